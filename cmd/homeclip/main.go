@@ -12,29 +12,86 @@ import (
 
 	"github.com/d6o/homeclip/internal/cleanup"
 	"github.com/d6o/homeclip/internal/clipboard"
+	"github.com/d6o/homeclip/internal/collab"
 	"github.com/d6o/homeclip/internal/config"
+	"github.com/d6o/homeclip/internal/events"
 	"github.com/d6o/homeclip/internal/filestore"
 	"github.com/d6o/homeclip/internal/server"
 )
 
 func main() {
-	cfg := config.NewConfig()
+	cfg, err := config.NewConfig()
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
 
 	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
 		slog.Error("failed to create data directory", "error", err)
 		os.Exit(1)
 	}
 
-	clipStore := clipboard.NewStore(cfg.DataDir)
+	bus := events.NewBus(256)
+
+	var clipRetention []clipboard.RetentionRule
+	var fileRetention []filestore.RetentionRule
+	for _, r := range cfg.RetentionRules {
+		if r.Kind == config.RetentionClips {
+			clipRetention = append(clipRetention, clipboard.RetentionRule{
+				Name:         r.Name,
+				Clips:        r.Clips,
+				MinSize:      r.MinSize,
+				MaxSize:      r.MaxSize,
+				ContentTypes: r.ContentTypes,
+				MaxAge:       r.MaxAge,
+			})
+			continue
+		}
+		fileRetention = append(fileRetention, filestore.RetentionRule{
+			Name:         r.Name,
+			MinSize:      r.MinSize,
+			MaxSize:      r.MaxSize,
+			Extensions:   r.Extensions,
+			ContentTypes: r.ContentTypes,
+			MaxAge:       r.MaxAge,
+		})
+	}
 
-	fileStore, err := filestore.NewStore(cfg.DataDir)
+	clipStore, err := clipboard.NewStore(cfg.DataDir, bus, clipboard.Options{
+		MaxTextSize:   cfg.MaxTextSize,
+		MaxFormatSize: cfg.MaxFormatSize,
+		Retention:     clipRetention,
+	})
+	if err != nil {
+		slog.Error("failed to create clipboard store", "error", err)
+		os.Exit(1)
+	}
+
+	fileStore, err := filestore.NewStore(cfg.DataDir, bus, filestore.Options{
+		OnConflict:  filestore.ConflictPolicy(cfg.FileConflict),
+		MaxFileSize: cfg.MaxFileSize,
+		Quota: filestore.Quota{
+			MaxBytes: cfg.StorageQuota,
+			MaxFiles: cfg.MaxFiles,
+			OnFull:   filestore.QuotaPolicy(cfg.QuotaPolicy),
+		},
+		Retention: fileRetention,
+	})
 	if err != nil {
 		slog.Error("failed to create file store", "error", err)
 		os.Exit(1)
 	}
 
-	cleaner := cleanup.NewCleaner(10*time.Minute, 24*time.Hour, clipStore, fileStore)
-	srv := server.NewServer(cfg.Port, clipStore, fileStore)
+	cleaner := cleanup.NewCleaner(cfg.CleanupInterval, bus,
+		cleanup.Target{Kind: "text", Store: clipStore, MaxAge: cfg.TextMaxAge},
+		cleanup.Target{Kind: "file", Store: fileStore, MaxAge: cfg.FileMaxAge},
+	)
+	hub := collab.NewHub(clipStore, bus, time.Second, cfg.MaxTextSize)
+	srv := server.NewServer(cfg.Port, clipStore, fileStore, bus, hub, cleaner, server.Limits{
+		MaxFileSize:   cfg.MaxFileSize,
+		MaxTextSize:   cfg.MaxTextSize,
+		MaxFormatSize: cfg.MaxFormatSize,
+	})
 
 	sigCtx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
@@ -45,6 +102,10 @@ func main() {
 		return cleaner.Run(gCtx)
 	})
 
+	g.Go(func() error {
+		return hub.Run(gCtx)
+	})
+
 	g.Go(func() error {
 		return srv.Run(gCtx)
 	})
@@ -2,4 +2,7 @@ module github.com/d6o/homeclip
 
 go 1.25.6
 
-require golang.org/x/sync v0.19.0
+require (
+	github.com/coder/websocket v1.8.14
+	golang.org/x/sync v0.19.0
+)
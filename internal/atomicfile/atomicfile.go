@@ -0,0 +1,134 @@
+// Package atomicfile writes files so that a crash leaves either the old or
+// the new content in place, never a truncated file.
+package atomicfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const tempPrefix = ".tmp-"
+
+// File is a temporary file that replaces its target on Commit.
+type File struct {
+	*os.File
+	path string
+	done bool
+}
+
+// Create starts a write to path. The temporary file is made in dir, which
+// must be on the same filesystem as path.
+func Create(dir, path string, perm os.FileMode) (*File, error) {
+	f, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return &File{File: f, path: path}, nil
+}
+
+// Commit flushes the file to disk and moves it over the target.
+func (f *File) Commit() error {
+	return f.CommitTo(f.path)
+}
+
+// CommitTo is Commit with a target chosen after the file was written. path
+// must be on the same filesystem as the one given to Create.
+func (f *File) CommitTo(path string) error {
+	if f.done {
+		return os.ErrClosed
+	}
+	f.done = true
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	syncDir(filepath.Dir(path))
+
+	return nil
+}
+
+// Abort discards the file. It does nothing after Commit, so it can be
+// deferred.
+func (f *File) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// WriteFile is os.WriteFile, but readers see either the old or the new
+// content.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	f, err := Create(filepath.Dir(path), path, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+
+	return f.Commit()
+}
+
+// Sweep removes temporary files left in dir by writes that never finished.
+// It must only run while nothing is writing to dir.
+func Sweep(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	var errs []error
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), tempPrefix) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// syncDir makes a rename durable. It is best effort: the new content is
+// already visible, and some filesystems cannot sync directories at all.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+
+	d.Sync()
+}
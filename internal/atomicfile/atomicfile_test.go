@@ -0,0 +1,136 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+
+	if err := WriteFile(path, []byte("first"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := WriteFile(path, []byte("second"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if string(data) != "second" {
+		t.Errorf("expected %q, got %q", "second", data)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0o644 {
+		t.Errorf("expected mode 0644, got %v", info.Mode().Perm())
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files left, got %d entries", len(entries))
+	}
+}
+
+func TestAbortKeepsOldContent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+
+	if err := WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	f, err := Create(dir, path, 0o644)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write([]byte("half a wri"))
+	f.Abort()
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "old" {
+		t.Errorf("expected old content kept, got %q", data)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected temporary file removed, got %d entries", len(entries))
+	}
+}
+
+func TestCommitTwice(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := Create(dir, filepath.Join(dir, "a"), 0o644)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := f.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := f.Commit(); err == nil {
+		t.Error("expected second Commit to fail")
+	}
+	f.Abort()
+
+	if _, err := os.Stat(filepath.Join(dir, "a")); err != nil {
+		t.Errorf("expected committed file to survive Abort, got %v", err)
+	}
+}
+
+func TestCommitTo(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := Create(dir, filepath.Join(dir, "a"), 0o644)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.WriteString("data")
+	if err := f.CommitTo(filepath.Join(dir, "b")); err != nil {
+		t.Fatalf("CommitTo failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("expected original target to be untouched, got %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "b"))
+	if err != nil || string(data) != "data" {
+		t.Errorf("expected data at new target, got %q, %v", data, err)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := Create(dir, filepath.Join(dir, "target"), 0o644)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Close() // simulate a crash before Commit
+
+	if err := os.WriteFile(filepath.Join(dir, "keep.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Sweep(dir); err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "keep.txt" {
+		t.Errorf("expected only keep.txt left, got %v", entries)
+	}
+}
+
+func TestSweepMissingDir(t *testing.T) {
+	if err := Sweep(filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Errorf("expected no error for a missing dir, got %v", err)
+	}
+}
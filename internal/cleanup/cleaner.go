@@ -3,47 +3,195 @@ package cleanup
 import (
 	"context"
 	"log/slog"
+	"sync"
 	"time"
+
+	"github.com/d6o/homeclip/internal/events"
 )
 
+// Result is what a store's Cleanup removed and what it could not.
+type Result struct {
+	Removed []string
+	Failed  []ItemError
+}
+
+// ItemError is an expired item that could not be removed.
+type ItemError struct {
+	Name string
+	Err  error
+}
+
+func (e ItemError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+func (e ItemError) Unwrap() error {
+	return e.Err
+}
+
 type cleanable interface {
-	Cleanup(ctx context.Context, maxAge time.Duration) error
+	// Cleanup removes expired items. The error is for failures that are
+	// not about a single item.
+	Cleanup(ctx context.Context, maxAge time.Duration) (Result, error)
+	Expired(ctx context.Context, maxAge time.Duration) ([]string, error)
+}
+
+type publisher interface {
+	Publish(t events.Type, data any)
+}
+
+type Target struct {
+	Kind  string
+	Store cleanable
+	// MaxAge is how long items without a TTL of their own are kept. Zero
+	// keeps them until they are deleted.
+	MaxAge time.Duration
+}
+
+type Expired struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// Failure is an error a target returned during a cycle, about the named
+// item if there is one.
+type Failure struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+// Report describes one cleanup cycle. In a dry run Removed lists what
+// would have been removed.
+type Report struct {
+	StartedAt time.Time `json:"startedAt"`
+	DryRun    bool      `json:"dryRun,omitempty"`
+	Removed   []Expired `json:"removed"`
+	Failures  []Failure `json:"failures"`
+}
+
+// Status is what the cleaner has done so far.
+type Status struct {
+	LastRun *Report `json:"lastRun,omitempty"`
+	// NextRun is when the next scheduled cycle starts, once Run is going.
+	NextRun       *time.Time `json:"nextRun,omitempty"`
+	TotalRemoved  int        `json:"totalRemoved"`
+	TotalFailures int        `json:"totalFailures"`
 }
 
 type Cleaner struct {
-	targets  []cleanable
+	targets  []Target
+	events   publisher
 	interval time.Duration
-	maxAge   time.Duration
+
+	// cycleMu keeps scheduled and manual cycles from overlapping.
+	cycleMu sync.Mutex
+
+	mu     sync.Mutex
+	status Status
 }
 
-func NewCleaner(interval, maxAge time.Duration, targets ...cleanable) *Cleaner {
+func NewCleaner(interval time.Duration, events publisher, targets ...Target) *Cleaner {
 	return &Cleaner{
 		targets:  targets,
+		events:   events,
 		interval: interval,
-		maxAge:   maxAge,
 	}
 }
 
+// Run cleans up once straight away and then every interval until ctx is
+// done.
 func (c *Cleaner) Run(ctx context.Context) error {
 	ticker := time.NewTicker(c.interval)
 	defer ticker.Stop()
 
+	c.setNextRun(time.Now().Add(c.interval))
+	c.RunNow(ctx)
+
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-ticker.C:
-			c.runCycle(ctx)
+		case t := <-ticker.C:
+			c.setNextRun(t.Add(c.interval))
+			c.RunNow(ctx)
 		}
 	}
 }
 
-func (c *Cleaner) runCycle(ctx context.Context) {
+// RunNow runs a cleanup cycle and waits for it to finish. It does not move
+// the next scheduled cycle.
+func (c *Cleaner) RunNow(ctx context.Context) Report {
+	c.cycleMu.Lock()
+	defer c.cycleMu.Unlock()
+
+	report := c.runCycle(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.status.LastRun = &report
+	c.status.TotalRemoved += len(report.Removed)
+	c.status.TotalFailures += len(report.Failures)
+
+	return report
+}
+
+// DryRun reports what a cycle would remove now without removing anything.
+func (c *Cleaner) DryRun(ctx context.Context) Report {
+	report := Report{StartedAt: time.Now(), DryRun: true, Removed: []Expired{}, Failures: []Failure{}}
+
 	for _, t := range c.targets {
-		if err := t.Cleanup(ctx, c.maxAge); err != nil {
-			slog.Error("cleanup failed", "error", err)
+		expired, err := t.Store.Expired(ctx, t.MaxAge)
+		if err != nil {
+			report.Failures = append(report.Failures, Failure{Kind: t.Kind, Error: err.Error()})
+		}
+
+		for _, name := range expired {
+			report.Removed = append(report.Removed, Expired{Kind: t.Kind, Name: name})
 		}
 	}
 
-	slog.Info("cleanup cycle completed")
+	return report
+}
+
+func (c *Cleaner) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.status
+}
+
+func (c *Cleaner) setNextRun(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.status.NextRun = &t
+}
+
+func (c *Cleaner) runCycle(ctx context.Context) Report {
+	report := Report{StartedAt: time.Now(), Removed: []Expired{}, Failures: []Failure{}}
+
+	for _, t := range c.targets {
+		res, err := t.Store.Cleanup(ctx, t.MaxAge)
+		if err != nil {
+			slog.Error("cleanup failed", "kind", t.Kind, "error", err)
+			report.Failures = append(report.Failures, Failure{Kind: t.Kind, Error: err.Error()})
+		}
+
+		for _, f := range res.Failed {
+			slog.Error("failed to remove expired item", "kind", t.Kind, "name", f.Name, "error", f.Err)
+			report.Failures = append(report.Failures, Failure{Kind: t.Kind, Name: f.Name, Error: f.Err.Error()})
+		}
+
+		for _, name := range res.Removed {
+			e := Expired{Kind: t.Kind, Name: name}
+			report.Removed = append(report.Removed, e)
+			c.events.Publish(events.ItemExpired, e)
+		}
+	}
+
+	slog.Info("cleanup cycle completed", "removed", len(report.Removed), "failures", len(report.Failures))
+
+	return report
 }
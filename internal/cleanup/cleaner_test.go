@@ -3,24 +3,40 @@ package cleanup
 import (
 	"context"
 	"errors"
+	"slices"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/d6o/homeclip/internal/events"
 )
 
 type mockCleanable struct {
-	mu       sync.Mutex
-	calls    int
-	maxAge   time.Duration
+	mu        sync.Mutex
+	calls     int
+	maxAge    time.Duration
+	removed   []string
+	failed    []ItemError
 	returnErr error
+
+	dryRuns int
+	expired []string
 }
 
-func (m *mockCleanable) Cleanup(_ context.Context, maxAge time.Duration) error {
+func (m *mockCleanable) Cleanup(_ context.Context, maxAge time.Duration) (Result, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.calls++
 	m.maxAge = maxAge
-	return m.returnErr
+	return Result{Removed: m.removed, Failed: m.failed}, m.returnErr
+}
+
+func (m *mockCleanable) Expired(_ context.Context, maxAge time.Duration) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dryRuns++
+	m.maxAge = maxAge
+	return m.expired, m.returnErr
 }
 
 func (m *mockCleanable) getCalls() int {
@@ -29,18 +45,26 @@ func (m *mockCleanable) getCalls() int {
 	return m.calls
 }
 
+type mockPublisher struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (m *mockPublisher) Publish(t events.Type, data any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, events.Event{Type: t, Data: data})
+}
+
 func TestNewCleaner(t *testing.T) {
 	m1 := &mockCleanable{}
 	m2 := &mockCleanable{}
 
-	c := NewCleaner(5*time.Minute, 24*time.Hour, m1, m2)
+	c := NewCleaner(5*time.Minute, &mockPublisher{}, Target{Kind: "a", Store: m1}, Target{Kind: "b", Store: m2})
 
 	if c.interval != 5*time.Minute {
 		t.Errorf("expected interval 5m, got %v", c.interval)
 	}
-	if c.maxAge != 24*time.Hour {
-		t.Errorf("expected maxAge 24h, got %v", c.maxAge)
-	}
 	if len(c.targets) != 2 {
 		t.Errorf("expected 2 targets, got %d", len(c.targets))
 	}
@@ -50,7 +74,10 @@ func TestCleaner_RunCycle(t *testing.T) {
 	m1 := &mockCleanable{}
 	m2 := &mockCleanable{}
 
-	c := NewCleaner(time.Minute, 2*time.Hour, m1, m2)
+	c := NewCleaner(time.Minute, &mockPublisher{},
+		Target{Kind: "a", Store: m1, MaxAge: 2 * time.Hour},
+		Target{Kind: "b", Store: m2, MaxAge: 0},
+	)
 	c.runCycle(context.Background())
 
 	if m1.getCalls() != 1 {
@@ -62,12 +89,16 @@ func TestCleaner_RunCycle(t *testing.T) {
 	if m1.maxAge != 2*time.Hour {
 		t.Errorf("expected maxAge 2h, got %v", m1.maxAge)
 	}
+	// Items with a TTL of their own still expire when there is no max age.
+	if m2.maxAge != 0 {
+		t.Errorf("expected maxAge 0, got %v", m2.maxAge)
+	}
 }
 
 func TestCleaner_RunCycleWithError(t *testing.T) {
 	m := &mockCleanable{returnErr: errors.New("cleanup error")}
 
-	c := NewCleaner(time.Minute, time.Hour, m)
+	c := NewCleaner(time.Minute, &mockPublisher{}, Target{Kind: "a", Store: m})
 	c.runCycle(context.Background())
 
 	if m.getCalls() != 1 {
@@ -75,9 +106,29 @@ func TestCleaner_RunCycleWithError(t *testing.T) {
 	}
 }
 
+func TestCleaner_RunCyclePublishesExpired(t *testing.T) {
+	m := &mockCleanable{removed: []string{"old.txt", "older.txt"}}
+	pub := &mockPublisher{}
+
+	c := NewCleaner(time.Minute, pub, Target{Kind: "file", Store: m})
+	c.runCycle(context.Background())
+
+	if len(pub.events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(pub.events))
+	}
+	for _, e := range pub.events {
+		if e.Type != events.ItemExpired {
+			t.Errorf("expected %q, got %q", events.ItemExpired, e.Type)
+		}
+	}
+	if got := pub.events[0].Data.(Expired); got != (Expired{Kind: "file", Name: "old.txt"}) {
+		t.Errorf("unexpected payload: %+v", got)
+	}
+}
+
 func TestCleaner_RunContextCancel(t *testing.T) {
 	m := &mockCleanable{}
-	c := NewCleaner(time.Hour, time.Hour, m)
+	c := NewCleaner(time.Hour, &mockPublisher{}, Target{Kind: "a", Store: m})
 
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
@@ -90,7 +141,7 @@ func TestCleaner_RunContextCancel(t *testing.T) {
 
 func TestCleaner_RunTicksAndCleans(t *testing.T) {
 	m := &mockCleanable{}
-	c := NewCleaner(10*time.Millisecond, time.Hour, m)
+	c := NewCleaner(10*time.Millisecond, &mockPublisher{}, Target{Kind: "a", Store: m})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
 	defer cancel()
@@ -101,3 +152,87 @@ func TestCleaner_RunTicksAndCleans(t *testing.T) {
 		t.Errorf("expected at least 1 cleanup call, got %d", m.getCalls())
 	}
 }
+
+func TestCleaner_RunsAtStartup(t *testing.T) {
+	m := &mockCleanable{}
+	c := NewCleaner(time.Hour, &mockPublisher{}, Target{Kind: "a", Store: m})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_ = c.Run(ctx)
+
+	if m.getCalls() != 1 {
+		t.Errorf("expected 1 cleanup call at startup, got %d", m.getCalls())
+	}
+
+	status := c.Status()
+	if status.LastRun == nil {
+		t.Fatal("expected the startup run to be reported")
+	}
+	if status.NextRun == nil || status.NextRun.Sub(status.LastRun.StartedAt) < 59*time.Minute {
+		t.Errorf("expected the next run an interval later, got %v", status.NextRun)
+	}
+}
+
+func TestCleaner_RunNowReportsStatus(t *testing.T) {
+	files := &mockCleanable{
+		removed: []string{"old.txt"},
+		failed:  []ItemError{{Name: "locked.txt", Err: errors.New("permission denied")}},
+	}
+	text := &mockCleanable{returnErr: errors.New("disk error")}
+	c := NewCleaner(time.Hour, &mockPublisher{},
+		Target{Kind: "file", Store: files},
+		Target{Kind: "text", Store: text},
+	)
+
+	if s := c.Status(); s.LastRun != nil || s.NextRun != nil {
+		t.Fatalf("expected an empty status before the first run, got %+v", s)
+	}
+
+	report := c.RunNow(context.Background())
+	if len(report.Removed) != 1 || report.Removed[0] != (Expired{Kind: "file", Name: "old.txt"}) {
+		t.Errorf("unexpected removed items: %+v", report.Removed)
+	}
+	wantFailures := []Failure{
+		{Kind: "file", Name: "locked.txt", Error: "permission denied"},
+		{Kind: "text", Error: "disk error"},
+	}
+	if !slices.Equal(report.Failures, wantFailures) {
+		t.Errorf("unexpected failures: %+v", report.Failures)
+	}
+
+	c.RunNow(context.Background())
+
+	status := c.Status()
+	if status.LastRun == nil || len(status.LastRun.Removed) != 1 {
+		t.Errorf("expected the last run to be reported, got %+v", status.LastRun)
+	}
+	if status.TotalRemoved != 2 || status.TotalFailures != 4 {
+		t.Errorf("expected 2 removed and 4 failures in total, got %d and %d", status.TotalRemoved, status.TotalFailures)
+	}
+}
+
+func TestCleaner_DryRun(t *testing.T) {
+	m := &mockCleanable{expired: []string{"old.txt"}}
+	pub := &mockPublisher{}
+	c := NewCleaner(time.Hour, pub, Target{Kind: "file", Store: m, MaxAge: time.Hour})
+
+	report := c.DryRun(context.Background())
+
+	if !report.DryRun {
+		t.Error("expected the report to be marked as a dry run")
+	}
+	if len(report.Removed) != 1 || report.Removed[0] != (Expired{Kind: "file", Name: "old.txt"}) {
+		t.Errorf("unexpected items: %+v", report.Removed)
+	}
+	if m.getCalls() != 0 || m.dryRuns != 1 || m.maxAge != time.Hour {
+		t.Errorf("expected only a dry run with the target max age, got %d cleanups, %d dry runs and %v", m.getCalls(), m.dryRuns, m.maxAge)
+	}
+	if len(pub.events) != 0 {
+		t.Errorf("expected no events, got %d", len(pub.events))
+	}
+	if c.Status().LastRun != nil {
+		t.Error("expected a dry run not to count as a run")
+	}
+}
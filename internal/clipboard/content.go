@@ -2,7 +2,73 @@ package clipboard
 
 import "time"
 
+const (
+	TypePlain = "text/plain"
+	TypeHTML  = "text/html"
+	TypePNG   = "image/png"
+)
+
 type Content struct {
+	Name      string    `json:"name"`
+	Revision  int64     `json:"revision"`
 	Content   string    `json:"content"`
+	Formats   []Format  `json:"formats,omitempty"`
 	UpdatedAt time.Time `json:"updatedAt"`
+
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	NeverExpires     bool       `json:"neverExpires,omitempty"`
+	BurnAfterReading bool       `json:"burnAfterReading,omitempty"`
+
+	// Pinned belongs to the clip rather than a revision: it carries over to
+	// new revisions and is never recorded in history.
+	Pinned bool `json:"pinned"`
+}
+
+// SetOptions are chosen by the writer and stored with the new revision.
+type SetOptions struct {
+	// TTL overrides the cleanup max age for this clip. Zero keeps the
+	// default and a negative TTL keeps the clip until it is deleted.
+	TTL time.Duration
+	// BurnAfterReading deletes the clip the first time it is read.
+	BurnAfterReading bool
+}
+
+// Expired reports whether a cleanup at now should remove the clip. Clips
+// written without a TTL fall back to maxAge; a zero maxAge keeps them.
+func (c Content) Expired(now time.Time, maxAge time.Duration) bool {
+	switch {
+	case c.Pinned, c.NeverExpires:
+		return false
+	case c.ExpiresAt != nil:
+		return now.After(*c.ExpiresAt)
+	default:
+		return maxAge > 0 && now.Sub(c.UpdatedAt) > maxAge
+	}
+}
+
+// redacted hides the payload of a burn-after-reading clip from everything
+// but Read.
+func (c Content) redacted() Content {
+	if c.BurnAfterReading {
+		c.Content = ""
+		c.Formats = nil
+	}
+	return c
+}
+
+// Format describes a non-plain-text representation of a clip. Its payload is
+// stored as a blob named by SHA256 next to the clip metadata.
+type Format struct {
+	Type   string `json:"type"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func (c Content) Format(mimeType string) (Format, bool) {
+	for _, f := range c.Formats {
+		if f.Type == mimeType {
+			return f, true
+		}
+	}
+	return Format{}, false
 }
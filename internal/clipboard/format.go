@@ -0,0 +1,203 @@
+package clipboard
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/d6o/homeclip/internal/atomicfile"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported clip format")
+	ErrFormatNotFound    = errors.New("clip format not found")
+	ErrTooLarge          = errors.New("clip format exceeds the size limit")
+)
+
+func supportedFormat(mimeType string) bool {
+	switch mimeType {
+	case TypePlain, TypeHTML, TypePNG:
+		return true
+	}
+	return false
+}
+
+// SetFormat stores one representation of a clip as a new revision, keeping
+// the other representations and the expiry of the current revision. Plain
+// text is held to the text limit and the others to the format limit.
+func (s *Store) SetFormat(_ context.Context, name, mimeType string, r io.Reader) (Content, error) {
+	if !supportedFormat(mimeType) {
+		return Content{}, ErrUnsupportedFormat
+	}
+
+	p, err := s.paths(name)
+	if err != nil {
+		return Content{}, err
+	}
+
+	limit, errTooLarge := s.maxFormat, ErrTooLarge
+	if mimeType == TypePlain {
+		limit, errTooLarge = s.maxText, ErrTextTooLarge
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return Content{}, err
+	}
+	if int64(len(data)) > limit {
+		return Content{}, errTooLarge
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := readCurrent(p, name)
+	if err != nil && !errors.Is(err, ErrEmpty) {
+		return Content{}, err
+	}
+
+	next := carryOver(current, mimeType)
+	if mimeType == TypePlain {
+		next.Content = string(data)
+	} else {
+		f, err := s.writeBlob(mimeType, data)
+		if err != nil {
+			return Content{}, err
+		}
+		next.Formats = append(next.Formats, f)
+	}
+
+	return s.set(p, name, next, SetOptions{})
+}
+
+// carryOver starts a revision that replaces one representation of current,
+// keeping its expiry and every representation but mimeType. A new
+// representation replaces an unread secret instead of adding to it.
+func carryOver(current Content, mimeType string) Content {
+	if current.BurnAfterReading {
+		return Content{}
+	}
+
+	next := Content{
+		Content:      current.Content,
+		ExpiresAt:    current.ExpiresAt,
+		NeverExpires: current.NeverExpires,
+	}
+	for _, f := range current.Formats {
+		if f.Type != mimeType {
+			next.Formats = append(next.Formats, f)
+		}
+	}
+
+	return next
+}
+
+// OpenFormat returns the payload of one representation of the current
+// revision of a clip.
+func (s *Store) OpenFormat(_ context.Context, name, mimeType string) (io.ReadCloser, error) {
+	p, err := s.paths(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, err := readCurrent(p, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.BurnAfterReading {
+		return nil, ErrFormatNotFound
+	}
+
+	if mimeType == TypePlain {
+		return io.NopCloser(strings.NewReader(c.Content)), nil
+	}
+
+	f, ok := c.Format(mimeType)
+	if !ok {
+		return nil, ErrFormatNotFound
+	}
+
+	// The blob is opened while holding the lock so a concurrent cleanup
+	// cannot remove it in between; the open handle stays readable after that.
+	return os.Open(filepath.Join(s.blobsDir, f.SHA256))
+}
+
+func (s *Store) writeBlob(mimeType string, data []byte) (Format, error) {
+	sum := sha256.Sum256(data)
+	f := Format{
+		Type:   mimeType,
+		Size:   int64(len(data)),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+
+	if err := os.MkdirAll(s.blobsDir, 0o755); err != nil {
+		return Format{}, err
+	}
+
+	path := filepath.Join(s.blobsDir, f.SHA256)
+	if _, err := os.Stat(path); err == nil {
+		return f, nil
+	}
+
+	return f, atomicfile.WriteFile(path, data, 0o644)
+}
+
+// collectBlobs removes blobs that are no longer referenced by any revision
+// of any clip. The caller must hold the write lock.
+func (s *Store) collectBlobs() error {
+	entries, err := os.ReadDir(s.blobsDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	names, err := s.names()
+	if err != nil {
+		return err
+	}
+
+	referenced := make(map[string]bool)
+	for _, name := range names {
+		p, _ := s.paths(name)
+
+		revisions, err := readHistory(p, name)
+		if err != nil {
+			return err
+		}
+
+		current, err := readCurrent(p, name)
+		if err != nil && !errors.Is(err, ErrEmpty) {
+			return err
+		}
+		revisions = append(revisions, current)
+
+		for _, rev := range revisions {
+			for _, f := range rev.Formats {
+				referenced[f.SHA256] = true
+			}
+		}
+	}
+
+	var errs []error
+	for _, e := range entries {
+		if referenced[e.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.blobsDir, e.Name())); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,98 @@
+package clipboard
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// RetentionRule keeps the clips it matches for MaxAge instead of the
+// cleanup default. A clip matches when it meets every criterion the rule
+// sets; criteria left empty match any clip.
+type RetentionRule struct {
+	Name string
+	// Clips lists the clip names the rule applies to.
+	Clips []string
+	// MinSize and MaxSize bound the size of the text and formats together,
+	// in bytes, inclusive. Zero is no bound.
+	MinSize int64
+	MaxSize int64
+	// ContentTypes such as "image/png" or "image/*". A clip matches if it
+	// holds any of them; text counts as text/plain.
+	ContentTypes []string
+	// MaxAge is how long matching clips are kept. Zero keeps them until
+	// they are deleted.
+	MaxAge time.Duration
+}
+
+// Rule names reported for clips that no RetentionRule decided on.
+const (
+	ruleTTL     = "ttl"
+	ruleDefault = "default"
+)
+
+func (r RetentionRule) matches(c Content) bool {
+	if len(r.Clips) > 0 && !slices.Contains(r.Clips, c.Name) {
+		return false
+	}
+
+	size := int64(len(c.Content))
+	for _, f := range c.Formats {
+		size += f.Size
+	}
+	if r.MinSize > 0 && size < r.MinSize {
+		return false
+	}
+	if r.MaxSize > 0 && size > r.MaxSize {
+		return false
+	}
+
+	if len(r.ContentTypes) > 0 && !matchContentType(r.ContentTypes, c) {
+		return false
+	}
+	return true
+}
+
+// matchContentType reports whether the clip holds any of the types. A
+// pattern ending in /* matches the whole type, as in image/*.
+func matchContentType(patterns []string, c Content) bool {
+	types := make([]string, 0, len(c.Formats)+1)
+	if c.Content != "" {
+		types = append(types, TypePlain)
+	}
+	for _, f := range c.Formats {
+		types = append(types, f.Type)
+	}
+
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		for _, t := range types {
+			if prefix, ok := strings.CutSuffix(p, "*"); ok {
+				if strings.HasPrefix(t, prefix) {
+					return true
+				}
+			} else if t == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expiry reports whether a cleanup at now should remove the clip or
+// revision and the rule that decided it. The first matching retention rule
+// replaces maxAge; a TTL chosen by the writer and pinning still take
+// precedence.
+func (s *Store) expiry(c Content, now time.Time, maxAge time.Duration) (string, bool) {
+	if c.ExpiresAt != nil {
+		return ruleTTL, c.Expired(now, maxAge)
+	}
+
+	for _, r := range s.retention {
+		if r.matches(c) {
+			return r.Name, c.Expired(now, r.MaxAge)
+		}
+	}
+
+	return ruleDefault, c.Expired(now, maxAge)
+}
@@ -0,0 +1,95 @@
+package clipboard
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRetentionRule_Matches(t *testing.T) {
+	note := Content{Name: "scratch", Content: "hello"}
+	image := Content{Name: DefaultClip, Formats: []Format{{Type: TypePNG, Size: 2 << 20}}}
+
+	tests := []struct {
+		name string
+		rule RetentionRule
+		clip Content
+		want bool
+	}{
+		{"empty rule", RetentionRule{}, note, true},
+		{"clip name", RetentionRule{Clips: []string{"scratch"}}, note, true},
+		{"other clip name", RetentionRule{Clips: []string{"scratch"}}, image, false},
+		{"text type", RetentionRule{ContentTypes: []string{"text/plain"}}, note, true},
+		{"format type wildcard", RetentionRule{ContentTypes: []string{"image/*"}}, image, true},
+		{"missing type", RetentionRule{ContentTypes: []string{"image/*"}}, note, false},
+		{"min size counts formats", RetentionRule{MinSize: 1 << 20}, image, true},
+		{"below min size", RetentionRule{MinSize: 1 << 20}, note, false},
+		{"above max size", RetentionRule{MaxSize: 1 << 20}, image, false},
+		{"one criterion fails", RetentionRule{Clips: []string{"scratch"}, MinSize: 1 << 20}, note, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.matches(tt.clip); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStore_CleanupRetentionRules(t *testing.T) {
+	s, err := NewStore(t.TempDir(), &mockPublisher{}, Options{
+		Retention: []RetentionRule{
+			{Name: "scratch", Clips: []string{"scratch"}, MaxAge: time.Hour},
+			{Name: "keep-notes", Clips: []string{"notes"}, MaxAge: 0},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	ctx := context.Background()
+	if err := os.MkdirAll(s.clipsDir, 0o755); err != nil {
+		t.Fatalf("failed to create clips dir: %v", err)
+	}
+
+	for name, age := range map[string]time.Duration{
+		"scratch": 2 * time.Hour,
+		"notes":   365 * 24 * time.Hour,
+		"other":   2 * time.Hour,
+		"old":     2 * 24 * time.Hour,
+	} {
+		p, err := s.paths(name)
+		if err != nil {
+			t.Fatalf("paths failed: %v", err)
+		}
+		data, _ := json.Marshal(Content{Content: name, Revision: 1, UpdatedAt: time.Now().Add(-age)})
+		if err := os.WriteFile(p.content, data, 0o644); err != nil {
+			t.Fatalf("failed to write clip: %v", err)
+		}
+	}
+
+	expired, err := s.Expired(ctx, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Expired failed: %v", err)
+	}
+	slices.Sort(expired)
+
+	res, err := s.Cleanup(ctx, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	slices.Sort(res.Removed)
+
+	// scratch is past its hour, notes are kept for good and the rest fall
+	// back to the default max age.
+	want := []string{"old", "scratch"}
+	if !slices.Equal(expired, want) {
+		t.Errorf("expected %v to have expired, got %v", want, expired)
+	}
+	if !slices.Equal(res.Removed, want) {
+		t.Errorf("expected %v removed, got %v", want, res.Removed)
+	}
+}
@@ -4,82 +4,668 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/d6o/homeclip/internal/atomicfile"
+	"github.com/d6o/homeclip/internal/cleanup"
+	"github.com/d6o/homeclip/internal/events"
+)
+
+const (
+	DefaultClip = "default"
+	maxHistory  = 50
+
+	defaultMaxTextSize   = 1024 * 1024      // 1 MB
+	defaultMaxFormatSize = 10 * 1024 * 1024 // 10 MB
+
+	historySuffix = ".history.json"
+	contentSuffix = ".json"
 )
 
-var ErrEmpty = errors.New("clipboard is empty")
+var (
+	ErrEmpty            = errors.New("clipboard is empty")
+	ErrRevisionNotFound = errors.New("revision not found")
+	ErrInvalidName      = errors.New("invalid clip name")
+	ErrConflict         = errors.New("clip was modified concurrently")
+	ErrTextTooLarge     = errors.New("clip text exceeds the size limit")
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type clipPaths struct {
+	content string
+	history string
+}
+
+type publisher interface {
+	Publish(t events.Type, data any)
+}
 
 type Store struct {
-	filePath string
-	mu       sync.RWMutex
+	dataDir   string
+	clipsDir  string
+	blobsDir  string
+	events    publisher
+	maxText   int64
+	maxFormat int64
+	retention []RetentionRule
+	mu        sync.RWMutex
+}
+
+// Options configure a Store.
+type Options struct {
+	// MaxTextSize is the largest clip text accepted, in bytes. It defaults
+	// to 1 MB.
+	MaxTextSize int64
+	// MaxFormatSize is the largest HTML or image representation accepted,
+	// in bytes. It defaults to 10 MB.
+	MaxFormatSize int64
+	// Retention gives clips matching a rule their own max age. The first
+	// matching rule applies.
+	Retention []RetentionRule
 }
 
-func NewStore(dataDir string) *Store {
-	return &Store{
-		filePath: filepath.Join(dataDir, "clipboard.json"),
+func NewStore(dataDir string, events publisher, opts Options) (*Store, error) {
+	s := &Store{
+		dataDir:   dataDir,
+		clipsDir:  filepath.Join(dataDir, "clips"),
+		blobsDir:  filepath.Join(dataDir, "clipblobs"),
+		events:    events,
+		maxText:   opts.MaxTextSize,
+		maxFormat: opts.MaxFormatSize,
+		retention: opts.Retention,
+	}
+	if s.maxText <= 0 {
+		s.maxText = defaultMaxTextSize
+	}
+	if s.maxFormat <= 0 {
+		s.maxFormat = defaultMaxFormatSize
 	}
+
+	for _, dir := range []string{s.dataDir, s.clipsDir, s.blobsDir} {
+		if err := atomicfile.Sweep(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
 }
 
-func (s *Store) Get(_ context.Context) (Content, error) {
+func (s *Store) Get(_ context.Context, name string) (Content, error) {
+	p, err := s.paths(name)
+	if err != nil {
+		return Content{}, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	data, err := os.ReadFile(s.filePath)
+	c, err := readCurrent(p, name)
+	return c.redacted(), err
+}
+
+// Read is Get for a reader who wants the payload. A burn-after-reading clip
+// is returned in full and deleted in the same step, so only one reader ever
+// sees it.
+func (s *Store) Read(_ context.Context, name string) (Content, error) {
+	p, err := s.paths(name)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return Content{}, ErrEmpty
-		}
 		return Content{}, err
 	}
 
-	var c Content
-	if err := json.Unmarshal(data, &c); err != nil {
+	s.mu.RLock()
+	c, err := readCurrent(p, name)
+	s.mu.RUnlock()
+
+	if err != nil || !c.BurnAfterReading {
+		return c, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Someone else may have read or replaced it while the lock was released.
+	c, err = readCurrent(p, name)
+	if err != nil || !c.BurnAfterReading {
+		return c, err
+	}
+
+	// Only the secret goes. set never puts an unread secret in history, so
+	// the revisions before it are kept.
+	if err := os.Remove(p.content); err != nil {
 		return Content{}, err
 	}
 
+	s.events.Publish(events.TextUpdated, Content{Name: name})
+
 	return c, nil
 }
 
-func (s *Store) Set(_ context.Context, content string) error {
+func (s *Store) Set(_ context.Context, name, content string, opts SetOptions) (Content, error) {
+	p, err := s.paths(name)
+	if err != nil {
+		return Content{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	c := Content{
-		Content:   content,
-		UpdatedAt: time.Now(),
+	return s.set(p, name, Content{Content: content}, opts)
+}
+
+// CompareAndSet writes content only if the clip is still at revision; a
+// missing clip is at revision 0. On mismatch the current content is returned.
+func (s *Store) CompareAndSet(_ context.Context, name string, revision int64, content string, opts SetOptions) (Content, error) {
+	p, err := s.paths(name)
+	if err != nil {
+		return Content{}, err
 	}
 
-	data, err := json.Marshal(c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := readCurrent(p, name)
+	if err != nil && !errors.Is(err, ErrEmpty) {
+		return Content{}, err
+	}
+
+	if current.Revision != revision {
+		return current.redacted(), ErrConflict
+	}
+
+	return s.set(p, name, Content{Content: content}, opts)
+}
+
+// SetIfExists writes content only if the clip has been written before, as
+// HTTP's If-Match: * asks. A missing clip fails with ErrConflict.
+func (s *Store) SetIfExists(_ context.Context, name, content string, opts SetOptions) (Content, error) {
+	p, err := s.paths(name)
 	if err != nil {
-		return err
+		return Content{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := readCurrent(p, name)
+	if errors.Is(err, ErrEmpty) || (err == nil && current.Revision == 0) {
+		return current.redacted(), ErrConflict
+	}
+	if err != nil {
+		return Content{}, err
 	}
 
-	return os.WriteFile(s.filePath, data, 0o644)
+	return s.set(p, name, Content{Content: content}, opts)
 }
 
-func (s *Store) Cleanup(_ context.Context, maxAge time.Duration) error {
+// CompareAndSetText is CompareAndSet for an edit of the plain text alone.
+// Like SetFormat, it keeps the other representations and the expiry of the
+// current revision.
+func (s *Store) CompareAndSetText(_ context.Context, name string, revision int64, text string) (Content, error) {
+	p, err := s.paths(name)
+	if err != nil {
+		return Content{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	data, err := os.ReadFile(s.filePath)
+	current, err := readCurrent(p, name)
+	if err != nil && !errors.Is(err, ErrEmpty) {
+		return Content{}, err
+	}
+
+	if current.Revision != revision {
+		return current.redacted(), ErrConflict
+	}
+
+	next := carryOver(current, TypePlain)
+	next.Content = text
+
+	return s.set(p, name, next, SetOptions{})
+}
+
+func (s *Store) List(_ context.Context) ([]Content, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names, err := s.names()
+	if err != nil {
+		return nil, err
+	}
+
+	clips := make([]Content, 0, len(names))
+	for _, name := range names {
+		p, _ := s.paths(name)
+
+		c, err := readCurrent(p, name)
+		if err != nil {
+			if errors.Is(err, ErrEmpty) {
+				continue
+			}
+			return nil, err
+		}
+
+		clips = append(clips, c.redacted())
+	}
+
+	return clips, nil
+}
+
+func (s *Store) Delete(_ context.Context, name string) error {
+	p, err := s.paths(name)
 	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.delete(p, name)
+}
+
+func (s *Store) delete(p clipPaths, name string) error {
+	if err := os.Remove(p.content); err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return nil
+			return ErrEmpty
 		}
 		return err
 	}
 
+	if err := writeHistory(p, nil); err != nil {
+		return err
+	}
+
+	s.events.Publish(events.TextUpdated, Content{Name: name})
+
+	return nil
+}
+
+// Pin marks a clip so that cleanup never removes it, or clears the mark. It
+// does not create a new revision.
+func (s *Store) Pin(_ context.Context, name string, pinned bool) (Content, error) {
+	p, err := s.paths(name)
+	if err != nil {
+		return Content{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, err := readCurrent(p, name)
+	if err != nil {
+		return Content{}, err
+	}
+
+	c.Pinned = pinned
+	if err := writeCurrent(p, c); err != nil {
+		return Content{}, err
+	}
+
+	s.events.Publish(events.TextUpdated, c.redacted())
+
+	return c.redacted(), nil
+}
+
+func (s *Store) History(_ context.Context, name string) ([]Content, error) {
+	p, err := s.paths(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return readHistory(p, name)
+}
+
+func (s *Store) Revision(_ context.Context, name string, id int64) (Content, error) {
+	p, err := s.paths(name)
+	if err != nil {
+		return Content{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return findRevision(p, name, id)
+}
+
+func (s *Store) Restore(_ context.Context, name string, id int64) (Content, error) {
+	p, err := s.paths(name)
+	if err != nil {
+		return Content{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rev, err := findRevision(p, name, id)
+	if err != nil {
+		return Content{}, err
+	}
+
+	return s.set(p, name, Content{Content: rev.Content, Formats: rev.Formats}, SetOptions{})
+}
+
+// Cleanup removes expired clips and history revisions. A clip that cannot
+// be checked or cleaned up is reported in the result.
+func (s *Store) Cleanup(_ context.Context, maxAge time.Duration) (cleanup.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.names()
+	if err != nil {
+		return cleanup.Result{}, err
+	}
+
+	var res cleanup.Result
+	now := time.Now()
+	for _, name := range names {
+		p, _ := s.paths(name)
+
+		rule, expired, err := s.cleanupClip(p, name, now, maxAge)
+		if err != nil {
+			res.Failed = append(res.Failed, cleanup.ItemError{Name: name, Err: err})
+		}
+		if expired {
+			slog.Info("clip expired", "name", name, "rule", rule)
+			res.Removed = append(res.Removed, name)
+		}
+	}
+
+	return res, s.collectBlobs()
+}
+
+// Expired lists the clips Cleanup would remove, without removing them.
+// Expired history revisions are not reported.
+func (s *Store) Expired(_ context.Context, maxAge time.Duration) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names, err := s.names()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []string
+	var errs []error
+	now := time.Now()
+	for _, name := range names {
+		p, _ := s.paths(name)
+
+		c, err := readCurrent(p, name)
+		switch {
+		case errors.Is(err, ErrEmpty):
+		case err != nil:
+			errs = append(errs, err)
+		default:
+			if _, ok := s.expiry(c, now, maxAge); ok {
+				expired = append(expired, name)
+			}
+		}
+	}
+
+	return expired, errors.Join(errs...)
+}
+
+// cleanupClip removes the clip and its history revisions if they expired,
+// and reports the rule that removed the clip.
+func (s *Store) cleanupClip(p clipPaths, name string, now time.Time, maxAge time.Duration) (string, bool, error) {
+	c, err := readCurrent(p, name)
+	if err != nil && !errors.Is(err, ErrEmpty) {
+		return "", false, err
+	}
+
+	var rule string
+	expired := false
+	if err == nil {
+		rule, expired = s.expiry(c, now, maxAge)
+	}
+	if expired {
+		if err := os.Remove(p.content); err != nil {
+			return rule, false, err
+		}
+	}
+
+	history, err := readHistory(p, name)
+	if err != nil {
+		return rule, expired, err
+	}
+
+	kept := history[:0]
+	for _, h := range history {
+		if _, old := s.expiry(h, now, maxAge); !old {
+			kept = append(kept, h)
+		}
+	}
+
+	if len(kept) == len(history) {
+		return rule, expired, nil
+	}
+
+	return rule, expired, writeHistory(p, kept)
+}
+
+func (s *Store) paths(name string) (clipPaths, error) {
+	if name == DefaultClip {
+		return clipPaths{
+			content: filepath.Join(s.dataDir, "clipboard.json"),
+			history: filepath.Join(s.dataDir, "clipboard_history.json"),
+		}, nil
+	}
+
+	if !validName.MatchString(name) {
+		return clipPaths{}, ErrInvalidName
+	}
+
+	return clipPaths{
+		content: filepath.Join(s.clipsDir, name+contentSuffix),
+		history: filepath.Join(s.clipsDir, name+historySuffix),
+	}, nil
+}
+
+func (s *Store) names() ([]string, error) {
+	seen := map[string]bool{DefaultClip: true}
+	names := []string{DefaultClip}
+
+	entries, err := os.ReadDir(s.clipsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		name := strings.TrimSuffix(e.Name(), historySuffix)
+		name = strings.TrimSuffix(name, contentSuffix)
+		if seen[name] || !validName.MatchString(name) {
+			continue
+		}
+
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	sort.Strings(names[1:])
+
+	return names, nil
+}
+
+func (s *Store) set(p clipPaths, name string, value Content, opts SetOptions) (Content, error) {
+	if int64(len(value.Content)) > s.maxText {
+		return Content{}, ErrTextTooLarge
+	}
+
+	history, err := readHistory(p, name)
+	if err != nil {
+		return Content{}, err
+	}
+
+	var next int64
+	if len(history) > 0 {
+		next = history[0].Revision
+	}
+
+	var pinned bool
+
+	current, err := readCurrent(p, name)
+	switch {
+	case err == nil:
+		if current.Revision > next {
+			next = current.Revision
+		}
+		if current.Revision == 0 {
+			next++
+			current.Revision = next
+		}
+
+		pinned = current.Pinned
+		current.Pinned = false
+
+		// An unread secret is dropped rather than kept in history.
+		if !current.BurnAfterReading {
+			history = append([]Content{current}, history...)
+			if len(history) > maxHistory {
+				history = history[:maxHistory]
+			}
+
+			if err := writeHistory(p, history); err != nil {
+				return Content{}, err
+			}
+		}
+	case !errors.Is(err, ErrEmpty):
+		return Content{}, err
+	}
+
+	c := Content{
+		Name:      name,
+		Revision:  next + 1,
+		Content:   value.Content,
+		Formats:   value.Formats,
+		UpdatedAt: time.Now(),
+
+		ExpiresAt:        value.ExpiresAt,
+		NeverExpires:     value.NeverExpires,
+		BurnAfterReading: opts.BurnAfterReading,
+		Pinned:           pinned,
+	}
+
+	// Without a TTL the new revision keeps whatever expiry value carries.
+	switch {
+	case opts.TTL < 0:
+		c.NeverExpires = true
+	case opts.TTL > 0:
+		expiresAt := c.UpdatedAt.Add(opts.TTL)
+		c.ExpiresAt = &expiresAt
+	}
+
+	if err := writeCurrent(p, c); err != nil {
+		return Content{}, err
+	}
+
+	s.events.Publish(events.TextUpdated, c.redacted())
+
+	return c, nil
+}
+
+func findRevision(p clipPaths, name string, id int64) (Content, error) {
+	current, err := readCurrent(p, name)
+	if err != nil && !errors.Is(err, ErrEmpty) {
+		return Content{}, err
+	}
+
+	if err == nil && current.Revision == id && !current.BurnAfterReading {
+		return current, nil
+	}
+
+	history, err := readHistory(p, name)
+	if err != nil {
+		return Content{}, err
+	}
+
+	for _, h := range history {
+		if h.Revision == id {
+			return h, nil
+		}
+	}
+
+	return Content{}, ErrRevisionNotFound
+}
+
+func readCurrent(p clipPaths, name string) (Content, error) {
+	data, err := os.ReadFile(p.content)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Content{}, ErrEmpty
+		}
+		return Content{}, err
+	}
+
 	var c Content
 	if err := json.Unmarshal(data, &c); err != nil {
+		return Content{}, err
+	}
+	c.Name = name
+
+	return c, nil
+}
+
+func writeCurrent(p clipPaths, c Content) error {
+	data, err := json.Marshal(c)
+	if err != nil {
 		return err
 	}
 
-	if time.Since(c.UpdatedAt) > maxAge {
-		return os.Remove(s.filePath)
+	if err := os.MkdirAll(filepath.Dir(p.content), 0o755); err != nil {
+		return err
 	}
 
-	return nil
+	return atomicfile.WriteFile(p.content, data, 0o644)
+}
+
+func readHistory(p clipPaths, name string) ([]Content, error) {
+	data, err := os.ReadFile(p.history)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var history []Content
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, err
+	}
+
+	for i := range history {
+		history[i].Name = name
+	}
+
+	return history, nil
+}
+
+func writeHistory(p clipPaths, history []Content) error {
+	if len(history) == 0 {
+		if err := os.Remove(p.history); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(p.history, data, 0o644)
 }
@@ -1,26 +1,96 @@
 package clipboard
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/d6o/homeclip/internal/events"
 )
 
 func TestNewStore(t *testing.T) {
-	s := NewStore("/tmp/testdata")
-	if s.filePath != filepath.Join("/tmp/testdata", "clipboard.json") {
-		t.Errorf("unexpected file path: %s", s.filePath)
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	p, err := s.paths(DefaultClip)
+	if err != nil {
+		t.Fatalf("paths failed: %v", err)
+	}
+	if p.content != filepath.Join(dir, "clipboard.json") {
+		t.Errorf("unexpected file path: %s", p.content)
+	}
+}
+
+func TestNewStore_SweepsInterruptedWrites(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	if _, err := s.Set(ctx, "work", "saved", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	// A crash halfway through a write leaves only a temporary file behind.
+	for _, d := range []string{dir, s.clipsDir} {
+		if err := os.WriteFile(filepath.Join(d, ".tmp-123"), []byte(`{"cont`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s = newTestStore(t, dir, &mockPublisher{})
+
+	for _, d := range []string{dir, s.clipsDir} {
+		if _, err := os.Stat(filepath.Join(d, ".tmp-123")); !os.IsNotExist(err) {
+			t.Errorf("expected temporary file in %s swept, got %v", d, err)
+		}
 	}
+
+	c, err := s.Get(ctx, "work")
+	if err != nil || c.Content != "saved" {
+		t.Errorf("expected saved clip intact, got %+v, %v", c, err)
+	}
+}
+
+type mockPublisher struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (m *mockPublisher) Publish(t events.Type, data any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, events.Event{Type: t, Data: data})
+}
+
+func newTestStore(t *testing.T, dir string, pub publisher) *Store {
+	t.Helper()
+	s, err := NewStore(dir, pub, Options{})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	return s
+}
+
+func defaultPaths(t *testing.T, s *Store) clipPaths {
+	t.Helper()
+	p, err := s.paths(DefaultClip)
+	if err != nil {
+		t.Fatalf("paths failed: %v", err)
+	}
+	return p
 }
 
 func TestStore_GetEmpty(t *testing.T) {
 	dir := t.TempDir()
-	s := NewStore(dir)
+	s := newTestStore(t, dir, &mockPublisher{})
 
-	_, err := s.Get(context.Background())
+	_, err := s.Get(context.Background(), DefaultClip)
 	if err != ErrEmpty {
 		t.Errorf("expected ErrEmpty, got %v", err)
 	}
@@ -28,14 +98,14 @@ func TestStore_GetEmpty(t *testing.T) {
 
 func TestStore_SetAndGet(t *testing.T) {
 	dir := t.TempDir()
-	s := NewStore(dir)
+	s := newTestStore(t, dir, &mockPublisher{})
 	ctx := context.Background()
 
-	if err := s.Set(ctx, "hello world"); err != nil {
+	if _, err := s.Set(ctx, DefaultClip, "hello world", SetOptions{}); err != nil {
 		t.Fatalf("Set failed: %v", err)
 	}
 
-	c, err := s.Get(ctx)
+	c, err := s.Get(ctx, DefaultClip)
 	if err != nil {
 		t.Fatalf("Get failed: %v", err)
 	}
@@ -51,18 +121,18 @@ func TestStore_SetAndGet(t *testing.T) {
 
 func TestStore_SetOverwrite(t *testing.T) {
 	dir := t.TempDir()
-	s := NewStore(dir)
+	s := newTestStore(t, dir, &mockPublisher{})
 	ctx := context.Background()
 
-	if err := s.Set(ctx, "first"); err != nil {
+	if _, err := s.Set(ctx, DefaultClip, "first", SetOptions{}); err != nil {
 		t.Fatalf("Set failed: %v", err)
 	}
 
-	if err := s.Set(ctx, "second"); err != nil {
+	if _, err := s.Set(ctx, DefaultClip, "second", SetOptions{}); err != nil {
 		t.Fatalf("Set failed: %v", err)
 	}
 
-	c, err := s.Get(ctx)
+	c, err := s.Get(ctx, DefaultClip)
 	if err != nil {
 		t.Fatalf("Get failed: %v", err)
 	}
@@ -74,13 +144,13 @@ func TestStore_SetOverwrite(t *testing.T) {
 
 func TestStore_GetInvalidJSON(t *testing.T) {
 	dir := t.TempDir()
-	s := NewStore(dir)
+	s := newTestStore(t, dir, &mockPublisher{})
 
-	if err := os.WriteFile(s.filePath, []byte("not json"), 0o644); err != nil {
+	if err := os.WriteFile(defaultPaths(t, s).content, []byte("not json"), 0o644); err != nil {
 		t.Fatalf("failed to write file: %v", err)
 	}
 
-	_, err := s.Get(context.Background())
+	_, err := s.Get(context.Background(), DefaultClip)
 	if err == nil {
 		t.Error("expected error for invalid JSON, got nil")
 	}
@@ -88,9 +158,9 @@ func TestStore_GetInvalidJSON(t *testing.T) {
 
 func TestStore_CleanupNoFile(t *testing.T) {
 	dir := t.TempDir()
-	s := NewStore(dir)
+	s := newTestStore(t, dir, &mockPublisher{})
 
-	err := s.Cleanup(context.Background(), time.Hour)
+	_, err := s.Cleanup(context.Background(), time.Hour)
 	if err != nil {
 		t.Errorf("expected nil error, got %v", err)
 	}
@@ -98,18 +168,18 @@ func TestStore_CleanupNoFile(t *testing.T) {
 
 func TestStore_CleanupFresh(t *testing.T) {
 	dir := t.TempDir()
-	s := NewStore(dir)
+	s := newTestStore(t, dir, &mockPublisher{})
 	ctx := context.Background()
 
-	if err := s.Set(ctx, "keep me"); err != nil {
+	if _, err := s.Set(ctx, DefaultClip, "keep me", SetOptions{}); err != nil {
 		t.Fatalf("Set failed: %v", err)
 	}
 
-	if err := s.Cleanup(ctx, time.Hour); err != nil {
+	if _, err := s.Cleanup(ctx, time.Hour); err != nil {
 		t.Fatalf("Cleanup failed: %v", err)
 	}
 
-	c, err := s.Get(ctx)
+	c, err := s.Get(ctx, DefaultClip)
 	if err != nil {
 		t.Fatalf("Get failed after cleanup: %v", err)
 	}
@@ -121,7 +191,7 @@ func TestStore_CleanupFresh(t *testing.T) {
 
 func TestStore_CleanupExpired(t *testing.T) {
 	dir := t.TempDir()
-	s := NewStore(dir)
+	s := newTestStore(t, dir, &mockPublisher{})
 	ctx := context.Background()
 
 	old := Content{
@@ -129,30 +199,1010 @@ func TestStore_CleanupExpired(t *testing.T) {
 		UpdatedAt: time.Now().Add(-2 * time.Hour),
 	}
 	data, _ := json.Marshal(old)
-	if err := os.WriteFile(s.filePath, data, 0o644); err != nil {
+	if err := os.WriteFile(defaultPaths(t, s).content, data, 0o644); err != nil {
 		t.Fatalf("failed to write file: %v", err)
 	}
 
-	if err := s.Cleanup(ctx, time.Hour); err != nil {
+	if _, err := s.Cleanup(ctx, time.Hour); err != nil {
 		t.Fatalf("Cleanup failed: %v", err)
 	}
 
-	_, err := s.Get(ctx)
+	_, err := s.Get(ctx, DefaultClip)
 	if err != ErrEmpty {
 		t.Errorf("expected ErrEmpty after cleanup, got %v", err)
 	}
 }
 
+func TestStore_CleanupZeroMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	old := Content{
+		Content:   "old content",
+		UpdatedAt: time.Now().Add(-365 * 24 * time.Hour),
+	}
+	data, _ := json.Marshal(old)
+	if err := os.WriteFile(defaultPaths(t, s).content, data, 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if _, err := s.Cleanup(ctx, 0); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	if _, err := s.Get(ctx, DefaultClip); err != nil {
+		t.Errorf("expected clip to be kept, got %v", err)
+	}
+}
+
+func TestStore_Expired(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	if _, err := s.Set(ctx, "fresh", "new", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	old := Content{Content: "old content", UpdatedAt: time.Now().Add(-2 * time.Hour)}
+	data, _ := json.Marshal(old)
+	if err := os.WriteFile(defaultPaths(t, s).content, data, 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	expired, err := s.Expired(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("Expired failed: %v", err)
+	}
+	if len(expired) != 1 || expired[0] != DefaultClip {
+		t.Errorf("expected the default clip, got %v", expired)
+	}
+
+	if _, err := s.Get(ctx, DefaultClip); err != nil {
+		t.Errorf("expected clip to be kept, got %v", err)
+	}
+}
+
 func TestStore_CleanupInvalidJSON(t *testing.T) {
 	dir := t.TempDir()
-	s := NewStore(dir)
+	s := newTestStore(t, dir, &mockPublisher{})
 
-	if err := os.WriteFile(s.filePath, []byte("bad"), 0o644); err != nil {
+	if err := os.WriteFile(defaultPaths(t, s).content, []byte("bad"), 0o644); err != nil {
 		t.Fatalf("failed to write file: %v", err)
 	}
 
-	err := s.Cleanup(context.Background(), time.Hour)
-	if err == nil {
-		t.Error("expected error for invalid JSON, got nil")
+	res, err := s.Cleanup(context.Background(), time.Hour)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if len(res.Failed) != 1 || res.Failed[0].Name != DefaultClip {
+		t.Errorf("expected the default clip to be reported as failed, got %+v", res.Failed)
+	}
+}
+
+func TestStore_SetAssignsRevisions(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	for _, v := range []string{"one", "two", "three"} {
+		if _, err := s.Set(ctx, DefaultClip, v, SetOptions{}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	c, err := s.Get(ctx, DefaultClip)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if c.Revision != 3 {
+		t.Errorf("expected revision 3, got %d", c.Revision)
+	}
+
+	history, err := s.History(ctx, DefaultClip)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 revisions in history, got %d", len(history))
+	}
+	if history[0].Content != "two" || history[1].Content != "one" {
+		t.Errorf("expected history newest first, got %q, %q", history[0].Content, history[1].Content)
+	}
+}
+
+func TestStore_HistoryBounded(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	for i := 0; i < maxHistory+10; i++ {
+		if _, err := s.Set(ctx, DefaultClip, "v", SetOptions{}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	history, err := s.History(ctx, DefaultClip)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(history) != maxHistory {
+		t.Errorf("expected %d revisions, got %d", maxHistory, len(history))
+	}
+}
+
+func TestStore_RevisionAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	if _, err := s.Set(ctx, DefaultClip, "original", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := s.Set(ctx, DefaultClip, "accidental paste", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	rev, err := s.Revision(ctx, DefaultClip, 1)
+	if err != nil {
+		t.Fatalf("Revision failed: %v", err)
+	}
+	if rev.Content != "original" {
+		t.Errorf("expected content %q, got %q", "original", rev.Content)
+	}
+
+	restored, err := s.Restore(ctx, DefaultClip, 1)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if restored.Revision != 3 {
+		t.Errorf("expected restore to create revision 3, got %d", restored.Revision)
+	}
+
+	c, err := s.Get(ctx, DefaultClip)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if c.Content != "original" {
+		t.Errorf("expected content %q, got %q", "original", c.Content)
+	}
+}
+
+func TestStore_RevisionNotFound(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+
+	_, err := s.Revision(context.Background(), DefaultClip, 42)
+	if err != ErrRevisionNotFound {
+		t.Errorf("expected ErrRevisionNotFound, got %v", err)
+	}
+
+	_, err = s.Restore(context.Background(), DefaultClip, 42)
+	if err != ErrRevisionNotFound {
+		t.Errorf("expected ErrRevisionNotFound, got %v", err)
+	}
+}
+
+func TestStore_CleanupExpiredHistory(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	history := []Content{
+		{Revision: 2, Content: "recent", UpdatedAt: time.Now()},
+		{Revision: 1, Content: "stale", UpdatedAt: time.Now().Add(-2 * time.Hour)},
+	}
+	data, _ := json.Marshal(history)
+	if err := os.WriteFile(defaultPaths(t, s).history, data, 0o644); err != nil {
+		t.Fatalf("failed to write history: %v", err)
+	}
+
+	if _, err := s.Cleanup(ctx, time.Hour); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	got, err := s.History(ctx, DefaultClip)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(got) != 1 || got[0].Content != "recent" {
+		t.Errorf("expected only recent revision to remain, got %v", got)
+	}
+}
+
+func TestStore_NamedClipsAreIndependent(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	if _, err := s.Set(ctx, DefaultClip, "shared", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := s.Set(ctx, "work", "meeting notes", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	c, err := s.Get(ctx, "work")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if c.Content != "meeting notes" || c.Name != "work" {
+		t.Errorf("unexpected clip: %+v", c)
+	}
+	if c.Revision != 1 {
+		t.Errorf("expected revision 1 for new clip, got %d", c.Revision)
+	}
+
+	c, err = s.Get(ctx, DefaultClip)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if c.Content != "shared" {
+		t.Errorf("expected default clip untouched, got %q", c.Content)
+	}
+}
+
+func TestStore_List(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	for _, name := range []string{"zeta", DefaultClip, "alpha"} {
+		if _, err := s.Set(ctx, name, name+" content", SetOptions{}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if _, err := s.Set(ctx, "alpha", "updated", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	clips, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	var names []string
+	for _, c := range clips {
+		names = append(names, c.Name)
+	}
+	if strings.Join(names, ",") != "default,alpha,zeta" {
+		t.Errorf("unexpected clip names: %v", names)
+	}
+}
+
+func TestStore_Delete(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	if _, err := s.Set(ctx, "temp", "a", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := s.Set(ctx, "temp", "b", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	if err := s.Delete(ctx, "temp"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	if _, err := s.Get(ctx, "temp"); err != ErrEmpty {
+		t.Errorf("expected ErrEmpty after delete, got %v", err)
+	}
+
+	history, err := s.History(ctx, "temp")
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(history) != 0 {
+		t.Errorf("expected history removed, got %d revisions", len(history))
+	}
+
+	if err := s.Delete(ctx, "temp"); err != ErrEmpty {
+		t.Errorf("expected ErrEmpty for missing clip, got %v", err)
+	}
+}
+
+func TestStore_InvalidName(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	for _, name := range []string{"", "../etc", "a.b", "with space"} {
+		if _, err := s.Set(ctx, name, "x", SetOptions{}); err != ErrInvalidName {
+			t.Errorf("expected ErrInvalidName for %q, got %v", name, err)
+		}
+	}
+}
+
+func TestStore_CleanupNamedClips(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	if _, err := s.Set(ctx, "fresh", "keep", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	stale := Content{Content: "old", UpdatedAt: time.Now().Add(-2 * time.Hour)}
+	data, _ := json.Marshal(stale)
+	if err := os.WriteFile(filepath.Join(s.clipsDir, "stale.json"), data, 0o644); err != nil {
+		t.Fatalf("failed to write clip: %v", err)
+	}
+
+	if _, err := s.Cleanup(ctx, time.Hour); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	if _, err := s.Get(ctx, "stale"); err != ErrEmpty {
+		t.Errorf("expected stale clip removed, got %v", err)
+	}
+	if _, err := s.Get(ctx, "fresh"); err != nil {
+		t.Errorf("expected fresh clip kept, got %v", err)
+	}
+}
+
+func TestStore_CompareAndSet(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	c, err := s.CompareAndSet(ctx, DefaultClip, 0, "first", SetOptions{})
+	if err != nil {
+		t.Fatalf("CompareAndSet on empty clip failed: %v", err)
+	}
+	if c.Revision != 1 {
+		t.Errorf("expected revision 1, got %d", c.Revision)
+	}
+
+	c, err = s.CompareAndSet(ctx, DefaultClip, 1, "second", SetOptions{})
+	if err != nil {
+		t.Fatalf("CompareAndSet failed: %v", err)
+	}
+	if c.Revision != 2 {
+		t.Errorf("expected revision 2, got %d", c.Revision)
+	}
+}
+
+func TestStore_CompareAndSetConflict(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	if _, err := s.Set(ctx, DefaultClip, "laptop", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := s.Set(ctx, DefaultClip, "phone", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	current, err := s.CompareAndSet(ctx, DefaultClip, 1, "stale laptop edit", SetOptions{})
+	if err != ErrConflict {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if current.Content != "phone" || current.Revision != 2 {
+		t.Errorf("expected current content to be returned, got %+v", current)
+	}
+
+	c, err := s.Get(ctx, DefaultClip)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if c.Content != "phone" {
+		t.Errorf("expected content unchanged, got %q", c.Content)
+	}
+}
+
+func TestStore_CompareAndSetTextKeepsFormatsAndExpiry(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	if _, err := s.Set(ctx, DefaultClip, "hello", SetOptions{TTL: -1}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	c, err := s.SetFormat(ctx, DefaultClip, TypeHTML, strings.NewReader("<b>hello</b>"))
+	if err != nil {
+		t.Fatalf("SetFormat failed: %v", err)
+	}
+
+	c, err = s.CompareAndSetText(ctx, DefaultClip, c.Revision, "hello, world")
+	if err != nil {
+		t.Fatalf("CompareAndSetText failed: %v", err)
+	}
+	if c.Content != "hello, world" {
+		t.Errorf("expected the new text, got %q", c.Content)
+	}
+	if len(c.Formats) != 1 || c.Formats[0].Type != TypeHTML {
+		t.Errorf("expected the HTML representation to be kept, got %+v", c.Formats)
+	}
+	if !c.NeverExpires {
+		t.Error("expected the clip to still never expire")
+	}
+
+	if _, err := s.CompareAndSetText(ctx, DefaultClip, c.Revision-1, "stale"); err != ErrConflict {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+}
+
+func TestStore_CompareAndSetConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wins := 0
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.CompareAndSet(ctx, DefaultClip, 0, "racer", SetOptions{}); err == nil {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if wins != 1 {
+		t.Errorf("expected exactly one writer to win, got %d", wins)
+	}
+}
+
+func TestStore_SetIfExists(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	if _, err := s.SetIfExists(ctx, DefaultClip, "x", SetOptions{}); err != ErrConflict {
+		t.Fatalf("expected ErrConflict for a missing clip, got %v", err)
+	}
+	if _, err := s.Get(ctx, DefaultClip); err != ErrEmpty {
+		t.Errorf("expected nothing written, got %v", err)
+	}
+
+	s.Set(ctx, DefaultClip, "first", SetOptions{})
+
+	// Concurrent writes to a clip that exists all go through.
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.SetIfExists(ctx, DefaultClip, "racer", SetOptions{})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("expected every write to succeed, got %v", err)
+		}
+	}
+
+	c, _ := s.Get(ctx, DefaultClip)
+	if c.Content != "racer" || c.Revision != 11 {
+		t.Errorf("expected revision 11 with the new text, got %d %q", c.Revision, c.Content)
+	}
+}
+
+func TestStore_PublishesTextUpdated(t *testing.T) {
+	dir := t.TempDir()
+	pub := &mockPublisher{}
+	s := newTestStore(t, dir, pub)
+	ctx := context.Background()
+
+	if _, err := s.Set(ctx, "work", "hello", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := s.Delete(ctx, "work"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	if len(pub.events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(pub.events))
+	}
+	for _, e := range pub.events {
+		if e.Type != events.TextUpdated {
+			t.Errorf("expected %q, got %q", events.TextUpdated, e.Type)
+		}
+	}
+	if c := pub.events[0].Data.(Content); c.Content != "hello" || c.Revision != 1 {
+		t.Errorf("unexpected event payload: %+v", c)
+	}
+}
+
+func TestStore_CleanupReportsExpiredClips(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	old := Content{Content: "old", UpdatedAt: time.Now().Add(-2 * time.Hour)}
+	data, _ := json.Marshal(old)
+	if err := os.WriteFile(defaultPaths(t, s).content, data, 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	res, err := s.Cleanup(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if len(res.Removed) != 1 || res.Removed[0] != DefaultClip {
+		t.Errorf("expected default clip reported as removed, got %v", res.Removed)
+	}
+}
+
+func TestStore_SetFormatKeepsOtherFormats(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	if _, err := s.SetFormat(ctx, DefaultClip, TypePlain, strings.NewReader("hello")); err != nil {
+		t.Fatalf("SetFormat plain failed: %v", err)
+	}
+	if _, err := s.SetFormat(ctx, DefaultClip, TypeHTML, strings.NewReader("<b>hello</b>")); err != nil {
+		t.Fatalf("SetFormat html failed: %v", err)
+	}
+	c, err := s.SetFormat(ctx, DefaultClip, TypePNG, bytes.NewReader([]byte{0x89, 'P', 'N', 'G'}))
+	if err != nil {
+		t.Fatalf("SetFormat png failed: %v", err)
+	}
+
+	if c.Content != "hello" {
+		t.Errorf("expected plain text kept, got %q", c.Content)
+	}
+	if len(c.Formats) != 2 {
+		t.Fatalf("expected 2 binary formats, got %d", len(c.Formats))
+	}
+
+	f, ok := c.Format(TypePNG)
+	if !ok || f.Size != 4 {
+		t.Errorf("unexpected png format: %+v", f)
+	}
+
+	rc, err := s.OpenFormat(ctx, DefaultClip, TypeHTML)
+	if err != nil {
+		t.Fatalf("OpenFormat failed: %v", err)
+	}
+	defer rc.Close()
+
+	data, _ := io.ReadAll(rc)
+	if string(data) != "<b>hello</b>" {
+		t.Errorf("expected html payload, got %q", data)
+	}
+}
+
+func TestStore_SetReplacesFormats(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	if _, err := s.SetFormat(ctx, DefaultClip, TypeHTML, strings.NewReader("<i>x</i>")); err != nil {
+		t.Fatalf("SetFormat failed: %v", err)
+	}
+
+	c, err := s.Set(ctx, DefaultClip, "typed", SetOptions{})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if len(c.Formats) != 0 {
+		t.Errorf("expected plain write to drop other formats, got %v", c.Formats)
+	}
+
+	if _, err := s.OpenFormat(ctx, DefaultClip, TypeHTML); err != ErrFormatNotFound {
+		t.Errorf("expected ErrFormatNotFound, got %v", err)
+	}
+}
+
+func TestStore_SetFormatUnsupported(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+
+	_, err := s.SetFormat(context.Background(), DefaultClip, "application/pdf", strings.NewReader("x"))
+	if err != ErrUnsupportedFormat {
+		t.Errorf("expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestStore_SetFormatTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+
+	big := bytes.NewReader(make([]byte, defaultMaxFormatSize+1))
+	if _, err := s.SetFormat(context.Background(), DefaultClip, TypePNG, big); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestStore_SetFormatLimits(t *testing.T) {
+	s, err := NewStore(t.TempDir(), &mockPublisher{}, Options{MaxTextSize: 16, MaxFormatSize: 8})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	ctx := context.Background()
+
+	// Plain text is held to the text limit even where it is above the
+	// format limit.
+	if _, err := s.SetFormat(ctx, DefaultClip, TypePlain, strings.NewReader("123456789012")); err != nil {
+		t.Errorf("expected text within the text limit to be stored, got %v", err)
+	}
+	if _, err := s.SetFormat(ctx, DefaultClip, TypeHTML, strings.NewReader("12345678")); err != nil {
+		t.Errorf("expected a format at the limit to be stored, got %v", err)
+	}
+	if _, err := s.SetFormat(ctx, DefaultClip, TypeHTML, strings.NewReader("123456789")); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestStore_SetTextTooLarge(t *testing.T) {
+	s, err := NewStore(t.TempDir(), &mockPublisher{}, Options{MaxTextSize: 8})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := s.Set(ctx, DefaultClip, "12345678", SetOptions{}); err != nil {
+		t.Fatalf("expected text at the limit to be stored, got %v", err)
+	}
+	if _, err := s.Set(ctx, DefaultClip, "123456789", SetOptions{}); err != ErrTextTooLarge {
+		t.Errorf("expected ErrTextTooLarge from Set, got %v", err)
+	}
+	if _, err := s.CompareAndSet(ctx, DefaultClip, 1, "123456789", SetOptions{}); err != ErrTextTooLarge {
+		t.Errorf("expected ErrTextTooLarge from CompareAndSet, got %v", err)
+	}
+
+	// Plain text sent as a format is still clip text.
+	_, err = s.SetFormat(ctx, DefaultClip, TypePlain, strings.NewReader("123456789"))
+	if err != ErrTextTooLarge {
+		t.Errorf("expected ErrTextTooLarge from SetFormat, got %v", err)
+	}
+
+	c, _ := s.Get(ctx, DefaultClip)
+	if c.Content != "12345678" {
+		t.Errorf("expected the stored text to be unchanged, got %q", c.Content)
+	}
+}
+
+func TestStore_CleanupCollectsUnreferencedBlobs(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	c, err := s.SetFormat(ctx, DefaultClip, TypePNG, strings.NewReader("image"))
+	if err != nil {
+		t.Fatalf("SetFormat failed: %v", err)
+	}
+	f, _ := c.Format(TypePNG)
+
+	if err := s.Delete(ctx, DefaultClip); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(s.blobsDir, f.SHA256)); err != nil {
+		t.Fatalf("expected blob to survive until cleanup: %v", err)
+	}
+
+	if _, err := s.Cleanup(ctx, time.Hour); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(s.blobsDir, f.SHA256)); !os.IsNotExist(err) {
+		t.Errorf("expected unreferenced blob removed, got %v", err)
+	}
+}
+
+func TestStore_RestoreKeepsFormats(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	old, err := s.SetFormat(ctx, DefaultClip, TypePNG, strings.NewReader("screenshot"))
+	if err != nil {
+		t.Fatalf("SetFormat failed: %v", err)
+	}
+	if _, err := s.Set(ctx, DefaultClip, "oops", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	c, err := s.Restore(ctx, DefaultClip, old.Revision)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if _, ok := c.Format(TypePNG); !ok {
+		t.Error("expected restored revision to include the png format")
+	}
+}
+
+func TestStore_SetWithTTL(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	c, err := s.Set(ctx, "secret", "hunter2", SetOptions{TTL: 10 * time.Minute})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if c.ExpiresAt == nil || !c.ExpiresAt.Equal(c.UpdatedAt.Add(10*time.Minute)) {
+		t.Fatalf("expected expiry 10m after update, got %v", c.ExpiresAt)
+	}
+
+	res, err := s.Cleanup(ctx, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if len(res.Removed) != 0 {
+		t.Errorf("expected nothing removed before the ttl, got %v", res.Removed)
+	}
+
+	past := time.Now().Add(-time.Second)
+	c.ExpiresAt = &past
+	data, _ := json.Marshal(c)
+	if err := os.WriteFile(filepath.Join(s.clipsDir, "secret.json"), data, 0o644); err != nil {
+		t.Fatalf("failed to write clip: %v", err)
+	}
+
+	if _, err := s.Cleanup(ctx, 24*time.Hour); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if _, err := s.Get(ctx, "secret"); err != ErrEmpty {
+		t.Errorf("expected clip removed after its ttl, got %v", err)
+	}
+}
+
+func TestStore_SetNeverExpires(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	c, err := s.Set(ctx, "wifi", "guest password", SetOptions{TTL: -1})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if !c.NeverExpires || c.ExpiresAt != nil {
+		t.Fatalf("expected clip without expiry, got %+v", c)
+	}
+
+	c.UpdatedAt = time.Now().Add(-48 * time.Hour)
+	data, _ := json.Marshal(c)
+	if err := os.WriteFile(filepath.Join(s.clipsDir, "wifi.json"), data, 0o644); err != nil {
+		t.Fatalf("failed to write clip: %v", err)
+	}
+
+	if _, err := s.Cleanup(ctx, time.Hour); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if _, err := s.Get(ctx, "wifi"); err != nil {
+		t.Errorf("expected clip kept, got %v", err)
+	}
+}
+
+func TestStore_SetFormatKeepsExpiry(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	first, err := s.Set(ctx, DefaultClip, "text", SetOptions{TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	c, err := s.SetFormat(ctx, DefaultClip, TypeHTML, strings.NewReader("<b>text</b>"))
+	if err != nil {
+		t.Fatalf("SetFormat failed: %v", err)
+	}
+	if c.ExpiresAt == nil || !c.ExpiresAt.Equal(*first.ExpiresAt) {
+		t.Errorf("expected expiry %v kept, got %v", first.ExpiresAt, c.ExpiresAt)
+	}
+
+	c, err = s.Set(ctx, DefaultClip, "replaced", SetOptions{})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if c.ExpiresAt != nil {
+		t.Errorf("expected a plain write to reset the expiry, got %v", c.ExpiresAt)
+	}
+}
+
+func TestStore_PinSurvivesCleanupAndWrites(t *testing.T) {
+	dir := t.TempDir()
+	pub := &mockPublisher{}
+	s := newTestStore(t, dir, pub)
+	ctx := context.Background()
+
+	if _, err := s.Set(ctx, "wifi", "v1", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	c, err := s.Pin(ctx, "wifi", true)
+	if err != nil {
+		t.Fatalf("Pin failed: %v", err)
+	}
+	if !c.Pinned || c.Revision != 1 {
+		t.Fatalf("expected revision 1 pinned, got %+v", c)
+	}
+
+	c, err = s.Set(ctx, "wifi", "v2", SetOptions{})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if !c.Pinned {
+		t.Error("expected pin to carry over to the new revision")
+	}
+
+	history, err := s.History(ctx, "wifi")
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(history) != 1 || history[0].Pinned {
+		t.Errorf("expected unpinned history entry, got %+v", history)
+	}
+
+	c.UpdatedAt = time.Now().Add(-48 * time.Hour)
+	data, _ := json.Marshal(c)
+	if err := os.WriteFile(filepath.Join(s.clipsDir, "wifi.json"), data, 0o644); err != nil {
+		t.Fatalf("failed to write clip: %v", err)
+	}
+
+	if _, err := s.Cleanup(ctx, time.Hour); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if _, err := s.Get(ctx, "wifi"); err != nil {
+		t.Fatalf("expected pinned clip kept, got %v", err)
+	}
+
+	if _, err := s.Pin(ctx, "wifi", false); err != nil {
+		t.Fatalf("Pin failed: %v", err)
+	}
+	if _, err := s.Cleanup(ctx, time.Hour); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if _, err := s.Get(ctx, "wifi"); err != ErrEmpty {
+		t.Errorf("expected unpinned clip removed, got %v", err)
+	}
+}
+
+func TestStore_PinMissing(t *testing.T) {
+	s := newTestStore(t, t.TempDir(), &mockPublisher{})
+
+	if _, err := s.Pin(context.Background(), "missing", true); err != ErrEmpty {
+		t.Errorf("expected ErrEmpty, got %v", err)
+	}
+}
+
+func TestStore_BurnAfterReading(t *testing.T) {
+	dir := t.TempDir()
+	pub := &mockPublisher{}
+	s := newTestStore(t, dir, pub)
+	ctx := context.Background()
+
+	if _, err := s.Set(ctx, DefaultClip, "before", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := s.Set(ctx, DefaultClip, "hunter2", SetOptions{BurnAfterReading: true}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	published := pub.events[len(pub.events)-1].Data.(Content)
+	if published.Content != "" || !published.BurnAfterReading {
+		t.Errorf("expected a redacted event, got %+v", published)
+	}
+
+	c, err := s.Get(ctx, DefaultClip)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if c.Content != "" || !c.BurnAfterReading {
+		t.Errorf("expected Get to hide the secret, got %+v", c)
+	}
+
+	clips, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if clips[0].Content != "" {
+		t.Errorf("expected List to hide the secret, got %q", clips[0].Content)
+	}
+
+	if _, err := s.Revision(ctx, DefaultClip, 2); err != ErrRevisionNotFound {
+		t.Errorf("expected secret revision to be hidden, got %v", err)
+	}
+
+	c, err = s.Read(ctx, DefaultClip)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if c.Content != "hunter2" {
+		t.Errorf("expected the secret, got %q", c.Content)
+	}
+
+	if _, err := s.Read(ctx, DefaultClip); err != ErrEmpty {
+		t.Errorf("expected the secret to be gone after one read, got %v", err)
+	}
+}
+
+func TestStore_BurnAfterReadingNotKeptInHistory(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	if _, err := s.Set(ctx, DefaultClip, "hunter2", SetOptions{BurnAfterReading: true}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := s.Set(ctx, DefaultClip, "replaced", SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	history, err := s.History(ctx, DefaultClip)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(history) != 0 {
+		t.Errorf("expected the unread secret to be dropped, got %+v", history)
+	}
+}
+
+func TestStore_BurnAfterReadingKeepsHistory(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	for _, v := range []string{"a", "b"} {
+		if _, err := s.Set(ctx, DefaultClip, v, SetOptions{}); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	if _, err := s.Set(ctx, DefaultClip, "hunter2", SetOptions{BurnAfterReading: true}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	if _, err := s.Read(ctx, DefaultClip); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if _, err := s.Get(ctx, DefaultClip); err != ErrEmpty {
+		t.Errorf("expected the secret to be gone, got %v", err)
+	}
+
+	history, err := s.History(ctx, DefaultClip)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(history) != 2 || history[0].Content != "b" || history[1].Content != "a" {
+		t.Errorf("expected earlier revisions to survive the read, got %+v", history)
+	}
+}
+
+func TestStore_BurnAfterReadingConcurrentReaders(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, &mockPublisher{})
+	ctx := context.Background()
+
+	if _, err := s.Set(ctx, "otp", "123456", SetOptions{BurnAfterReading: true}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	got := 0
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c, err := s.Read(ctx, "otp"); err == nil && c.Content == "123456" {
+				mu.Lock()
+				got++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got != 1 {
+		t.Errorf("expected exactly one reader to get the secret, got %d", got)
 	}
 }
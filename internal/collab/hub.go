@@ -0,0 +1,402 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/d6o/homeclip/internal/clipboard"
+	"github.com/d6o/homeclip/internal/events"
+)
+
+const (
+	maxHistory    = 1000
+	sessionBuffer = 256
+)
+
+var (
+	ErrStaleRevision    = errors.New("revision is too old or in the future")
+	ErrSessionClosed    = errors.New("editing session is closed")
+	ErrBurnAfterReading = errors.New("burn-after-reading clips cannot be edited live")
+)
+
+type textStore interface {
+	Get(ctx context.Context, name string) (clipboard.Content, error)
+	CompareAndSetText(ctx context.Context, name string, revision int64, text string) (clipboard.Content, error)
+}
+
+type eventSource interface {
+	Subscribe(lastID uint64) ([]events.Event, <-chan events.Event, func())
+}
+
+type Message struct {
+	Type     string `json:"type"`
+	Revision int    `json:"revision"`
+	Content  string `json:"content,omitempty"`
+	Op       Op     `json:"op,omitempty"`
+}
+
+type Hub struct {
+	store        textStore
+	events       eventSource
+	persistDelay time.Duration
+	maxText      int64
+
+	mu   sync.Mutex
+	docs map[string]*document
+}
+
+// NewHub returns a hub that writes documents to store persistDelay after
+// the last edit. Edits that would make a document longer than maxText bytes
+// are rejected; zero is no limit.
+func NewHub(store textStore, events eventSource, persistDelay time.Duration, maxText int64) *Hub {
+	return &Hub{
+		store:        store,
+		events:       events,
+		persistDelay: persistDelay,
+		maxText:      maxText,
+		docs:         make(map[string]*document),
+	}
+}
+
+// Run folds writes made outside of the editing sessions, such as a plain
+// PUT /api/text, into any open document.
+func (h *Hub) Run(ctx context.Context) error {
+	for {
+		_, live, cancel := h.events.Subscribe(0)
+
+	stream:
+		for {
+			select {
+			case <-ctx.Done():
+				cancel()
+				return ctx.Err()
+			case e, ok := <-live:
+				if !ok {
+					break stream
+				}
+				if c, ok := e.Data.(clipboard.Content); ok && e.Type == events.TextUpdated {
+					h.external(ctx, c)
+				}
+			}
+		}
+
+		cancel()
+	}
+}
+
+func (h *Hub) Join(ctx context.Context, name string) (*Session, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, ok := h.docs[name]
+	if !ok {
+		c, err := h.store.Get(ctx, name)
+		if err != nil && !errors.Is(err, clipboard.ErrEmpty) {
+			return nil, err
+		}
+		if c.BurnAfterReading {
+			return nil, ErrBurnAfterReading
+		}
+
+		text := utf16.Encode([]rune(c.Content))
+		d = &document{
+			hub:       h,
+			name:      name,
+			text:      text,
+			snapshot:  text,
+			persisted: c.Revision,
+			sessions:  make(map[*Session]struct{}),
+		}
+		h.docs[name] = d
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := &Session{
+		doc:      d,
+		messages: make(chan Message, sessionBuffer),
+	}
+	s.messages <- Message{Type: "init", Revision: d.revision, Content: string(utf16.Decode(d.text))}
+	d.sessions[s] = struct{}{}
+
+	return s, nil
+}
+
+func (h *Hub) external(ctx context.Context, c clipboard.Content) {
+	h.mu.Lock()
+	d, ok := h.docs[c.Name]
+	if ok && c.BurnAfterReading {
+		delete(h.docs, c.Name)
+	}
+	h.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// A secret replaced the document; editors are disconnected and nothing
+	// they typed is written over it.
+	if c.BurnAfterReading {
+		d.close()
+		return
+	}
+
+	if c.Revision != 0 && c.Revision <= d.persisted {
+		return
+	}
+
+	if err := d.merge(c); err != nil {
+		slog.Error("failed to merge external clipboard change", "name", d.name, "error", err)
+		return
+	}
+
+	if d.dirty {
+		d.persist(ctx)
+	}
+}
+
+func (h *Hub) leave(s *Session) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d := s.doc
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.sessions[s]; !ok {
+		return
+	}
+	delete(d.sessions, s)
+	close(s.messages)
+
+	if len(d.sessions) > 0 {
+		return
+	}
+
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	if d.dirty {
+		d.persist(context.Background())
+	}
+	delete(h.docs, d.name)
+}
+
+type Session struct {
+	doc      *document
+	messages chan Message
+}
+
+// Messages delivers the initial document followed by operations from other
+// editors. It is closed when the session leaves or falls too far behind.
+func (s *Session) Messages() <-chan Message {
+	return s.messages
+}
+
+// Submit applies an operation made against revision. The session is sent an
+// ack carrying the new revision once it has been applied.
+func (s *Session) Submit(revision int, op Op) error {
+	return s.doc.submit(s, revision, op)
+}
+
+func (s *Session) Leave() {
+	s.doc.hub.leave(s)
+}
+
+type document struct {
+	hub  *Hub
+	name string
+
+	mu       sync.Mutex
+	text     []uint16
+	revision int
+	history  []Op
+	sessions map[*Session]struct{}
+
+	// snapshot is the text last read from or written to the store, at store
+	// revision persisted and document revision snapshotRev.
+	snapshot    []uint16
+	snapshotRev int
+	persisted   int64
+	dirty       bool
+	timer       *time.Timer
+}
+
+func (d *document) submit(from *Session, revision int, op Op) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.sessions[from]; !ok {
+		return ErrSessionClosed
+	}
+
+	if err := d.apply(from, revision, op); err != nil {
+		return err
+	}
+
+	d.schedulePersist()
+
+	return nil
+}
+
+func (d *document) apply(from *Session, revision int, op Op) error {
+	base := d.revision - len(d.history)
+	if revision < base || revision > d.revision {
+		return ErrStaleRevision
+	}
+
+	for _, concurrent := range d.history[revision-base:] {
+		var err error
+		if op, _, err = transform(op, concurrent); err != nil {
+			return err
+		}
+	}
+
+	text, err := apply(d.text, op)
+	if err != nil {
+		return err
+	}
+
+	// The store would refuse the document, so editors may not grow it past
+	// the limit. Changes merged from the store are already within it.
+	if from != nil && d.hub.maxText > 0 {
+		if n := utf8Len(text); n > d.hub.maxText && n > utf8Len(d.text) {
+			return clipboard.ErrTextTooLarge
+		}
+	}
+
+	d.text = text
+	d.revision++
+	d.history = append(d.history, op)
+	if len(d.history) > maxHistory {
+		d.history = d.history[len(d.history)-maxHistory:]
+	}
+	d.dirty = true
+
+	for s := range d.sessions {
+		m := Message{Type: "op", Revision: d.revision, Op: op}
+		if s == from {
+			m = Message{Type: "ack", Revision: d.revision}
+		}
+
+		select {
+		case s.messages <- m:
+		default:
+			// Drop editors that cannot keep up; they reconnect and resync.
+			delete(d.sessions, s)
+			close(s.messages)
+		}
+	}
+
+	return nil
+}
+
+// merge rebases a change written to the store by someone else onto the
+// document, as if it had been submitted against the last snapshot.
+func (d *document) merge(c clipboard.Content) error {
+	target := utf16.Encode([]rune(c.Content))
+
+	op := diff(d.snapshot, target)
+	if !op.isNoop() {
+		if err := d.apply(nil, d.snapshotRev, op); err != nil {
+			return err
+		}
+	}
+
+	d.persisted = c.Revision
+	d.snapshot = target
+	d.snapshotRev = d.revision
+	d.dirty = !slices.Equal(d.text, target)
+
+	return nil
+}
+
+func (d *document) close() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.dirty = false
+
+	for s := range d.sessions {
+		delete(d.sessions, s)
+		close(s.messages)
+	}
+}
+
+func (d *document) schedulePersist() {
+	if d.timer != nil {
+		return
+	}
+
+	d.timer = time.AfterFunc(d.hub.persistDelay, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		d.timer = nil
+		if d.dirty {
+			d.persist(context.Background())
+		}
+	})
+}
+
+func (d *document) persist(ctx context.Context) {
+	for attempt := 0; attempt < 3; attempt++ {
+		text := string(utf16.Decode(d.text))
+
+		c, err := d.hub.store.CompareAndSetText(ctx, d.name, d.persisted, text)
+		if errors.Is(err, clipboard.ErrConflict) && c.BurnAfterReading {
+			// Run closes the document when the secret's event arrives.
+			d.dirty = false
+			return
+		}
+		if errors.Is(err, clipboard.ErrConflict) {
+			if err := d.merge(c); err != nil {
+				slog.Error("failed to merge clipboard conflict", "name", d.name, "error", err)
+				return
+			}
+			continue
+		}
+		if err != nil {
+			slog.Error("failed to persist clipboard", "name", d.name, "error", err)
+			return
+		}
+
+		d.persisted = c.Revision
+		d.snapshot = d.text
+		d.snapshotRev = d.revision
+		d.dirty = false
+		return
+	}
+
+	slog.Error("gave up persisting clipboard after repeated conflicts", "name", d.name)
+}
+
+// utf8Len is the length of text in bytes once it is stored as UTF-8, with
+// unpaired surrogates replaced the way utf16.Decode does.
+func utf8Len(text []uint16) int64 {
+	var n int64
+	for i := 0; i < len(text); i++ {
+		r := rune(text[i])
+		if utf16.IsSurrogate(r) {
+			if i+1 < len(text) && utf16.DecodeRune(r, rune(text[i+1])) != utf8.RuneError {
+				n += 4
+				i++
+				continue
+			}
+			r = utf8.RuneError
+		}
+		n += int64(utf8.RuneLen(r))
+	}
+	return n
+}
@@ -0,0 +1,321 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/d6o/homeclip/internal/clipboard"
+	"github.com/d6o/homeclip/internal/events"
+)
+
+func newTestHub(t *testing.T) (*Hub, *clipboard.Store) {
+	t.Helper()
+	bus := events.NewBus(16)
+	store, err := clipboard.NewStore(t.TempDir(), bus, clipboard.Options{})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	hub := NewHub(store, bus, time.Hour, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go hub.Run(ctx)
+
+	return hub, store
+}
+
+func receive(t *testing.T, s *Session) Message {
+	t.Helper()
+	select {
+	case m, ok := <-s.Messages():
+		if !ok {
+			t.Fatal("session closed")
+		}
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	return Message{}
+}
+
+func TestHub_JoinSendsInit(t *testing.T) {
+	hub, store := newTestHub(t)
+	ctx := context.Background()
+
+	if _, err := store.Set(ctx, clipboard.DefaultClip, "hello", clipboard.SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	s, err := hub.Join(ctx, clipboard.DefaultClip)
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	defer s.Leave()
+
+	m := receive(t, s)
+	if m.Type != "init" || m.Content != "hello" || m.Revision != 0 {
+		t.Errorf("unexpected init message: %+v", m)
+	}
+}
+
+func TestHub_ConcurrentEditsConverge(t *testing.T) {
+	hub, store := newTestHub(t)
+	ctx := context.Background()
+
+	if _, err := store.Set(ctx, clipboard.DefaultClip, "abc", clipboard.SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	laptop, _ := hub.Join(ctx, clipboard.DefaultClip)
+	defer laptop.Leave()
+	phone, _ := hub.Join(ctx, clipboard.DefaultClip)
+	defer phone.Leave()
+	receive(t, laptop)
+	receive(t, phone)
+
+	// Both edit revision 0 without having seen each other's change.
+	if err := laptop.Submit(0, parseOp(t, `[1,"X",2]`)); err != nil {
+		t.Fatalf("laptop Submit failed: %v", err)
+	}
+	if err := phone.Submit(0, parseOp(t, `[2,"Y",1]`)); err != nil {
+		t.Fatalf("phone Submit failed: %v", err)
+	}
+
+	if m := receive(t, laptop); m.Type != "ack" || m.Revision != 1 {
+		t.Errorf("expected laptop ack at revision 1, got %+v", m)
+	}
+
+	m := receive(t, phone)
+	if m.Type != "op" || m.Revision != 1 {
+		t.Errorf("expected phone to receive laptop op at revision 1, got %+v", m)
+	}
+
+	if m := receive(t, phone); m.Type != "ack" || m.Revision != 2 {
+		t.Errorf("expected phone ack at revision 2, got %+v", m)
+	}
+
+	m = receive(t, laptop)
+	if m.Type != "op" || m.Revision != 2 {
+		t.Errorf("expected laptop to receive rebased phone op at revision 2, got %+v", m)
+	}
+
+	laptopDoc, err := apply(text("aXbc"), m.Op)
+	if err != nil {
+		t.Fatalf("laptop failed to apply op: %v", err)
+	}
+	if str(laptopDoc) != "aXbYc" {
+		t.Errorf("expected laptop to converge on %q, got %q", "aXbYc", str(laptopDoc))
+	}
+}
+
+func TestHub_PersistsOnLastLeave(t *testing.T) {
+	hub, store := newTestHub(t)
+	ctx := context.Background()
+
+	s, _ := hub.Join(ctx, "notes")
+	receive(t, s)
+
+	if err := s.Submit(0, parseOp(t, `["draft"]`)); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	s.Leave()
+
+	c, err := store.Get(ctx, "notes")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if c.Content != "draft" {
+		t.Errorf("expected persisted content %q, got %q", "draft", c.Content)
+	}
+}
+
+func TestHub_PersistsAfterDelay(t *testing.T) {
+	bus := events.NewBus(16)
+	store, err := clipboard.NewStore(t.TempDir(), bus, clipboard.Options{})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	hub := NewHub(store, bus, 10*time.Millisecond, 0)
+	ctx := context.Background()
+
+	s, _ := hub.Join(ctx, clipboard.DefaultClip)
+	defer s.Leave()
+	receive(t, s)
+
+	if err := s.Submit(0, parseOp(t, `["typed"]`)); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if c, err := store.Get(ctx, clipboard.DefaultClip); err == nil && c.Content == "typed" {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("expected content to be persisted after the delay")
+}
+
+func TestHub_MergesExternalWrites(t *testing.T) {
+	hub, store := newTestHub(t)
+	ctx := context.Background()
+
+	if _, err := store.Set(ctx, clipboard.DefaultClip, "hello", clipboard.SetOptions{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	s, _ := hub.Join(ctx, clipboard.DefaultClip)
+	defer s.Leave()
+	receive(t, s)
+
+	// An unpersisted edit from the editor...
+	if err := s.Submit(0, parseOp(t, `[5," world"]`)); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	receive(t, s)
+
+	// ...and a plain PUT from another device at the same time.
+	c, err := store.Set(ctx, clipboard.DefaultClip, "Hello", clipboard.SetOptions{})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	hub.external(ctx, c)
+
+	m := receive(t, s)
+	doc, err := apply(text("hello world"), m.Op)
+	if err != nil {
+		t.Fatalf("failed to apply external op: %v", err)
+	}
+	if str(doc) != "Hello world" {
+		t.Errorf("expected editor to see %q, got %q", "Hello world", str(doc))
+	}
+
+	c, err = store.Get(ctx, clipboard.DefaultClip)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if c.Content != "Hello world" {
+		t.Errorf("expected merged content persisted, got %q", c.Content)
+	}
+}
+
+func TestHub_RejectsStaleRevision(t *testing.T) {
+	hub, _ := newTestHub(t)
+	ctx := context.Background()
+
+	s, _ := hub.Join(ctx, clipboard.DefaultClip)
+	defer s.Leave()
+	receive(t, s)
+
+	if err := s.Submit(5, parseOp(t, `["x"]`)); !errors.Is(err, ErrStaleRevision) {
+		t.Errorf("expected ErrStaleRevision, got %v", err)
+	}
+}
+
+func TestHub_RejectsEditsOverTextLimit(t *testing.T) {
+	bus := events.NewBus(16)
+	store, err := clipboard.NewStore(t.TempDir(), bus, clipboard.Options{MaxTextSize: 8})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	hub := NewHub(store, bus, time.Hour, 8)
+	ctx := context.Background()
+
+	s, _ := hub.Join(ctx, clipboard.DefaultClip)
+	receive(t, s)
+
+	if err := s.Submit(0, parseOp(t, `["1234567"]`)); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	receive(t, s)
+
+	// "é" is two bytes once stored, which takes the text to nine.
+	if err := s.Submit(1, parseOp(t, `[7, "é"]`)); !errors.Is(err, clipboard.ErrTextTooLarge) {
+		t.Errorf("expected ErrTextTooLarge, got %v", err)
+	}
+	if err := s.Submit(1, parseOp(t, `[7, "8"]`)); err != nil {
+		t.Errorf("expected text at the limit to be accepted, got %v", err)
+	}
+	receive(t, s)
+
+	s.Leave()
+
+	c, err := store.Get(ctx, clipboard.DefaultClip)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if c.Content != "12345678" {
+		t.Errorf("expected %q persisted, got %q", "12345678", c.Content)
+	}
+}
+
+func TestUTF8Len(t *testing.T) {
+	for _, s := range []string{"", "abc", "héllo", "日本", "😀 smile"} {
+		if got := utf8Len(utf16.Encode([]rune(s))); got != int64(len(s)) {
+			t.Errorf("utf8Len(%q) = %d, want %d", s, got, len(s))
+		}
+	}
+
+	// A lone surrogate is stored as U+FFFD.
+	if got := utf8Len([]uint16{0xd800, 'a'}); got != 4 {
+		t.Errorf("expected 4 bytes for a lone surrogate and a letter, got %d", got)
+	}
+}
+
+func TestHub_SubmitAfterLeave(t *testing.T) {
+	hub, _ := newTestHub(t)
+
+	s, _ := hub.Join(context.Background(), clipboard.DefaultClip)
+	s.Leave()
+
+	if err := s.Submit(0, parseOp(t, `["x"]`)); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("expected ErrSessionClosed, got %v", err)
+	}
+}
+
+func TestHub_BurnAfterReadingClosesDocument(t *testing.T) {
+	hub, store := newTestHub(t)
+	ctx := context.Background()
+
+	s, err := hub.Join(ctx, clipboard.DefaultClip)
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	defer s.Leave()
+	receive(t, s)
+
+	if err := s.Submit(0, parseOp(t, `["draft"]`)); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	receive(t, s)
+
+	c, err := store.Set(ctx, clipboard.DefaultClip, "secret", clipboard.SetOptions{BurnAfterReading: true})
+	if err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	hub.external(ctx, c)
+
+	select {
+	case _, ok := <-s.Messages():
+		if ok {
+			t.Fatal("expected session to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for session to close")
+	}
+
+	if _, err := hub.Join(ctx, clipboard.DefaultClip); !errors.Is(err, ErrBurnAfterReading) {
+		t.Errorf("expected ErrBurnAfterReading, got %v", err)
+	}
+
+	c, err = store.Read(ctx, clipboard.DefaultClip)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if c.Content != "secret" {
+		t.Errorf("expected the secret to survive the closed document, got %q", c.Content)
+	}
+}
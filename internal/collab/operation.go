@@ -0,0 +1,259 @@
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+var ErrInvalidOp = errors.New("invalid operation")
+
+// Positions and lengths are counted in UTF-16 code units so that operations
+// produced by browsers can be applied without translation.
+type component struct {
+	retain int
+	delete int
+	insert []uint16
+}
+
+// Op is a text operation. On the wire it is a JSON array where a positive
+// number retains, a negative number deletes and a string inserts.
+type Op []component
+
+func (o Op) MarshalJSON() ([]byte, error) {
+	out := make([]any, len(o))
+	for i, c := range o {
+		switch {
+		case c.retain > 0:
+			out[i] = c.retain
+		case c.delete > 0:
+			out[i] = -c.delete
+		default:
+			out[i] = string(utf16.Decode(c.insert))
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+func (o *Op) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	var op Op
+	for _, r := range raw {
+		var n int
+		if err := json.Unmarshal(r, &n); err == nil {
+			switch {
+			case n > 0:
+				op = op.retain(n)
+			case n < 0:
+				op = op.delete(-n)
+			default:
+				return fmt.Errorf("%w: zero-length component", ErrInvalidOp)
+			}
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(r, &s); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidOp, r)
+		}
+		op = op.insert(utf16.Encode([]rune(s)))
+	}
+
+	*o = op
+	return nil
+}
+
+func (o Op) retain(n int) Op {
+	if n <= 0 {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].retain > 0 {
+		o[last].retain += n
+		return o
+	}
+	return append(o, component{retain: n})
+}
+
+func (o Op) delete(n int) Op {
+	if n <= 0 {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].delete > 0 {
+		o[last].delete += n
+		return o
+	}
+	return append(o, component{delete: n})
+}
+
+// insert keeps inserts ahead of an adjacent delete so that equivalent
+// operations always have the same representation.
+func (o Op) insert(s []uint16) Op {
+	if len(s) == 0 {
+		return o
+	}
+
+	last := len(o) - 1
+	if last >= 0 && len(o[last].insert) > 0 {
+		o[last].insert = append(append([]uint16{}, o[last].insert...), s...)
+		return o
+	}
+
+	if last >= 0 && o[last].delete > 0 {
+		if last > 0 && len(o[last-1].insert) > 0 {
+			o[last-1].insert = append(append([]uint16{}, o[last-1].insert...), s...)
+			return o
+		}
+		o = append(o, o[last])
+		o[last] = component{insert: s}
+		return o
+	}
+
+	return append(o, component{insert: s})
+}
+
+func (o Op) baseLen() int {
+	n := 0
+	for _, c := range o {
+		n += c.retain + c.delete
+	}
+	return n
+}
+
+func (o Op) isNoop() bool {
+	for _, c := range o {
+		if c.delete > 0 || len(c.insert) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func apply(doc []uint16, op Op) ([]uint16, error) {
+	if op.baseLen() != len(doc) {
+		return nil, fmt.Errorf("%w: base length %d does not match document length %d", ErrInvalidOp, op.baseLen(), len(doc))
+	}
+
+	out := make([]uint16, 0, len(doc))
+	pos := 0
+	for _, c := range op {
+		switch {
+		case c.retain > 0:
+			out = append(out, doc[pos:pos+c.retain]...)
+			pos += c.retain
+		case c.delete > 0:
+			pos += c.delete
+		default:
+			out = append(out, c.insert...)
+		}
+	}
+
+	return out, nil
+}
+
+// transform rebases two concurrent operations on the same document so that
+// apply(apply(doc, a), b1) equals apply(apply(doc, b), a1). Inserts from a
+// win ties, which callers rely on by always passing the incoming operation
+// as a.
+func transform(a, b Op) (Op, Op, error) {
+	if a.baseLen() != b.baseLen() {
+		return nil, nil, fmt.Errorf("%w: concurrent operations have different base lengths", ErrInvalidOp)
+	}
+
+	var a1, b1 Op
+	i, j := 0, 0
+	var ca, cb component
+	hasA, hasB := false, false
+
+	next := func(op Op, idx *int, c *component, has *bool) {
+		if *idx < len(op) {
+			*c = op[*idx]
+			*idx++
+			*has = true
+			return
+		}
+		*has = false
+	}
+
+	next(a, &i, &ca, &hasA)
+	next(b, &j, &cb, &hasB)
+
+	for hasA || hasB {
+		if hasA && len(ca.insert) > 0 {
+			a1 = a1.insert(ca.insert)
+			b1 = b1.retain(len(ca.insert))
+			next(a, &i, &ca, &hasA)
+			continue
+		}
+		if hasB && len(cb.insert) > 0 {
+			a1 = a1.retain(len(cb.insert))
+			b1 = b1.insert(cb.insert)
+			next(b, &j, &cb, &hasB)
+			continue
+		}
+		if !hasA || !hasB {
+			return nil, nil, fmt.Errorf("%w: operations are incompatible", ErrInvalidOp)
+		}
+
+		la, lb := ca.retain+ca.delete, cb.retain+cb.delete
+		n := min(la, lb)
+
+		switch {
+		case ca.retain > 0 && cb.retain > 0:
+			a1 = a1.retain(n)
+			b1 = b1.retain(n)
+		case ca.delete > 0 && cb.retain > 0:
+			a1 = a1.delete(n)
+		case ca.retain > 0 && cb.delete > 0:
+			b1 = b1.delete(n)
+		}
+
+		ca = shrink(ca, n)
+		cb = shrink(cb, n)
+		if ca.retain+ca.delete == 0 {
+			next(a, &i, &ca, &hasA)
+		}
+		if cb.retain+cb.delete == 0 {
+			next(b, &j, &cb, &hasB)
+		}
+	}
+
+	return a1, b1, nil
+}
+
+func shrink(c component, n int) component {
+	if c.retain > 0 {
+		c.retain -= n
+	} else {
+		c.delete -= n
+	}
+	return c
+}
+
+// diff builds an operation turning from into to by trimming their common
+// prefix and suffix and replacing the middle.
+func diff(from, to []uint16) Op {
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix &&
+		from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+
+	var op Op
+	op = op.retain(prefix)
+	op = op.insert(to[prefix : len(to)-suffix])
+	op = op.delete(len(from) - prefix - suffix)
+	op = op.retain(suffix)
+
+	return op
+}
@@ -0,0 +1,208 @@
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+	"unicode/utf16"
+)
+
+func text(s string) []uint16 {
+	return utf16.Encode([]rune(s))
+}
+
+func str(u []uint16) string {
+	return string(utf16.Decode(u))
+}
+
+func parseOp(t *testing.T, s string) Op {
+	t.Helper()
+	var op Op
+	if err := json.Unmarshal([]byte(s), &op); err != nil {
+		t.Fatalf("failed to parse op %s: %v", s, err)
+	}
+	return op
+}
+
+func TestOp_JSONRoundTrip(t *testing.T) {
+	op := parseOp(t, `[3,"hé😀",-2,1]`)
+
+	data, err := json.Marshal(op)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != `[3,"hé😀",-2,1]` {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+	if op.baseLen() != 6 {
+		t.Errorf("expected base length 6, got %d", op.baseLen())
+	}
+}
+
+func TestOp_UnmarshalInvalid(t *testing.T) {
+	for _, s := range []string{`[0]`, `[true]`, `[{}]`} {
+		var op Op
+		if err := json.Unmarshal([]byte(s), &op); !errors.Is(err, ErrInvalidOp) {
+			t.Errorf("expected ErrInvalidOp for %s, got %v", s, err)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	got, err := apply(text("hello world"), parseOp(t, `[6,"brave new ",5]`))
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if str(got) != "hello brave new world" {
+		t.Errorf("unexpected result %q", str(got))
+	}
+
+	got, err = apply(text("hello world"), parseOp(t, `[5,-6]`))
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if str(got) != "hello" {
+		t.Errorf("unexpected result %q", str(got))
+	}
+}
+
+func TestApply_LengthMismatch(t *testing.T) {
+	if _, err := apply(text("abc"), parseOp(t, `[5]`)); !errors.Is(err, ErrInvalidOp) {
+		t.Errorf("expected ErrInvalidOp, got %v", err)
+	}
+}
+
+func TestTransform_Converges(t *testing.T) {
+	cases := []struct {
+		doc  string
+		a, b string
+		want string
+	}{
+		{"abc", `[1,"X",2]`, `[2,"Y",1]`, "aXbYc"},
+		{"abc", `[1,"X",2]`, `[1,"Y",2]`, "aXYbc"},
+		{"abcdef", `[1,-3,2]`, `[2,-3,1]`, "af"},
+		{"abcdef", `[2,"X",-2,2]`, `[1,-4,1]`, "aXf"},
+		{"", `["a"]`, `["b"]`, "ab"},
+		{"hello", `[5,"!"]`, `[-5]`, "!"},
+	}
+
+	for _, tc := range cases {
+		doc := text(tc.doc)
+		a, b := parseOp(t, tc.a), parseOp(t, tc.b)
+
+		a1, b1, err := transform(a, b)
+		if err != nil {
+			t.Fatalf("transform(%s, %s) failed: %v", tc.a, tc.b, err)
+		}
+
+		left, err := apply(doc, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if left, err = apply(left, b1); err != nil {
+			t.Fatal(err)
+		}
+
+		right, err := apply(doc, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if right, err = apply(right, a1); err != nil {
+			t.Fatal(err)
+		}
+
+		if str(left) != str(right) {
+			t.Errorf("%s vs %s on %q diverged: %q != %q", tc.a, tc.b, tc.doc, str(left), str(right))
+		}
+		if str(left) != tc.want {
+			t.Errorf("%s vs %s on %q: expected %q, got %q", tc.a, tc.b, tc.doc, tc.want, str(left))
+		}
+	}
+}
+
+func TestTransform_BaseLengthMismatch(t *testing.T) {
+	if _, _, err := transform(parseOp(t, `[3]`), parseOp(t, `[4]`)); !errors.Is(err, ErrInvalidOp) {
+		t.Errorf("expected ErrInvalidOp, got %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	cases := []struct{ from, to, want string }{
+		{"hello world", "hello brave world", `[6,"brave ",5]`},
+		{"hello world", "hello", `[5,-6]`},
+		{"abc", "abc", `[3]`},
+		{"", "new", `["new"]`},
+		{"old", "new", `["new",-3]`},
+	}
+
+	for _, tc := range cases {
+		op := diff(text(tc.from), text(tc.to))
+
+		data, _ := json.Marshal(op)
+		if string(data) != tc.want {
+			t.Errorf("diff(%q, %q): expected %s, got %s", tc.from, tc.to, tc.want, data)
+		}
+
+		got, err := apply(text(tc.from), op)
+		if err != nil {
+			t.Fatalf("apply failed: %v", err)
+		}
+		if str(got) != tc.to {
+			t.Errorf("diff(%q, %q) applied to %q", tc.from, tc.to, str(got))
+		}
+	}
+}
+
+func randomOp(r *rand.Rand, doc []uint16) Op {
+	var op Op
+	pos := 0
+	for pos < len(doc) {
+		n := 1 + r.Intn(len(doc)-pos)
+		switch r.Intn(3) {
+		case 0:
+			op = op.retain(n)
+		case 1:
+			op = op.delete(n)
+		default:
+			op = op.insert(text(fmt.Sprintf("<%d>", r.Intn(10))))
+			continue
+		}
+		pos += n
+	}
+	if r.Intn(2) == 0 {
+		op = op.insert(text("!"))
+	}
+	return op
+}
+
+func TestTransform_Random(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 2000; i++ {
+		doc := text("abcdefghij"[:r.Intn(11)])
+		a, b := randomOp(r, doc), randomOp(r, doc)
+
+		a1, b1, err := transform(a, b)
+		if err != nil {
+			t.Fatalf("transform failed: %v", err)
+		}
+
+		left, _ := apply(doc, a)
+		left, err = apply(left, b1)
+		if err != nil {
+			t.Fatalf("apply b1 failed: %v", err)
+		}
+
+		right, _ := apply(doc, b)
+		right, err = apply(right, a1)
+		if err != nil {
+			t.Fatalf("apply a1 failed: %v", err)
+		}
+
+		if str(left) != str(right) {
+			t.Fatalf("diverged on %q: %q != %q", str(doc), str(left), str(right))
+		}
+	}
+}
@@ -1,18 +1,63 @@
 package config
 
-import "os"
+import (
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
 
 const (
-	defaultPort    = "8080"
-	defaultDataDir = "/data"
+	defaultPort          = "8080"
+	defaultDataDir       = "/data"
+	defaultFileConflict  = "rename"
+	defaultQuotaPolicy   = "reject"
+	defaultMaxFileSize   = 100 << 20
+	defaultMaxTextSize   = 1 << 20
+	defaultMaxFormatSize = 10 << 20
+
+	defaultCleanupInterval = 10 * time.Minute
+	defaultMaxAge          = 24 * time.Hour
 )
 
 type Config struct {
 	Port    string
 	DataDir string
+
+	// FileConflict is what happens when an upload reuses the name of an
+	// existing file: overwrite, rename or reject.
+	FileConflict string
+
+	// StorageQuota and MaxFiles limit what the file store holds in total;
+	// zero means no limit. QuotaPolicy is what happens to an upload that
+	// does not fit: reject or evict.
+	StorageQuota int64
+	MaxFiles     int
+	QuotaPolicy  string
+
+	// MaxFileSize and MaxTextSize are the largest file upload and clip
+	// text accepted, in bytes. MaxFormatSize is the largest HTML or image
+	// representation of a clip.
+	MaxFileSize   int64
+	MaxTextSize   int64
+	MaxFormatSize int64
+
+	// CleanupInterval is how often expired items are removed. TextMaxAge
+	// and FileMaxAge are how long clips and files without a TTL of their
+	// own are kept; zero keeps them until they are deleted.
+	CleanupInterval time.Duration
+	TextMaxAge      time.Duration
+	FileMaxAge      time.Duration
+
+	// RetentionRules give files and clips they match their own max age
+	// instead of FileMaxAge or TextMaxAge.
+	RetentionRules []RetentionRule
 }
 
-func NewConfig() Config {
+func NewConfig() (Config, error) {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = defaultPort
@@ -23,8 +68,189 @@ func NewConfig() Config {
 		dataDir = defaultDataDir
 	}
 
+	fileConflict, err := parseChoice("FILE_CONFLICT", defaultFileConflict, "overwrite", "rename", "reject")
+	if err != nil {
+		return Config{}, err
+	}
+
+	storageQuota, err := parseSize(os.Getenv("STORAGE_QUOTA"))
+	if err != nil {
+		return Config{}, fmt.Errorf("STORAGE_QUOTA: %w", err)
+	}
+
+	maxFiles := 0
+	if v := os.Getenv("MAX_FILES"); v != "" {
+		maxFiles, err = strconv.Atoi(v)
+		if err != nil || maxFiles < 0 {
+			return Config{}, fmt.Errorf("MAX_FILES: %q is not a whole number", v)
+		}
+	}
+
+	quotaPolicy, err := parseChoice("QUOTA_POLICY", defaultQuotaPolicy, "reject", "evict")
+	if err != nil {
+		return Config{}, err
+	}
+
+	maxFileSize, err := parseLimit("MAX_FILE_SIZE", defaultMaxFileSize)
+	if err != nil {
+		return Config{}, err
+	}
+
+	maxTextSize, err := parseLimit("MAX_TEXT_SIZE", defaultMaxTextSize)
+	if err != nil {
+		return Config{}, err
+	}
+
+	maxFormatSize, err := parseLimit("MAX_FORMAT_SIZE", defaultMaxFormatSize)
+	if err != nil {
+		return Config{}, err
+	}
+
+	cleanupInterval, err := parseAge("CLEANUP_INTERVAL", defaultCleanupInterval)
+	if err != nil {
+		return Config{}, err
+	}
+	if cleanupInterval == 0 {
+		return Config{}, fmt.Errorf("CLEANUP_INTERVAL: must be greater than zero")
+	}
+
+	textMaxAge, err := parseAge("TEXT_MAX_AGE", defaultMaxAge)
+	if err != nil {
+		return Config{}, err
+	}
+
+	fileMaxAge, err := parseAge("FILE_MAX_AGE", defaultMaxAge)
+	if err != nil {
+		return Config{}, err
+	}
+
+	retentionRules, err := parseRetentionRules(os.Getenv("RETENTION_RULES"))
+	if err != nil {
+		return Config{}, fmt.Errorf("RETENTION_RULES: %w", err)
+	}
+
 	return Config{
-		Port:    port,
-		DataDir: dataDir,
+		Port:            port,
+		DataDir:         dataDir,
+		FileConflict:    fileConflict,
+		StorageQuota:    storageQuota,
+		MaxFiles:        maxFiles,
+		QuotaPolicy:     quotaPolicy,
+		MaxFileSize:     maxFileSize,
+		MaxTextSize:     maxTextSize,
+		MaxFormatSize:   maxFormatSize,
+		CleanupInterval: cleanupInterval,
+		TextMaxAge:      textMaxAge,
+		FileMaxAge:      fileMaxAge,
+		RetentionRules:  retentionRules,
+	}, nil
+}
+
+// parseAge reads a duration from the environment. It may be zero but not
+// negative.
+func parseAge(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
 	}
+
+	d, err := parseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+
+	return d, nil
+}
+
+// parseDuration reads a duration such as 90s, 12h or 7d.
+func parseDuration(v string) (time.Duration, error) {
+	s := strings.TrimSpace(v)
+
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int64
+		n, err = strconv.ParseInt(days, 10, 64)
+		if n > int64(math.MaxInt64/(24*time.Hour)) {
+			err = strconv.ErrRange
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%q is not a duration such as 30m, 12h or 7d", v)
+	}
+
+	return d, nil
+}
+
+// parseChoice reads a setting from the environment that takes one of a
+// fixed set of values.
+func parseChoice(key, def string, choices ...string) (string, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+
+	if !slices.Contains(choices, v) {
+		return "", fmt.Errorf("%s: %q is not one of %s", key, v, strings.Join(choices, ", "))
+	}
+
+	return v, nil
+}
+
+// parseLimit reads a size limit from the environment. Unlike a quota, a
+// limit cannot be switched off.
+func parseLimit(key string, def int64) (int64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+
+	n, err := parseSize(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%s: must be greater than zero", key)
+	}
+
+	return n, nil
+}
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	// Longest suffixes first so that "MB" is not read as "B".
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize reads a byte count such as 1048576, 512MB or 1GB. Units are
+// powers of 1024. An empty string is zero.
+func parseSize(v string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	if s == "" {
+		return 0, nil
+	}
+
+	factor := int64(1)
+	for _, u := range sizeUnits {
+		if num, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, factor = strings.TrimSpace(num), u.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > (1<<63-1)/factor {
+		return 0, fmt.Errorf("%q is not a size such as 500MB or 2GB", v)
+	}
+
+	return n * factor, nil
 }
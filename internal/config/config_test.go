@@ -2,14 +2,30 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewConfig_Defaults(t *testing.T) {
 	os.Unsetenv("PORT")
 	os.Unsetenv("DATA_DIR")
+	os.Unsetenv("FILE_CONFLICT")
+	os.Unsetenv("STORAGE_QUOTA")
+	os.Unsetenv("MAX_FILES")
+	os.Unsetenv("QUOTA_POLICY")
+	os.Unsetenv("MAX_FILE_SIZE")
+	os.Unsetenv("MAX_TEXT_SIZE")
+	os.Unsetenv("MAX_FORMAT_SIZE")
+	os.Unsetenv("CLEANUP_INTERVAL")
+	os.Unsetenv("TEXT_MAX_AGE")
+	os.Unsetenv("FILE_MAX_AGE")
+	os.Unsetenv("RETENTION_RULES")
 
-	cfg := NewConfig()
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig failed: %v", err)
+	}
 
 	if cfg.Port != defaultPort {
 		t.Errorf("expected port %q, got %q", defaultPort, cfg.Port)
@@ -17,13 +33,47 @@ func TestNewConfig_Defaults(t *testing.T) {
 	if cfg.DataDir != defaultDataDir {
 		t.Errorf("expected data dir %q, got %q", defaultDataDir, cfg.DataDir)
 	}
+	if cfg.FileConflict != defaultFileConflict {
+		t.Errorf("expected file conflict %q, got %q", defaultFileConflict, cfg.FileConflict)
+	}
+	if cfg.StorageQuota != 0 || cfg.MaxFiles != 0 {
+		t.Errorf("expected no quota, got %d bytes and %d files", cfg.StorageQuota, cfg.MaxFiles)
+	}
+	if cfg.QuotaPolicy != defaultQuotaPolicy {
+		t.Errorf("expected quota policy %q, got %q", defaultQuotaPolicy, cfg.QuotaPolicy)
+	}
+	if cfg.MaxFileSize != defaultMaxFileSize || cfg.MaxTextSize != defaultMaxTextSize || cfg.MaxFormatSize != defaultMaxFormatSize {
+		t.Errorf("expected default size limits, got %d, %d and %d", cfg.MaxFileSize, cfg.MaxTextSize, cfg.MaxFormatSize)
+	}
+	if cfg.CleanupInterval != defaultCleanupInterval {
+		t.Errorf("expected cleanup interval %v, got %v", defaultCleanupInterval, cfg.CleanupInterval)
+	}
+	if cfg.TextMaxAge != defaultMaxAge || cfg.FileMaxAge != defaultMaxAge {
+		t.Errorf("expected default max ages, got %v and %v", cfg.TextMaxAge, cfg.FileMaxAge)
+	}
+	if cfg.RetentionRules != nil {
+		t.Errorf("expected no retention rules, got %+v", cfg.RetentionRules)
+	}
 }
 
 func TestNewConfig_CustomValues(t *testing.T) {
 	t.Setenv("PORT", "9090")
 	t.Setenv("DATA_DIR", "/tmp/custom")
+	t.Setenv("FILE_CONFLICT", "reject")
+	t.Setenv("STORAGE_QUOTA", "900MB")
+	t.Setenv("MAX_FILES", "500")
+	t.Setenv("QUOTA_POLICY", "evict")
+	t.Setenv("MAX_FILE_SIZE", "2GB")
+	t.Setenv("MAX_TEXT_SIZE", "64KB")
+	t.Setenv("MAX_FORMAT_SIZE", "20MB")
+	t.Setenv("CLEANUP_INTERVAL", "1m")
+	t.Setenv("TEXT_MAX_AGE", "0")
+	t.Setenv("FILE_MAX_AGE", "7d")
 
-	cfg := NewConfig()
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig failed: %v", err)
+	}
 
 	if cfg.Port != "9090" {
 		t.Errorf("expected port %q, got %q", "9090", cfg.Port)
@@ -31,13 +81,117 @@ func TestNewConfig_CustomValues(t *testing.T) {
 	if cfg.DataDir != "/tmp/custom" {
 		t.Errorf("expected data dir %q, got %q", "/tmp/custom", cfg.DataDir)
 	}
+	if cfg.FileConflict != "reject" {
+		t.Errorf("expected file conflict %q, got %q", "reject", cfg.FileConflict)
+	}
+	if cfg.StorageQuota != 900<<20 {
+		t.Errorf("expected quota of 900 MB, got %d", cfg.StorageQuota)
+	}
+	if cfg.MaxFiles != 500 {
+		t.Errorf("expected 500 files, got %d", cfg.MaxFiles)
+	}
+	if cfg.QuotaPolicy != "evict" {
+		t.Errorf("expected quota policy %q, got %q", "evict", cfg.QuotaPolicy)
+	}
+	if cfg.MaxFileSize != 2<<30 {
+		t.Errorf("expected 2 GB file limit, got %d", cfg.MaxFileSize)
+	}
+	if cfg.MaxTextSize != 64<<10 {
+		t.Errorf("expected 64 KB text limit, got %d", cfg.MaxTextSize)
+	}
+	if cfg.MaxFormatSize != 20<<20 {
+		t.Errorf("expected 20 MB format limit, got %d", cfg.MaxFormatSize)
+	}
+	if cfg.CleanupInterval != time.Minute {
+		t.Errorf("expected cleanup interval 1m, got %v", cfg.CleanupInterval)
+	}
+	if cfg.TextMaxAge != 0 {
+		t.Errorf("expected text to never expire, got %v", cfg.TextMaxAge)
+	}
+	if cfg.FileMaxAge != 7*24*time.Hour {
+		t.Errorf("expected files to expire after 7 days, got %v", cfg.FileMaxAge)
+	}
+}
+
+func TestNewConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		key, value string
+	}{
+		{"FILE_CONFLICT", "merge"},
+		{"FILE_CONFLICT", "Rename"},
+		{"STORAGE_QUOTA", "lots"},
+		{"STORAGE_QUOTA", "-1GB"},
+		{"MAX_FILES", "ten"},
+		{"MAX_FILES", "-5"},
+		{"QUOTA_POLICY", "delete"},
+		{"MAX_FILE_SIZE", "0"},
+		{"MAX_TEXT_SIZE", "huge"},
+		{"MAX_FORMAT_SIZE", "0"},
+		{"CLEANUP_INTERVAL", "0"},
+		{"CLEANUP_INTERVAL", "often"},
+		{"TEXT_MAX_AGE", "-1h"},
+		{"FILE_MAX_AGE", "1w"},
+		{"FILE_MAX_AGE", "-2d"},
+		{"FILE_MAX_AGE", "999999999d"},
+		{"RETENTION_RULES", `{"maxAge": "1h"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+
+			_, err := NewConfig()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.HasPrefix(err.Error(), tt.key+": ") {
+				t.Errorf("expected the error to name %s, got %q", tt.key, err)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"", 0},
+		{"0", 0},
+		{"1048576", 1 << 20},
+		{"512B", 512},
+		{"64KB", 64 << 10},
+		{"100MB", 100 << 20},
+		{"1gb", 1 << 30},
+		{"2 TB", 2 << 40},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if err != nil {
+			t.Errorf("parseSize(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"MB", "1.5GB", "10XB", "99999999999TB"} {
+		if _, err := parseSize(in); err == nil {
+			t.Errorf("parseSize(%q) should fail", in)
+		}
+	}
 }
 
 func TestNewConfig_PartialOverride(t *testing.T) {
 	t.Setenv("PORT", "3000")
 	os.Unsetenv("DATA_DIR")
 
-	cfg := NewConfig()
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig failed: %v", err)
+	}
 
 	if cfg.Port != "3000" {
 		t.Errorf("expected port %q, got %q", "3000", cfg.Port)
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Kinds of item a RetentionRule applies to, named as in the cleanup log.
+const (
+	RetentionFiles = "file"
+	RetentionClips = "text"
+)
+
+// RetentionRule gives files or clips matching every criterion it sets their
+// own max age. Zero sizes are no bound and a zero MaxAge keeps the items.
+// Extensions only apply to files and Clips, a list of clip names, only to
+// clips.
+type RetentionRule struct {
+	Name         string
+	Kind         string
+	Clips        []string
+	MinSize      int64
+	MaxSize      int64
+	Extensions   []string
+	ContentTypes []string
+	MaxAge       time.Duration
+}
+
+// retentionRuleJSON is how a rule is written in RETENTION_RULES, with sizes
+// and durations in the same notation as the other variables.
+type retentionRuleJSON struct {
+	Name         string   `json:"name"`
+	Kind         string   `json:"kind"`
+	Clips        []string `json:"clips"`
+	MinSize      string   `json:"minSize"`
+	MaxSize      string   `json:"maxSize"`
+	Extensions   []string `json:"extensions"`
+	ContentTypes []string `json:"contentTypes"`
+	MaxAge       *string  `json:"maxAge"`
+}
+
+// parseRetentionRules reads a JSON list of rules such as
+//
+//	[{"name": "videos", "contentTypes": ["video/*"], "maxAge": "1h"},
+//	 {"extensions": [".pdf"], "maxSize": "10MB", "maxAge": "7d"},
+//	 {"kind": "text", "clips": ["scratch"], "maxAge": "1h"}]
+//
+// Rules apply to files unless their kind is "text". An empty string is no
+// rules.
+func parseRetentionRules(v string) ([]RetentionRule, error) {
+	if strings.TrimSpace(v) == "" {
+		return nil, nil
+	}
+
+	dec := json.NewDecoder(strings.NewReader(v))
+	dec.DisallowUnknownFields()
+
+	var raw []retentionRuleJSON
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("not a JSON list of rules: %w", err)
+	}
+
+	rules := make([]RetentionRule, 0, len(raw))
+	for i, r := range raw {
+		rule, err := r.rule()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (r retentionRuleJSON) rule() (RetentionRule, error) {
+	if r.MaxAge == nil {
+		return RetentionRule{}, errors.New("maxAge is required")
+	}
+
+	kind := r.Kind
+	switch kind {
+	case "":
+		kind = RetentionFiles
+	case RetentionFiles, RetentionClips:
+	default:
+		return RetentionRule{}, fmt.Errorf("unknown kind %q, use %q or %q", r.Kind, RetentionFiles, RetentionClips)
+	}
+
+	sized := r.MinSize != "" || r.MaxSize != "" || len(r.ContentTypes) > 0
+	if kind == RetentionFiles {
+		if len(r.Clips) > 0 {
+			return RetentionRule{}, errors.New("clips only apply to rules of kind \"text\"")
+		}
+		if !sized && len(r.Extensions) == 0 {
+			return RetentionRule{}, errors.New("needs a size, extension or content type to match")
+		}
+	} else {
+		if len(r.Extensions) > 0 {
+			return RetentionRule{}, errors.New("extensions only apply to files")
+		}
+		if !sized && len(r.Clips) == 0 {
+			return RetentionRule{}, errors.New("needs a clip name, size or content type to match")
+		}
+	}
+
+	maxAge, err := parseDuration(*r.MaxAge)
+	if err != nil {
+		return RetentionRule{}, fmt.Errorf("maxAge: %w", err)
+	}
+
+	minSize, err := parseSize(r.MinSize)
+	if err != nil {
+		return RetentionRule{}, fmt.Errorf("minSize: %w", err)
+	}
+
+	maxSize, err := parseSize(r.MaxSize)
+	if err != nil {
+		return RetentionRule{}, fmt.Errorf("maxSize: %w", err)
+	}
+	if maxSize > 0 && minSize > maxSize {
+		return RetentionRule{}, errors.New("minSize is larger than maxSize")
+	}
+
+	return RetentionRule{
+		Name:         r.Name,
+		Kind:         kind,
+		Clips:        r.Clips,
+		MinSize:      minSize,
+		MaxSize:      maxSize,
+		Extensions:   r.Extensions,
+		ContentTypes: r.ContentTypes,
+		MaxAge:       maxAge,
+	}, nil
+}
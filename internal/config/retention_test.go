@@ -0,0 +1,68 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRetentionRules(t *testing.T) {
+	rules, err := parseRetentionRules(`[
+		{"name": "videos", "contentTypes": ["video/*"], "minSize": "100MB", "maxAge": "1h"},
+		{"extensions": [".pdf"], "maxSize": "10MB", "maxAge": "7d"},
+		{"extensions": [".log"], "maxAge": "0"},
+		{"kind": "text", "clips": ["scratch"], "maxAge": "1h"},
+		{"kind": "text", "contentTypes": ["image/*"], "maxAge": "1d"}
+	]`)
+	if err != nil {
+		t.Fatalf("parseRetentionRules failed: %v", err)
+	}
+
+	want := []RetentionRule{
+		{Name: "videos", Kind: RetentionFiles, ContentTypes: []string{"video/*"}, MinSize: 100 << 20, MaxAge: time.Hour},
+		{Name: "rule 2", Kind: RetentionFiles, Extensions: []string{".pdf"}, MaxSize: 10 << 20, MaxAge: 7 * 24 * time.Hour},
+		{Name: "rule 3", Kind: RetentionFiles, Extensions: []string{".log"}},
+		{Name: "rule 4", Kind: RetentionClips, Clips: []string{"scratch"}, MaxAge: time.Hour},
+		{Name: "rule 5", Kind: RetentionClips, ContentTypes: []string{"image/*"}, MaxAge: 24 * time.Hour},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("got %+v, want %+v", rules, want)
+	}
+}
+
+func TestParseRetentionRules_Empty(t *testing.T) {
+	for _, in := range []string{"", "  ", "[]"} {
+		rules, err := parseRetentionRules(in)
+		if err != nil || len(rules) != 0 {
+			t.Errorf("parseRetentionRules(%q) = %v, %v", in, rules, err)
+		}
+	}
+}
+
+func TestParseRetentionRules_Invalid(t *testing.T) {
+	tests := []struct {
+		name, in string
+	}{
+		{"not json", `videos=1h`},
+		{"not a list", `{"extensions": [".mp4"], "maxAge": "1h"}`},
+		{"unknown field", `[{"mimeType": "video/*", "maxAge": "1h"}]`},
+		{"missing max age", `[{"extensions": [".mp4"]}]`},
+		{"invalid max age", `[{"extensions": [".mp4"], "maxAge": "soon"}]`},
+		{"negative max age", `[{"extensions": [".mp4"], "maxAge": "-1h"}]`},
+		{"invalid size", `[{"minSize": "big", "maxAge": "1h"}]`},
+		{"min above max", `[{"minSize": "2GB", "maxSize": "1GB", "maxAge": "1h"}]`},
+		{"no criteria", `[{"name": "everything", "maxAge": "1h"}]`},
+		{"unknown kind", `[{"kind": "folder", "minSize": "1MB", "maxAge": "1h"}]`},
+		{"clips in a file rule", `[{"clips": ["scratch"], "maxAge": "1h"}]`},
+		{"extensions in a clip rule", `[{"kind": "text", "extensions": [".txt"], "maxAge": "1h"}]`},
+		{"no clip criteria", `[{"kind": "text", "maxAge": "1h"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseRetentionRules(tt.in); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package events
+
+import "sync"
+
+type Type string
+
+const (
+	TextUpdated Type = "text.updated"
+	FileCreated Type = "file.created"
+	FileDeleted Type = "file.deleted"
+	FileUpdated Type = "file.updated"
+	ItemExpired Type = "item.expired"
+)
+
+const subscriberBuffer = 64
+
+type Event struct {
+	ID   uint64
+	Type Type
+	Data any
+}
+
+type Bus struct {
+	mu      sync.Mutex
+	lastID  uint64
+	backlog []Event
+	size    int
+	subs    map[chan Event]struct{}
+}
+
+func NewBus(backlog int) *Bus {
+	return &Bus{
+		size: backlog,
+		subs: make(map[chan Event]struct{}),
+	}
+}
+
+func (b *Bus) Publish(t Type, data any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e := Event{ID: b.lastID, Type: t, Data: data}
+
+	if b.size > 0 {
+		b.backlog = append(b.backlog, e)
+		if len(b.backlog) > b.size {
+			b.backlog = b.backlog[len(b.backlog)-b.size:]
+		}
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			// The subscriber fell behind; drop it so it reconnects and
+			// resumes from its last event ID instead of stalling publishers.
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the buffered events published after lastID followed by a
+// channel of live events. The channel is closed if the subscriber falls
+// behind. The returned function must be called to unsubscribe.
+func (b *Bus) Subscribe(lastID uint64) ([]Event, <-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []Event
+	if lastID > 0 {
+		for _, e := range b.backlog {
+			if e.ID > lastID {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	b.subs[ch] = struct{}{}
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+
+	return replay, ch, cancel
+}
@@ -0,0 +1,108 @@
+package events
+
+import "testing"
+
+func TestBus_PublishDeliversToSubscribers(t *testing.T) {
+	b := NewBus(10)
+
+	_, ch1, cancel1 := b.Subscribe(0)
+	defer cancel1()
+	_, ch2, cancel2 := b.Subscribe(0)
+	defer cancel2()
+
+	b.Publish(TextUpdated, "hello")
+
+	for _, ch := range []<-chan Event{ch1, ch2} {
+		e := <-ch
+		if e.Type != TextUpdated {
+			t.Errorf("expected type %q, got %q", TextUpdated, e.Type)
+		}
+		if e.ID != 1 {
+			t.Errorf("expected ID 1, got %d", e.ID)
+		}
+		if e.Data != "hello" {
+			t.Errorf("expected data %q, got %v", "hello", e.Data)
+		}
+	}
+}
+
+func TestBus_SubscribeReplaysAfterLastID(t *testing.T) {
+	b := NewBus(10)
+
+	b.Publish(FileCreated, "a")
+	b.Publish(FileCreated, "b")
+	b.Publish(FileDeleted, "a")
+
+	replay, _, cancel := b.Subscribe(1)
+	defer cancel()
+
+	if len(replay) != 2 {
+		t.Fatalf("expected 2 replayed events, got %d", len(replay))
+	}
+	if replay[0].ID != 2 || replay[1].ID != 3 {
+		t.Errorf("expected IDs 2 and 3, got %d and %d", replay[0].ID, replay[1].ID)
+	}
+}
+
+func TestBus_SubscribeWithoutLastIDSkipsBacklog(t *testing.T) {
+	b := NewBus(10)
+	b.Publish(FileCreated, "a")
+
+	replay, _, cancel := b.Subscribe(0)
+	defer cancel()
+
+	if len(replay) != 0 {
+		t.Errorf("expected no replay, got %d events", len(replay))
+	}
+}
+
+func TestBus_BacklogBounded(t *testing.T) {
+	b := NewBus(3)
+	for i := 0; i < 10; i++ {
+		b.Publish(TextUpdated, i)
+	}
+
+	replay, _, cancel := b.Subscribe(1)
+	defer cancel()
+
+	if len(replay) != 3 {
+		t.Fatalf("expected 3 replayed events, got %d", len(replay))
+	}
+	if replay[0].ID != 8 {
+		t.Errorf("expected oldest retained ID 8, got %d", replay[0].ID)
+	}
+}
+
+func TestBus_SlowSubscriberDropped(t *testing.T) {
+	b := NewBus(0)
+
+	_, ch, cancel := b.Subscribe(0)
+	defer cancel()
+
+	for i := 0; i < subscriberBuffer+1; i++ {
+		b.Publish(TextUpdated, i)
+	}
+
+	n := 0
+	for range ch {
+		n++
+	}
+
+	if n != subscriberBuffer {
+		t.Errorf("expected %d buffered events before close, got %d", subscriberBuffer, n)
+	}
+}
+
+func TestBus_CancelClosesChannel(t *testing.T) {
+	b := NewBus(0)
+
+	_, ch, cancel := b.Subscribe(0)
+	cancel()
+	cancel()
+
+	if _, ok := <-ch; ok {
+		t.Error("expected channel to be closed")
+	}
+
+	b.Publish(TextUpdated, nil)
+}
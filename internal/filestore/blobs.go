@@ -0,0 +1,179 @@
+package filestore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidHash = errors.New("invalid sha256: expected 64 hex characters")
+
+// File contents are stored once per SHA-256 in blobDir. Every name in the
+// files directory is a hard link to its blob, so reading a file never has to
+// look at the blob directory, and refs counts the names that use each blob
+// so the blob can go with the last of them.
+
+// HasContent reports whether a file with the given SHA-256 is stored.
+// Burn-after-reading files do not count, so their hashes cannot be tested.
+func (s *Store) HasContent(_ context.Context, sha256 string) (bool, error) {
+	if !validHash(sha256) {
+		return false, ErrInvalidHash
+	}
+
+	s.blobMu.Lock()
+	stored := s.refs[sha256] > 0
+	s.blobMu.Unlock()
+
+	if !stored {
+		return false, nil
+	}
+
+	files, err := s.list()
+	if err != nil {
+		return false, err
+	}
+
+	for _, f := range files {
+		if f.SHA256 == sha256 && !f.BurnAfterReading {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// link makes name refer to the blob with the given hash, creating the blob
+// with commit unless it is already stored, in which case discard is called
+// instead. An existing file called name is replaced. The caller holds the
+// name lock.
+func (s *Store) link(name, sha256 string, commit func(blob string) error, discard func()) error {
+	blob := s.blobPath(sha256)
+
+	if err := s.addRef(sha256, commit, discard); err != nil {
+		return err
+	}
+
+	// Link under a temporary name and rename it into place so that an
+	// existing file is replaced atomically.
+	tmp, err := os.CreateTemp(s.tmpDir, ".tmp-*")
+	if err != nil {
+		s.dropRef(sha256)
+		return err
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+
+	if err := os.Link(blob, tmp.Name()); err != nil {
+		s.dropRef(sha256)
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		s.dropRef(sha256)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) addRef(sha256 string, commit func(blob string) error, discard func()) error {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	blob := s.blobPath(sha256)
+
+	// A blob without references can be left from a crash; blobs are
+	// written atomically, so its content is still good.
+	if _, err := os.Stat(blob); err == nil {
+		discard()
+	} else if err := commit(blob); err != nil {
+		return err
+	}
+
+	s.refs[sha256]++
+
+	return nil
+}
+
+// dropRef releases one name's reference and removes the blob and its
+// thumbnails once nothing refers to it. Files stored before checksums were
+// recorded have no hash and are ignored.
+func (s *Store) dropRef(sha256 string) error {
+	if sha256 == "" {
+		return nil
+	}
+
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	if s.refs[sha256]--; s.refs[sha256] > 0 {
+		return nil
+	}
+	delete(s.refs, sha256)
+
+	var errs []error
+	if err := os.Remove(s.blobPath(sha256)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, err)
+	}
+	if err := s.removeThumbs(sha256); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// loadRefs counts the names that use each blob and removes blobs nobody
+// uses. It runs before the store is shared.
+func (s *Store) loadRefs() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		// Undercounting a blob with a damaged sidecar only costs
+		// deduplication: the name keeps its own link to the content.
+		// Files hashed before deduplication have no blob but count all
+		// the same, as their content is stored either way.
+		m, _ := s.readMeta(e.Name())
+		if m.SHA256 != "" {
+			s.refs[m.SHA256]++
+		}
+	}
+
+	blobs, err := os.ReadDir(s.blobDir)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, e := range blobs {
+		if s.refs[e.Name()] > 0 {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.blobDir, e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *Store) blobPath(sha256 string) string {
+	return filepath.Join(s.blobDir, sha256)
+}
+
+func validHash(sha256 string) bool {
+	if len(sha256) != 64 || strings.ToLower(sha256) != sha256 {
+		return false
+	}
+	_, err := hex.DecodeString(sha256)
+	return err == nil
+}
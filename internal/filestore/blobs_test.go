@@ -0,0 +1,200 @@
+package filestore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func countBlobs(t *testing.T, s *Store) int {
+	t.Helper()
+	entries, err := os.ReadDir(s.blobDir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	return len(entries)
+}
+
+func TestStore_DeduplicatesIdenticalUploads(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	a, err := s.Save(ctx, "installer.exe", strings.NewReader("same bytes"), -1, SaveOptions{})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	b, err := s.Save(ctx, "installer-copy.exe", strings.NewReader("same bytes"), -1, SaveOptions{})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	if a.SHA256 != b.SHA256 {
+		t.Fatalf("expected identical hashes, got %q and %q", a.SHA256, b.SHA256)
+	}
+	if n := countBlobs(t, s); n != 1 {
+		t.Errorf("expected 1 blob, got %d", n)
+	}
+
+	fa, _ := os.Stat(filepath.Join(s.dir, "installer.exe"))
+	fb, _ := os.Stat(filepath.Join(s.dir, "installer-copy.exe"))
+	if !os.SameFile(fa, fb) {
+		t.Error("expected both names to share the stored content")
+	}
+
+	if err := s.Delete(ctx, "installer.exe"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if n := countBlobs(t, s); n != 1 {
+		t.Errorf("expected blob kept while referenced, got %d blobs", n)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(s.dir, "installer-copy.exe"))
+	if string(data) != "same bytes" {
+		t.Errorf("expected remaining copy intact, got %q", data)
+	}
+
+	if err := s.Delete(ctx, "installer-copy.exe"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if n := countBlobs(t, s); n != 0 {
+		t.Errorf("expected blob removed with last name, got %d blobs", n)
+	}
+}
+
+func TestStore_OverwriteReleasesOldBlob(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	s.Save(ctx, "a.txt", strings.NewReader("v1"), -1, SaveOptions{})
+	s.Save(ctx, "a.txt", strings.NewReader("v2"), -1, SaveOptions{OnConflict: ConflictOverwrite})
+
+	if n := countBlobs(t, s); n != 1 {
+		t.Errorf("expected only the new blob, got %d", n)
+	}
+
+	// Overwriting with the same content keeps it.
+	s.Save(ctx, "a.txt", strings.NewReader("v2"), -1, SaveOptions{OnConflict: ConflictOverwrite})
+	if n := countBlobs(t, s); n != 1 {
+		t.Errorf("expected blob kept, got %d", n)
+	}
+	data, _ := os.ReadFile(filepath.Join(s.dir, "a.txt"))
+	if string(data) != "v2" {
+		t.Errorf("expected v2, got %q", data)
+	}
+}
+
+func TestStore_CleanupKeepsSharedBlob(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	s.Save(ctx, "old.jpg", strings.NewReader("photo"), -1, SaveOptions{})
+	s.Save(ctx, "new.jpg", strings.NewReader("photo"), -1, SaveOptions{})
+	backdate(t, s, "old.jpg", time.Now().Add(-2*time.Hour))
+
+	res, err := s.Cleanup(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if len(res.Removed) != 1 {
+		t.Fatalf("expected old.jpg removed, got %v", res.Removed)
+	}
+	if n := countBlobs(t, s); n != 1 {
+		t.Errorf("expected shared blob kept, got %d", n)
+	}
+
+	backdate(t, s, "new.jpg", time.Now().Add(-2*time.Hour))
+	s.Cleanup(ctx, time.Hour)
+	if n := countBlobs(t, s); n != 0 {
+		t.Errorf("expected blob removed, got %d", n)
+	}
+}
+
+func TestStore_BurnReleasesBlob(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	s.Save(ctx, "secret.txt", strings.NewReader("psst"), -1, SaveOptions{BurnAfterReading: true})
+
+	f, _, err := s.Open(ctx, "secret.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	f.Close()
+
+	if n := countBlobs(t, s); n != 0 {
+		t.Errorf("expected blob removed after burn, got %d", n)
+	}
+}
+
+func TestStore_UploadDeduplicates(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	s.Save(ctx, "a.bin", strings.NewReader("chunky"), -1, SaveOptions{})
+
+	u, _ := s.CreateUpload(ctx, "b.bin", 6, SaveOptions{})
+	if _, err := s.WriteUpload(ctx, u.ID, 0, strings.NewReader("chunky")); err != nil {
+		t.Fatalf("WriteUpload failed: %v", err)
+	}
+
+	if n := countBlobs(t, s); n != 1 {
+		t.Errorf("expected 1 blob, got %d", n)
+	}
+	if _, err := os.Stat(s.partPath(u.ID)); !os.IsNotExist(err) {
+		t.Errorf("expected partial data moved out of the upload area, got %v", err)
+	}
+}
+
+func TestNewStore_RebuildsReferences(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir, &mockPublisher{}, Options{})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	ctx := context.Background()
+
+	s.Save(ctx, "a.txt", strings.NewReader("shared"), -1, SaveOptions{})
+	s.Save(ctx, "b.txt", strings.NewReader("shared"), -1, SaveOptions{})
+	orphan := filepath.Join(s.blobDir, strings.Repeat("0", 64))
+	os.WriteFile(orphan, []byte("orphan"), 0o644)
+
+	s, err = NewStore(dir, &mockPublisher{}, Options{})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("expected unreferenced blob removed, got %v", err)
+	}
+
+	s.Delete(ctx, "a.txt")
+	if n := countBlobs(t, s); n != 1 {
+		t.Errorf("expected blob kept for b.txt, got %d", n)
+	}
+}
+
+func TestStore_HasContent(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	info, _ := s.Save(ctx, "a.txt", strings.NewReader("a"), -1, SaveOptions{})
+
+	ok, err := s.HasContent(ctx, info.SHA256)
+	if err != nil || !ok {
+		t.Errorf("expected stored content, got %v, %v", ok, err)
+	}
+
+	ok, err = s.HasContent(ctx, strings.Repeat("a", 64))
+	if err != nil || ok {
+		t.Errorf("expected unknown content, got %v, %v", ok, err)
+	}
+
+	for _, bad := range []string{"", "abc", strings.Repeat("A", 64), strings.Repeat("g", 64)} {
+		if _, err := s.HasContent(ctx, bad); err != ErrInvalidHash {
+			t.Errorf("HasContent(%q): expected ErrInvalidHash, got %v", bad, err)
+		}
+	}
+}
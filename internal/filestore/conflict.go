@@ -0,0 +1,95 @@
+package filestore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConflictPolicy decides what happens when a file is uploaded under a name
+// that is already taken.
+type ConflictPolicy string
+
+const (
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictRename    ConflictPolicy = "rename"
+	ConflictReject    ConflictPolicy = "reject"
+)
+
+var (
+	ErrExists        = errors.New("a file with this name already exists")
+	ErrInvalidPolicy = errors.New("conflict policy must be overwrite, rename or reject")
+)
+
+// ParseConflictPolicy accepts the policy names used in configuration and
+// requests. An empty string yields the zero policy, which means the store
+// default.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case "", ConflictOverwrite, ConflictRename, ConflictReject:
+		return p, nil
+	default:
+		return "", ErrInvalidPolicy
+	}
+}
+
+func (s *Store) policy(p ConflictPolicy) ConflictPolicy {
+	if p == "" {
+		return s.conflict
+	}
+	return p
+}
+
+// claim picks the name a new file is published under and locks it. With
+// ConflictRename it tries "name (1).ext", "name (2).ext" and so on until it
+// finds a free one.
+func (s *Store) claim(name string, p ConflictPolicy) (string, func(), error) {
+	for i := 0; ; i++ {
+		candidate := numbered(name, i)
+
+		unlock := s.lockName(candidate)
+
+		_, err := os.Stat(filepath.Join(s.dir, candidate))
+		if errors.Is(err, os.ErrNotExist) {
+			return candidate, unlock, nil
+		}
+		if err != nil {
+			unlock()
+			return "", nil, err
+		}
+
+		switch p {
+		case ConflictOverwrite:
+			return candidate, unlock, nil
+		case ConflictReject:
+			unlock()
+			return "", nil, ErrExists
+		}
+
+		unlock()
+	}
+}
+
+// taken is a lock-free early check so that uploads that are going to be
+// rejected are not streamed to disk first.
+func (s *Store) taken(name string) bool {
+	_, err := os.Stat(filepath.Join(s.dir, name))
+	return err == nil
+}
+
+func numbered(name string, n int) string {
+	if n == 0 {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if base == "" {
+		// Dotfiles such as ".env" have no extension to keep.
+		base, ext = name, ""
+	}
+
+	return fmt.Sprintf("%s (%d)%s", base, n, ext)
+}
@@ -0,0 +1,144 @@
+package filestore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStore_SaveConflictPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  ConflictPolicy
+		want    string
+		wantErr error
+		content string
+	}{
+		{"default renames", "", "IMG_0001 (1).jpg", nil, "old"},
+		{"rename", ConflictRename, "IMG_0001 (1).jpg", nil, "old"},
+		{"overwrite", ConflictOverwrite, "IMG_0001.jpg", nil, "new"},
+		{"reject", ConflictReject, "", ErrExists, "old"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			ctx := context.Background()
+
+			if _, err := s.Save(ctx, "IMG_0001.jpg", strings.NewReader("old"), 3, SaveOptions{}); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+
+			info, err := s.Save(ctx, "IMG_0001.jpg", strings.NewReader("new"), 3, SaveOptions{OnConflict: tt.policy})
+			if err != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if info.Name != tt.want {
+				t.Errorf("expected final name %q, got %q", tt.want, info.Name)
+			}
+
+			data, _ := os.ReadFile(filepath.Join(s.dir, "IMG_0001.jpg"))
+			if string(data) != tt.content {
+				t.Errorf("expected original name to hold %q, got %q", tt.content, data)
+			}
+
+			leftovers, _ := os.ReadDir(s.tmpDir)
+			if len(leftovers) != 0 {
+				t.Errorf("expected no temp files, got %d", len(leftovers))
+			}
+		})
+	}
+}
+
+func TestStore_SaveStoreDefaultPolicy(t *testing.T) {
+	s, err := NewStore(t.TempDir(), &mockPublisher{}, Options{OnConflict: ConflictReject})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	ctx := context.Background()
+
+	s.Save(ctx, "a.txt", strings.NewReader("a"), 1, SaveOptions{})
+
+	if _, err := s.Save(ctx, "a.txt", strings.NewReader("b"), 1, SaveOptions{}); err != ErrExists {
+		t.Errorf("expected ErrExists from store default, got %v", err)
+	}
+	if _, err := s.Save(ctx, "a.txt", strings.NewReader("b"), 1, SaveOptions{OnConflict: ConflictOverwrite}); err != nil {
+		t.Errorf("expected per-upload policy to win, got %v", err)
+	}
+}
+
+func TestStore_SaveConcurrentRenames(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			if _, err := s.Save(ctx, "photo.jpg", strings.NewReader("x"), 1, SaveOptions{}); err != nil {
+				t.Errorf("Save failed: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	files, _ := s.List(ctx)
+	if len(files) != 10 {
+		t.Errorf("expected every upload to keep its own name, got %d files", len(files))
+	}
+}
+
+func TestStore_UploadConflictReject(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	u, err := s.CreateUpload(ctx, "a.txt", 1, SaveOptions{OnConflict: ConflictReject})
+	if err != nil {
+		t.Fatalf("CreateUpload failed: %v", err)
+	}
+
+	// Someone else takes the name while the upload is in progress.
+	s.Save(ctx, "a.txt", strings.NewReader("a"), 1, SaveOptions{})
+
+	if _, err := s.WriteUpload(ctx, u.ID, 0, strings.NewReader("b")); err != ErrExists {
+		t.Fatalf("expected ErrExists, got %v", err)
+	}
+	if _, err := s.Upload(ctx, u.ID); err != ErrUploadNotFound {
+		t.Errorf("expected rejected upload to be discarded, got %v", err)
+	}
+	if _, err := s.CreateUpload(ctx, "a.txt", 1, SaveOptions{OnConflict: ConflictReject}); err != ErrExists {
+		t.Errorf("expected ErrExists on creation, got %v", err)
+	}
+}
+
+func TestNumbered(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		want string
+	}{
+		{"IMG_0001.jpg", 0, "IMG_0001.jpg"},
+		{"IMG_0001.jpg", 1, "IMG_0001 (1).jpg"},
+		{"notes", 2, "notes (2)"},
+		{".env", 1, ".env (1)"},
+	}
+
+	for _, tt := range tests {
+		if got := numbered(tt.name, tt.n); got != tt.want {
+			t.Errorf("numbered(%q, %d) = %q, want %q", tt.name, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	for _, v := range []string{"", "overwrite", "rename", "reject"} {
+		if _, err := ParseConflictPolicy(v); err != nil {
+			t.Errorf("ParseConflictPolicy(%q) failed: %v", v, err)
+		}
+	}
+	if _, err := ParseConflictPolicy("merge"); err != ErrInvalidPolicy {
+		t.Errorf("expected ErrInvalidPolicy, got %v", err)
+	}
+}
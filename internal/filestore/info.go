@@ -6,4 +6,81 @@ type Info struct {
 	Name       string    `json:"name"`
 	Size       int64     `json:"size"`
 	UploadedAt time.Time `json:"uploadedAt"`
+
+	// OriginalName is the name the file was uploaded as, before any
+	// collision renaming.
+	OriginalName string   `json:"originalName,omitempty"`
+	ContentType  string   `json:"contentType,omitempty"`
+	SHA256       string   `json:"sha256,omitempty"`
+	Uploader     Uploader `json:"uploader,omitzero"`
+
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	NeverExpires     bool       `json:"neverExpires,omitempty"`
+	BurnAfterReading bool       `json:"burnAfterReading,omitempty"`
+	Pinned           bool       `json:"pinned"`
+}
+
+// Uploader identifies where a file came from.
+type Uploader struct {
+	IP     string `json:"ip,omitempty"`
+	Device string `json:"device,omitempty"`
+}
+
+// SaveOptions are chosen by the uploader and stored with the file.
+type SaveOptions struct {
+	// TTL overrides the cleanup max age for this file. Zero keeps the
+	// default and a negative TTL keeps the file until it is deleted.
+	TTL time.Duration
+	// BurnAfterReading deletes the file the first time it is opened.
+	BurnAfterReading bool
+	// OnConflict overrides the store's policy for names already in use.
+	OnConflict ConflictPolicy
+	Uploader   Uploader
+}
+
+// redacted hides what a burn-after-reading file's checksum and sniffed type
+// would give away about it from everything but Open. A short secret could
+// otherwise be recovered from its hash.
+func (i Info) redacted() Info {
+	if i.BurnAfterReading {
+		i.SHA256 = ""
+		i.ContentType = ""
+	}
+	return i
+}
+
+// Expired reports whether a cleanup at now should remove the file. Files
+// uploaded without a TTL fall back to maxAge; a zero maxAge keeps them.
+func (i Info) Expired(now time.Time, maxAge time.Duration) bool {
+	switch {
+	case i.Pinned, i.NeverExpires:
+		return false
+	case i.ExpiresAt != nil:
+		return now.After(*i.ExpiresAt)
+	default:
+		return maxAge > 0 && now.Sub(i.UploadedAt) > maxAge
+	}
+}
+
+// newInfo describes a file from its metadata. Files stored before metadata
+// recorded the upload time fall back to modTime.
+func newInfo(name string, size int64, modTime time.Time, m metadata) Info {
+	uploadedAt := m.UploadedAt
+	if uploadedAt.IsZero() {
+		uploadedAt = modTime
+	}
+
+	return Info{
+		Name:             name,
+		Size:             size,
+		UploadedAt:       uploadedAt,
+		OriginalName:     m.OriginalName,
+		ContentType:      m.ContentType,
+		SHA256:           m.SHA256,
+		Uploader:         m.Uploader,
+		ExpiresAt:        m.ExpiresAt,
+		NeverExpires:     m.NeverExpires,
+		BurnAfterReading: m.BurnAfterReading,
+		Pinned:           m.Pinned,
+	}
 }
@@ -0,0 +1,43 @@
+package filestore
+
+import "sync"
+
+// nameLocks hands out one mutex per file name so that operations on
+// different files never wait for each other. Entries are dropped once no
+// caller holds or waits for them.
+type nameLocks struct {
+	mu    sync.Mutex
+	locks map[string]*nameLock
+}
+
+type nameLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func (l *nameLocks) lock(name string) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*nameLock)
+	}
+	nl, ok := l.locks[name]
+	if !ok {
+		nl = &nameLock{}
+		l.locks[name] = nl
+	}
+	nl.refs++
+	l.mu.Unlock()
+
+	nl.mu.Lock()
+
+	return func() {
+		nl.mu.Unlock()
+
+		l.mu.Lock()
+		nl.refs--
+		if nl.refs == 0 {
+			delete(l.locks, name)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,103 @@
+package filestore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/d6o/homeclip/internal/atomicfile"
+)
+
+// metadata is kept in a sidecar file per upload, outside of the files
+// directory so that it never shows up as a file itself. It is the record of
+// when a file was uploaded: the file's mtime can be changed by anyone.
+type metadata struct {
+	OriginalName string    `json:"originalName,omitempty"`
+	ContentType  string    `json:"contentType,omitempty"`
+	Size         int64     `json:"size,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	UploadedAt   time.Time `json:"uploadedAt,omitzero"`
+	Uploader     Uploader  `json:"uploader,omitzero"`
+
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	NeverExpires     bool       `json:"neverExpires,omitempty"`
+	BurnAfterReading bool       `json:"burnAfterReading,omitempty"`
+	Pinned           bool       `json:"pinned,omitempty"`
+}
+
+func (s *Store) metaPath(name string) string {
+	return filepath.Join(s.metaDir, name+".json")
+}
+
+// readMeta returns the metadata of a file, or the zero value for files
+// uploaded before metadata was recorded.
+func (s *Store) readMeta(name string) (metadata, error) {
+	data, err := os.ReadFile(s.metaPath(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return metadata{}, nil
+		}
+		return metadata{}, err
+	}
+
+	var m metadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return metadata{}, err
+	}
+
+	return m, nil
+}
+
+func (s *Store) writeMeta(name string, m metadata) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(s.metaPath(name), data, 0o644)
+}
+
+func (s *Store) removeMeta(name string) error {
+	if err := os.Remove(s.metaPath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// sniffLen is how much of a file http.DetectContentType looks at.
+const sniffLen = 512
+
+// digest collects the checksum and content type of a file while it is
+// written.
+type digest struct {
+	hash hash.Hash
+	head []byte
+	size int64
+}
+
+func newDigest() *digest {
+	return &digest{hash: sha256.New()}
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	if n := sniffLen - len(d.head); n > 0 {
+		d.head = append(d.head, p[:min(n, len(p))]...)
+	}
+	d.size += int64(len(p))
+	return d.hash.Write(p)
+}
+
+func (d *digest) metadata(originalName string) metadata {
+	return metadata{
+		OriginalName: originalName,
+		ContentType:  http.DetectContentType(d.head),
+		Size:         d.size,
+		SHA256:       hex.EncodeToString(d.hash.Sum(nil)),
+	}
+}
@@ -0,0 +1,277 @@
+package filestore
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+)
+
+// QuotaPolicy decides what happens to an upload that would take the store
+// past its quota.
+type QuotaPolicy string
+
+const (
+	QuotaReject QuotaPolicy = "reject"
+	QuotaEvict  QuotaPolicy = "evict"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// Quota limits what the store holds in total. A zero limit is no limit.
+type Quota struct {
+	MaxBytes int64
+	MaxFiles int
+	// OnFull rejects uploads that do not fit, or evicts the oldest unpinned
+	// files to make room for them.
+	OnFull QuotaPolicy
+}
+
+func (q Quota) unlimited() bool {
+	return q.MaxBytes <= 0 && q.MaxFiles <= 0
+}
+
+// Usage is what the store holds against its quota. Content shared by
+// several names is counted once, since it is only stored once.
+type Usage struct {
+	Files    int   `json:"files"`
+	Bytes    int64 `json:"bytes"`
+	MaxFiles int   `json:"maxFiles,omitempty"`
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+}
+
+// Usage reports the files and bytes stored. Unfinished uploads are not
+// included.
+func (s *Store) Usage(_ context.Context) (Usage, error) {
+	files, err := s.list()
+	if err != nil {
+		return Usage{}, err
+	}
+
+	t := newTally(files)
+
+	return Usage{
+		Files:    t.files,
+		Bytes:    t.bytes,
+		MaxFiles: s.quota.MaxFiles,
+		MaxBytes: s.quota.MaxBytes,
+	}, nil
+}
+
+// reservation is room counted against the quota for files that are not
+// stored yet.
+type reservation struct {
+	files int
+	bytes int64
+}
+
+// hold keeps room for a file while its content arrives, so that uploads in
+// flight count against the quota before they are stored. It starts at the
+// declared size and grows as Write sees more than that.
+type hold struct {
+	s       *Store
+	held    reservation
+	written int64
+}
+
+// reserve is a quick check for uploads whose content is not known yet, so
+// that one that cannot be stored is refused before it is sent. It assumes
+// the content is new and that nothing will be evicted for it. size may be
+// -1, in which case only the file is reserved up front. The hold must be
+// released once the file is stored or given up.
+func (s *Store) reserve(name string, size int64, replace bool) (*hold, error) {
+	if s.quota.unlimited() {
+		return &hold{}, nil
+	}
+	if s.quota.MaxBytes > 0 && size > s.quota.MaxBytes {
+		return nil, ErrQuotaExceeded
+	}
+
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+
+	if size >= 0 && s.quota.OnFull != QuotaEvict {
+		if _, _, err := s.plan(name, size, "", replace, reservation{}); err != nil {
+			return nil, err
+		}
+	}
+
+	h := &hold{s: s, held: reservation{files: 1, bytes: max(size, 0)}}
+	s.reserved.files += h.held.files
+	s.reserved.bytes += h.held.bytes
+
+	return h, nil
+}
+
+func (h *hold) Write(p []byte) (int, error) {
+	h.written += int64(len(p))
+	if h.s == nil || h.written <= h.held.bytes {
+		return len(p), nil
+	}
+
+	h.s.quotaMu.Lock()
+	defer h.s.quotaMu.Unlock()
+
+	h.s.reserved.bytes += h.written - h.held.bytes
+	h.held.bytes = h.written
+
+	return len(p), nil
+}
+
+// release gives the room back. It may be called more than once.
+func (h *hold) release() {
+	if h.s == nil {
+		return
+	}
+
+	h.s.quotaMu.Lock()
+	defer h.s.quotaMu.Unlock()
+
+	h.s.reserved.files -= h.held.files
+	h.s.reserved.bytes -= h.held.bytes
+	h.held = reservation{}
+}
+
+// admit makes room for size bytes with the given hash about to be stored
+// under name, evicting old files if the policy allows. replace says the
+// upload overwrites an existing file of that name, and held is what is
+// already counted for the file while it arrived. The room stays reserved
+// until release is called, so concurrent uploads cannot all take the last
+// of it.
+func (s *Store) admit(name string, size int64, sha256 string, replace bool, held reservation) (release func(), err error) {
+	if s.quota.unlimited() {
+		return func() {}, nil
+	}
+
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+
+	added, evict, err := s.plan(name, size, sha256, replace, held)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range evict {
+		if err := s.Delete(context.Background(), f.Name); err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		slog.Info("evicted file to stay within quota", "name", f.Name, "size", f.Size, "uploadedAt", f.UploadedAt)
+	}
+
+	s.reserved.files++
+	s.reserved.bytes += added
+
+	return func() {
+		s.quotaMu.Lock()
+		defer s.quotaMu.Unlock()
+
+		s.reserved.files--
+		s.reserved.bytes -= added
+	}, nil
+}
+
+// plan works out how many bytes a new file adds and which files have to go
+// to make room for it. Files still arriving count as well, except for held,
+// which is this one. The caller holds quotaMu.
+func (s *Store) plan(name string, size int64, sha256 string, replace bool, held reservation) (int64, []Info, error) {
+	files, err := s.list()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	pending, err := s.pendingUploads()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	t := newTally(files)
+	t.files += s.reserved.files + pending.files - held.files
+	t.bytes += s.reserved.bytes + pending.bytes - held.bytes
+
+	// An overwrite frees the file it replaces, and that file must not be
+	// evicted separately.
+	candidates := make([]Info, 0, len(files))
+	for _, f := range files {
+		switch {
+		case replace && f.Name == name:
+			t.remove(f)
+		case !f.Pinned:
+			candidates = append(candidates, f)
+		}
+	}
+
+	added := func() int64 {
+		if sha256 != "" && t.refs[sha256] > 0 {
+			return 0
+		}
+		return size
+	}
+	fits := func() bool {
+		return (s.quota.MaxFiles <= 0 || t.files+1 <= s.quota.MaxFiles) &&
+			(s.quota.MaxBytes <= 0 || t.bytes+added() <= s.quota.MaxBytes)
+	}
+
+	if fits() {
+		return added(), nil, nil
+	}
+	if s.quota.OnFull != QuotaEvict {
+		return 0, nil, ErrQuotaExceeded
+	}
+
+	slices.SortFunc(candidates, func(a, b Info) int {
+		return a.UploadedAt.Compare(b.UploadedAt)
+	})
+
+	var evict []Info
+	for _, f := range candidates {
+		t.remove(f)
+		evict = append(evict, f)
+		if fits() {
+			return added(), evict, nil
+		}
+	}
+
+	return 0, nil, ErrQuotaExceeded
+}
+
+// tally adds up files, counting content shared by several names once.
+type tally struct {
+	files int
+	bytes int64
+	refs  map[string]int
+}
+
+func newTally(files []Info) *tally {
+	t := &tally{refs: make(map[string]int)}
+	for _, f := range files {
+		t.add(f)
+	}
+	return t
+}
+
+func (t *tally) add(f Info) {
+	t.files++
+
+	// Files stored before checksums were recorded have no hash and their
+	// own copy.
+	if f.SHA256 == "" {
+		t.bytes += f.Size
+		return
+	}
+	if t.refs[f.SHA256] == 0 {
+		t.bytes += f.Size
+	}
+	t.refs[f.SHA256]++
+}
+
+func (t *tally) remove(f Info) {
+	t.files--
+
+	if f.SHA256 == "" {
+		t.bytes -= f.Size
+		return
+	}
+	if t.refs[f.SHA256]--; t.refs[f.SHA256] == 0 {
+		t.bytes -= f.Size
+	}
+}
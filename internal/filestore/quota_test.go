@@ -0,0 +1,240 @@
+package filestore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d6o/homeclip/internal/events"
+)
+
+func newQuotaStore(t *testing.T, q Quota) *Store {
+	t.Helper()
+	s, err := NewStore(t.TempDir(), &mockPublisher{}, Options{Quota: q})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	return s
+}
+
+func saveString(t *testing.T, s *Store, name, content string) {
+	t.Helper()
+	if _, err := s.Save(context.Background(), name, strings.NewReader(content), -1, SaveOptions{}); err != nil {
+		t.Fatalf("Save %s failed: %v", name, err)
+	}
+}
+
+func TestStore_QuotaRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		quota Quota
+	}{
+		{"files", Quota{MaxFiles: 2}},
+		{"bytes", Quota{MaxBytes: 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newQuotaStore(t, tt.quota)
+			ctx := context.Background()
+
+			saveString(t, s, "a.txt", "aaaa")
+			saveString(t, s, "b.txt", "bbbb")
+
+			_, err := s.Save(ctx, "c.txt", strings.NewReader("cccc"), -1, SaveOptions{})
+			if !errors.Is(err, ErrQuotaExceeded) {
+				t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+			}
+
+			files, _ := s.List(ctx)
+			if len(files) != 2 {
+				t.Errorf("expected 2 files, got %d", len(files))
+			}
+		})
+	}
+}
+
+func TestStore_QuotaRejectsKnownSizeEarly(t *testing.T) {
+	s := newQuotaStore(t, Quota{MaxBytes: 10})
+
+	r := &countingReader{r: strings.NewReader(strings.Repeat("x", 11))}
+	_, err := s.Save(context.Background(), "big.bin", r, 11, SaveOptions{})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if r.n != 0 {
+		t.Errorf("expected nothing to be read, got %d bytes", r.n)
+	}
+}
+
+type countingReader struct {
+	r *strings.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestStore_QuotaCountsDuplicatesOnce(t *testing.T) {
+	s := newQuotaStore(t, Quota{MaxBytes: 10})
+
+	saveString(t, s, "a.txt", "12345678")
+	saveString(t, s, "copy.txt", "12345678")
+
+	u, err := s.Usage(context.Background())
+	if err != nil {
+		t.Fatalf("Usage failed: %v", err)
+	}
+	if u.Files != 2 || u.Bytes != 8 || u.MaxBytes != 10 {
+		t.Errorf("unexpected usage %+v", u)
+	}
+}
+
+func TestStore_QuotaOverwriteFreesOldFile(t *testing.T) {
+	s := newQuotaStore(t, Quota{MaxFiles: 1, MaxBytes: 10})
+
+	saveString(t, s, "a.txt", "12345678")
+
+	_, err := s.Save(context.Background(), "a.txt", strings.NewReader("87654321"), 8, SaveOptions{OnConflict: ConflictOverwrite})
+	if err != nil {
+		t.Fatalf("expected overwrite to fit, got %v", err)
+	}
+}
+
+func TestStore_QuotaEvictsOldestUnpinned(t *testing.T) {
+	s := newQuotaStore(t, Quota{MaxFiles: 3, OnFull: QuotaEvict})
+	ctx := context.Background()
+
+	now := time.Now()
+	for i, name := range []string{"pinned.txt", "old.txt", "newer.txt"} {
+		saveString(t, s, name, name)
+		backdate(t, s, name, now.Add(time.Duration(i-3)*time.Hour))
+	}
+	if _, err := s.Pin(ctx, "pinned.txt", true); err != nil {
+		t.Fatalf("Pin failed: %v", err)
+	}
+
+	saveString(t, s, "new.txt", "new")
+
+	var names []string
+	files, _ := s.List(ctx)
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "new.txt,newer.txt,pinned.txt" {
+		t.Errorf("expected old.txt to be evicted, got %v", names)
+	}
+
+	pub := s.events.(*mockPublisher)
+	if !slices.ContainsFunc(pub.events, func(e events.Event) bool { return e.Type == events.FileDeleted }) {
+		t.Error("expected the eviction to be announced")
+	}
+}
+
+func TestStore_QuotaEvictCannotMakeRoom(t *testing.T) {
+	s := newQuotaStore(t, Quota{MaxFiles: 1, OnFull: QuotaEvict})
+	ctx := context.Background()
+
+	saveString(t, s, "keep.txt", "keep")
+	if _, err := s.Pin(ctx, "keep.txt", true); err != nil {
+		t.Fatalf("Pin failed: %v", err)
+	}
+
+	_, err := s.Save(ctx, "new.txt", strings.NewReader("new"), -1, SaveOptions{})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+
+	if _, err := s.Stat(ctx, "keep.txt"); err != nil {
+		t.Errorf("expected pinned file to be kept, got %v", err)
+	}
+}
+
+func TestStore_QuotaUploads(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir, &mockPublisher{}, Options{Quota: Quota{MaxBytes: 10}})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := s.CreateUpload(ctx, "big.bin", 11, SaveOptions{}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+
+	u, err := s.CreateUpload(ctx, "late.bin", 6, SaveOptions{})
+	if err != nil {
+		t.Fatalf("CreateUpload failed: %v", err)
+	}
+
+	// The upload's declared length is counted while it is in progress.
+	_, err = s.Save(ctx, "a.txt", strings.NewReader("12345678"), -1, SaveOptions{})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded for a file that does not fit beside the upload, got %v", err)
+	}
+
+	if _, err := s.WriteUpload(ctx, u.ID, 0, strings.NewReader("abc")); err != nil {
+		t.Fatalf("WriteUpload failed: %v", err)
+	}
+
+	// A quota lowered by a restart is only noticed when the upload
+	// completes.
+	s, err = NewStore(dir, &mockPublisher{}, Options{Quota: Quota{MaxBytes: 5}})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	_, err = s.WriteUpload(ctx, u.ID, 3, strings.NewReader("def"))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+
+	if _, err := os.Stat(s.partPath(u.ID)); !os.IsNotExist(err) {
+		t.Error("expected the rejected upload to be discarded")
+	}
+}
+
+func TestStore_QuotaCountsFilesInFlight(t *testing.T) {
+	s := newQuotaStore(t, Quota{MaxBytes: 10})
+	ctx := context.Background()
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Save(ctx, "stream.bin", pr, -1, SaveOptions{})
+		done <- err
+	}()
+
+	// Once six bytes of the stream have arrived, five more do not fit. The
+	// empty write returns only after the first chunk has been written out.
+	pw.Write([]byte("123456"))
+	pw.Write(nil)
+
+	if _, err := s.Save(ctx, "known.bin", strings.NewReader("12345"), 5, SaveOptions{}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded beside a streaming upload, got %v", err)
+	}
+	if _, err := s.CreateUpload(ctx, "later.bin", 5, SaveOptions{}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded for an upload, got %v", err)
+	}
+
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// The room held for the stream is now held by the file itself.
+	if _, err := s.Save(ctx, "small.bin", strings.NewReader("1234"), 4, SaveOptions{}); err != nil {
+		t.Errorf("expected the remaining room to be usable, got %v", err)
+	}
+	if s.reserved != (reservation{}) {
+		t.Errorf("expected nothing left reserved, got %+v", s.reserved)
+	}
+}
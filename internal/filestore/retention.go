@@ -0,0 +1,98 @@
+package filestore
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RetentionRule keeps the files it matches for MaxAge instead of the
+// cleanup default. A file matches when it meets every criterion the rule
+// sets; criteria left empty match any file.
+type RetentionRule struct {
+	Name string
+	// MinSize and MaxSize bound the file size in bytes, inclusive. Zero is
+	// no bound.
+	MinSize int64
+	MaxSize int64
+	// Extensions such as ".mp4" and content types such as "application/pdf"
+	// or "video/*". A file matches if it has any of them.
+	Extensions   []string
+	ContentTypes []string
+	// MaxAge is how long matching files are kept. Zero keeps them until
+	// they are deleted.
+	MaxAge time.Duration
+}
+
+// Rule names reported for files that no RetentionRule decided on.
+const (
+	ruleTTL     = "ttl"
+	ruleDefault = "default"
+)
+
+func (r RetentionRule) matches(i Info) bool {
+	if r.MinSize > 0 && i.Size < r.MinSize {
+		return false
+	}
+	if r.MaxSize > 0 && i.Size > r.MaxSize {
+		return false
+	}
+	if len(r.Extensions) > 0 && !matchExtension(r.Extensions, i.Name) {
+		return false
+	}
+	if len(r.ContentTypes) > 0 && !matchContentType(r.ContentTypes, i.ContentType) {
+		return false
+	}
+	return true
+}
+
+func matchExtension(exts []string, name string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(name), ".")
+	if ext == "" {
+		return false
+	}
+	for _, e := range exts {
+		if strings.EqualFold(strings.TrimPrefix(e, "."), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchContentType compares media types without their parameters. A
+// pattern ending in /* matches the whole type, as in video/*.
+func matchContentType(patterns []string, contentType string) bool {
+	ct, _, _ := strings.Cut(contentType, ";")
+	ct = strings.ToLower(strings.TrimSpace(ct))
+	if ct == "" {
+		return false
+	}
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(ct, prefix) {
+				return true
+			}
+		} else if ct == p {
+			return true
+		}
+	}
+	return false
+}
+
+// expiry reports whether a cleanup at now should remove the file and the
+// rule that decided it. The first matching retention rule replaces maxAge;
+// a TTL chosen at upload and pinning still take precedence.
+func (s *Store) expiry(i Info, now time.Time, maxAge time.Duration) (string, bool) {
+	if i.ExpiresAt != nil {
+		return ruleTTL, i.Expired(now, maxAge)
+	}
+
+	for _, r := range s.retention {
+		if r.matches(i) {
+			return r.Name, i.Expired(now, r.MaxAge)
+		}
+	}
+
+	return ruleDefault, i.Expired(now, maxAge)
+}
@@ -0,0 +1,112 @@
+package filestore
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetentionRule_Matches(t *testing.T) {
+	video := Info{Name: "Holiday.MP4", Size: 500 << 20, ContentType: "video/mp4"}
+	pdf := Info{Name: "invoice.pdf", Size: 200 << 10, ContentType: "application/pdf"}
+	note := Info{Name: "notes", Size: 10, ContentType: "text/plain; charset=utf-8"}
+
+	tests := []struct {
+		name string
+		rule RetentionRule
+		info Info
+		want bool
+	}{
+		{"empty rule", RetentionRule{}, note, true},
+		{"extension", RetentionRule{Extensions: []string{".mp4"}}, video, true},
+		{"extension without dot", RetentionRule{Extensions: []string{"pdf"}}, pdf, true},
+		{"other extension", RetentionRule{Extensions: []string{".mkv"}}, video, false},
+		{"no extension", RetentionRule{Extensions: []string{".txt"}}, note, false},
+		{"content type", RetentionRule{ContentTypes: []string{"application/pdf"}}, pdf, true},
+		{"content type wildcard", RetentionRule{ContentTypes: []string{"video/*"}}, video, true},
+		{"content type parameters", RetentionRule{ContentTypes: []string{"text/plain"}}, note, true},
+		{"other content type", RetentionRule{ContentTypes: []string{"image/*"}}, video, false},
+		{"unknown content type", RetentionRule{ContentTypes: []string{"video/*"}}, Info{Name: "a"}, false},
+		{"min size", RetentionRule{MinSize: 100 << 20}, video, true},
+		{"below min size", RetentionRule{MinSize: 100 << 20}, pdf, false},
+		{"max size", RetentionRule{MaxSize: 10 << 20}, pdf, true},
+		{"above max size", RetentionRule{MaxSize: 10 << 20}, video, false},
+		{"all criteria", RetentionRule{Extensions: []string{".pdf"}, ContentTypes: []string{"application/pdf"}, MaxSize: 1 << 20}, pdf, true},
+		{"one criterion fails", RetentionRule{Extensions: []string{".pdf"}, MinSize: 1 << 20}, pdf, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.matches(tt.info); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStore_CleanupRetentionRules(t *testing.T) {
+	s, err := NewStore(t.TempDir(), &mockPublisher{}, Options{
+		Retention: []RetentionRule{
+			{Name: "big", MinSize: 10, MaxAge: time.Hour},
+			{Name: "pdf", Extensions: []string{".pdf"}, MaxAge: 7 * 24 * time.Hour},
+			{Name: "keep-logs", Extensions: []string{".log"}, MaxAge: 0},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	ctx := context.Background()
+
+	files := map[string]string{
+		"big.pdf":   "a large document",
+		"small.pdf": "tiny",
+		"old.log":   "log",
+		"other.txt": "txt",
+	}
+	for name, content := range files {
+		saveString(t, s, name, content)
+		backdate(t, s, name, time.Now().Add(-2*24*time.Hour))
+	}
+
+	res, err := s.Cleanup(ctx, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	slices.Sort(res.Removed)
+
+	// big.pdf matches "big" first, small.pdf is within its week and
+	// other.txt falls back to the default max age.
+	if got := strings.Join(res.Removed, ","); got != "big.pdf,other.txt" {
+		t.Errorf("expected big.pdf and other.txt removed, got %v", res.Removed)
+	}
+}
+
+func TestStore_ExpiryRule(t *testing.T) {
+	s := &Store{retention: []RetentionRule{{Name: "pdf", Extensions: []string{".pdf"}, MaxAge: time.Hour}}}
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	past := now.Add(-time.Minute)
+
+	tests := []struct {
+		name        string
+		info        Info
+		wantRule    string
+		wantExpired bool
+	}{
+		{"rule", Info{Name: "a.pdf", UploadedAt: old}, "pdf", true},
+		{"default", Info{Name: "a.txt", UploadedAt: old}, ruleDefault, false},
+		{"ttl wins over rule", Info{Name: "a.pdf", UploadedAt: now, ExpiresAt: &past}, ruleTTL, true},
+		{"pinned", Info{Name: "a.pdf", UploadedAt: old, Pinned: true}, "pdf", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, expired := s.expiry(tt.info, now, 24*time.Hour)
+			if rule != tt.wantRule || expired != tt.wantExpired {
+				t.Errorf("expiry() = %q, %v, want %q, %v", rule, expired, tt.wantRule, tt.wantExpired)
+			}
+		})
+	}
+}
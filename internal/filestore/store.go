@@ -4,75 +4,257 @@ import (
 	"context"
 	"errors"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
+
+	"github.com/d6o/homeclip/internal/atomicfile"
+	"github.com/d6o/homeclip/internal/cleanup"
+	"github.com/d6o/homeclip/internal/events"
 )
 
-const maxFileSize = 100 * 1024 * 1024 // 100 MB
+const (
+	defaultMaxFileSize = 100 * 1024 * 1024 // 100 MB
+
+	// defaultUploadMaxAge is how long an upload may sit idle when files
+	// themselves never expire.
+	defaultUploadMaxAge = 24 * time.Hour
+)
 
 var (
-	ErrTooLarge = errors.New("file exceeds 100 MB limit")
+	ErrTooLarge = errors.New("file exceeds the size limit")
 	ErrNotFound = errors.New("file not found")
 )
 
+type publisher interface {
+	Publish(t events.Type, data any)
+}
+
 type Store struct {
-	dir string
-	mu  sync.RWMutex
+	dir       string
+	metaDir   string
+	tmpDir    string
+	uploadDir string
+	blobDir   string
+	thumbDir  string
+	events    publisher
+	conflict  ConflictPolicy
+	maxSize   int64
+	quota     Quota
+	retention []RetentionRule
+
+	// mu is held for reading by anything that touches a single file and
+	// for writing by Cleanup, which walks the whole directory. Operations on
+	// one file are serialized by its entry in names instead.
+	mu    sync.RWMutex
+	names nameLocks
+
+	// uploads serializes changes to the same partial upload. It is never
+	// held together with mu for writing, nor while a chunk is read from the
+	// client; writing marks the uploads receiving one instead.
+	uploads   nameLocks
+	writingMu sync.Mutex
+	writing   map[string]bool
+
+	// blobMu guards refs and the creation and removal of blobs.
+	blobMu sync.Mutex
+	refs   map[string]int
+
+	// quotaMu serializes admitting new files against the quota and guards
+	// the room reserved for files that are still being stored. It is taken
+	// before any name lock.
+	quotaMu  sync.Mutex
+	reserved reservation
+
+	// renderSem bounds how many thumbnails are rendered at once.
+	renderSem chan struct{}
+}
+
+// Options configure a Store.
+type Options struct {
+	// OnConflict applies to uploads that do not choose a policy. It
+	// defaults to ConflictRename.
+	OnConflict ConflictPolicy
+	// MaxFileSize is the largest file accepted. It defaults to 100 MB.
+	MaxFileSize int64
+	Quota       Quota
+	// Retention gives files matching a rule their own max age. The first
+	// matching rule applies.
+	Retention []RetentionRule
 }
 
-func NewStore(dataDir string) (*Store, error) {
-	dir := filepath.Join(dataDir, "files")
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+func NewStore(dataDir string, events publisher, opts Options) (*Store, error) {
+	s := &Store{
+		dir:       filepath.Join(dataDir, "files"),
+		metaDir:   filepath.Join(dataDir, "filemeta"),
+		tmpDir:    filepath.Join(dataDir, "tmp"),
+		uploadDir: filepath.Join(dataDir, "uploads"),
+		blobDir:   filepath.Join(dataDir, "fileblobs"),
+		thumbDir:  filepath.Join(dataDir, "thumbs"),
+		events:    events,
+		conflict:  opts.OnConflict,
+		maxSize:   opts.MaxFileSize,
+		quota:     opts.Quota,
+		retention: opts.Retention,
+		renderSem: make(chan struct{}, 1),
+		refs:      make(map[string]int),
+		writing:   make(map[string]bool),
+	}
+	if s.conflict == "" {
+		s.conflict = ConflictRename
+	}
+	if s.maxSize <= 0 {
+		s.maxSize = defaultMaxFileSize
+	}
+	if s.quota.OnFull == "" {
+		s.quota.OnFull = QuotaReject
+	}
+
+	for _, d := range []string{s.dir, s.metaDir, s.tmpDir, s.uploadDir, s.blobDir, s.thumbDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	// Uploads are written to tmpDir and only renamed into the files
+	// directory once complete, so anything left there is from a crash.
+	for _, d := range []string{s.metaDir, s.tmpDir, s.uploadDir} {
+		if err := atomicfile.Sweep(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.loadRefs(); err != nil {
 		return nil, err
 	}
 
-	return &Store{dir: dir}, nil
+	if err := s.sweepThumbs(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
 }
 
-func (s *Store) Save(_ context.Context, name string, r io.Reader, size int64) (Info, error) {
-	if size > maxFileSize {
+// Save stores r under name. size is checked against the limit up front when
+// the caller knows it and may be -1 otherwise; the stream is limited anyway.
+func (s *Store) Save(_ context.Context, name string, r io.Reader, size int64, opts SaveOptions) (Info, error) {
+	if size > s.maxSize {
 		return Info{}, ErrTooLarge
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	clean := filepath.Base(name)
+	policy := s.policy(opts.OnConflict)
 
-	dest := filepath.Join(s.dir, filepath.Base(name))
+	if policy == ConflictReject && s.taken(clean) {
+		return Info{}, ErrExists
+	}
 
-	f, err := os.Create(dest)
+	hold, err := s.reserve(clean, size, policy == ConflictOverwrite)
 	if err != nil {
 		return Info{}, err
 	}
-	defer f.Close()
+	defer hold.release()
 
-	limited := io.LimitReader(r, maxFileSize+1)
+	// The upload streams into a private temp file without any lock held;
+	// only publishing it under its final name is serialized.
+	f, err := atomicfile.Create(s.tmpDir, filepath.Join(s.dir, clean), 0o644)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Abort()
+
+	limited := io.LimitReader(r, s.maxSize+1)
 
-	written, err := io.Copy(f, limited)
+	d := newDigest()
+	written, err := io.Copy(io.MultiWriter(f, d, hold), limited)
 	if err != nil {
-		os.Remove(dest)
 		return Info{}, err
 	}
 
-	if written > maxFileSize {
-		os.Remove(dest)
+	if written > s.maxSize {
 		return Info{}, ErrTooLarge
 	}
 
-	stat, err := f.Stat()
+	m := d.metadata(clean)
+
+	release, err := s.admit(clean, m.Size, m.SHA256, policy == ConflictOverwrite, hold.held)
 	if err != nil {
 		return Info{}, err
 	}
+	defer release()
+	hold.release()
+
+	final, unlock, err := s.claim(clean, policy)
+	if err != nil {
+		return Info{}, err
+	}
+	defer unlock()
+
+	if err := s.link(final, m.SHA256, f.CommitTo, f.Abort); err != nil {
+		return Info{}, err
+	}
 
-	return Info{
-		Name:       filepath.Base(name),
-		Size:       written,
-		UploadedAt: stat.ModTime(),
-	}, nil
+	return s.finish(final, m, opts)
 }
 
+// finish records the metadata of a file that has just been moved into place
+// and announces it. m describes the content; finish adds the upload time and
+// options. The caller holds the file's name lock.
+func (s *Store) finish(name string, m metadata, opts SaveOptions) (Info, error) {
+	// Replacing a pinned file keeps it pinned.
+	old, _ := s.readMeta(name)
+	m.Pinned = old.Pinned
+	m.BurnAfterReading = opts.BurnAfterReading
+	m.Uploader = opts.Uploader
+	m.UploadedAt = time.Now()
+
+	switch {
+	case opts.TTL < 0:
+		m.NeverExpires = true
+	case opts.TTL > 0:
+		expiresAt := m.UploadedAt.Add(opts.TTL)
+		m.ExpiresAt = &expiresAt
+	}
+
+	if err := s.writeMeta(name, m); err != nil {
+		os.Remove(filepath.Join(s.dir, name))
+		s.removeMeta(name)
+		s.dropRef(m.SHA256)
+		s.dropRef(old.SHA256)
+		return Info{}, err
+	}
+
+	// The replaced file, if any, no longer uses its blob.
+	if err := s.dropRef(old.SHA256); err != nil {
+		slog.Error("failed to remove file blob", "sha256", old.SHA256, "error", err)
+	}
+
+	info := newInfo(name, m.Size, m.UploadedAt, m).redacted()
+
+	s.events.Publish(events.FileCreated, info)
+
+	return info, nil
+}
+
+// List describes every file, with the details of burn-after-reading files
+// redacted.
 func (s *Store) List(_ context.Context) ([]Info, error) {
+	files, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range files {
+		files[i] = files[i].redacted()
+	}
+
+	return files, nil
+}
+
+func (s *Store) list() ([]Info, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -92,35 +274,203 @@ func (s *Store) List(_ context.Context) ([]Info, error) {
 			continue
 		}
 
-		files = append(files, Info{
-			Name:       e.Name(),
-			Size:       info.Size(),
-			UploadedAt: info.ModTime(),
-		})
+		// A damaged sidecar should not hide the file itself.
+		m, _ := s.readMeta(e.Name())
+
+		files = append(files, newInfo(e.Name(), info.Size(), info.ModTime(), m))
 	}
 
 	return files, nil
 }
 
-func (s *Store) FilePath(name string) (string, error) {
+// Stat returns the details of one file without opening it.
+func (s *Store) Stat(_ context.Context, name string) (Info, error) {
 	clean := filepath.Base(name)
-	full := filepath.Join(s.dir, clean)
 
-	if _, err := os.Stat(full); err != nil {
+	unlock := s.lockName(clean)
+	defer unlock()
+
+	stat, err := os.Stat(filepath.Join(s.dir, clean))
+	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return "", ErrNotFound
+			return Info{}, ErrNotFound
 		}
-		return "", err
+		return Info{}, err
 	}
 
-	return full, nil
+	m, _ := s.readMeta(clean)
+
+	return newInfo(clean, stat.Size(), stat.ModTime(), m).redacted(), nil
 }
 
-func (s *Store) Delete(_ context.Context, name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Open returns a file for reading. The handle stays readable until it is
+// closed even if the file is deleted or replaced meanwhile. A
+// burn-after-reading file is removed as soon as it is open, so only the
+// first caller gets it.
+func (s *Store) Open(_ context.Context, name string) (*os.File, Info, error) {
+	clean := filepath.Base(name)
+
+	unlock := s.lockName(clean)
+	defer unlock()
+
+	f, info, err := s.open(clean)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	if info.BurnAfterReading {
+		if err := os.Remove(filepath.Join(s.dir, clean)); err != nil {
+			f.Close()
+			return nil, Info{}, err
+		}
+		if err := s.removeMeta(clean); err != nil {
+			slog.Error("failed to remove file metadata", "name", clean, "error", err)
+		}
+		if err := s.dropRef(info.SHA256); err != nil {
+			slog.Error("failed to remove file blob", "sha256", info.SHA256, "error", err)
+		}
+
+		s.events.Publish(events.FileDeleted, Info{Name: clean})
+	}
+
+	return f, info, nil
+}
+
+// Each calls fn for every file, or for the named ones, in name order. Every
+// file is opened under its lock and read through the open handle afterwards,
+// so a concurrent Delete or overwrite cannot change what fn reads. Names
+// that do not exist and burn-after-reading files are skipped.
+func (s *Store) Each(ctx context.Context, names []string, fn func(Info, io.Reader) error) error {
+	if names == nil {
+		entries, err := os.ReadDir(s.dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				names = append(names, e.Name())
+			}
+		}
+	} else {
+		names = cleanNames(names)
+	}
+
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := s.each(name, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) each(name string, fn func(Info, io.Reader) error) error {
+	unlock := s.lockName(name)
+	f, info, err := s.open(name)
+	unlock()
+
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	if info.BurnAfterReading {
+		return nil
+	}
+
+	return fn(info, f)
+}
+
+// open opens a file without consuming it. The caller holds the name lock.
+func (s *Store) open(name string) (*os.File, Info, error) {
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, Info{}, ErrNotFound
+		}
+		return nil, Info{}, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Info{}, err
+	}
+
+	if stat.IsDir() {
+		f.Close()
+		return nil, Info{}, ErrNotFound
+	}
+
+	m, err := s.readMeta(name)
+	if err != nil {
+		f.Close()
+		return nil, Info{}, err
+	}
+
+	return f, newInfo(name, stat.Size(), stat.ModTime(), m), nil
+}
+
+// cleanNames reduces client supplied names to unique base names in order.
+func cleanNames(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	clean := make([]string, 0, len(names))
+	for _, name := range names {
+		name = filepath.Base(name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		clean = append(clean, name)
+	}
+	slices.Sort(clean)
+	return clean
+}
+
+// Pin marks a file so that cleanup never removes it, or clears the mark.
+func (s *Store) Pin(_ context.Context, name string, pinned bool) (Info, error) {
+	clean := filepath.Base(name)
 
+	unlock := s.lockName(clean)
+	defer unlock()
+
+	stat, err := os.Stat(filepath.Join(s.dir, clean))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Info{}, ErrNotFound
+		}
+		return Info{}, err
+	}
+
+	m, err := s.readMeta(clean)
+	if err != nil {
+		return Info{}, err
+	}
+
+	m.Pinned = pinned
+	if err := s.writeMeta(clean, m); err != nil {
+		return Info{}, err
+	}
+
+	info := newInfo(clean, stat.Size(), stat.ModTime(), m).redacted()
+
+	s.events.Publish(events.FileUpdated, info)
+
+	return info, nil
+}
+
+func (s *Store) Delete(_ context.Context, name string) error {
 	clean := filepath.Base(name)
+
+	unlock := s.lockName(clean)
+	defer unlock()
 	full := filepath.Join(s.dir, clean)
 
 	if _, err := os.Stat(full); err != nil {
@@ -130,33 +480,145 @@ func (s *Store) Delete(_ context.Context, name string) error {
 		return err
 	}
 
-	return os.Remove(full)
+	m, _ := s.readMeta(clean)
+
+	if err := os.Remove(full); err != nil {
+		return err
+	}
+
+	if err := s.removeMeta(clean); err != nil {
+		return err
+	}
+
+	if err := s.dropRef(m.SHA256); err != nil {
+		return err
+	}
+
+	s.events.Publish(events.FileDeleted, Info{Name: clean})
+
+	return nil
 }
 
-func (s *Store) Cleanup(_ context.Context, maxAge time.Duration) error {
+// Cleanup removes expired files and abandoned uploads. Files that cannot be
+// checked or removed are reported in the result and left for the next
+// cleanup.
+func (s *Store) Cleanup(_ context.Context, maxAge time.Duration) (cleanup.Result, error) {
+	uploadAge := maxAge
+	if uploadAge <= 0 {
+		uploadAge = defaultUploadMaxAge
+	}
+
+	// Partial uploads never show up as files, so they are not reported.
+	uploadErr := s.cleanupUploads(uploadAge)
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	expired, failed, err := s.expiredFiles(time.Now(), maxAge)
+	if err != nil {
+		return cleanup.Result{}, errors.Join(err, uploadErr)
+	}
+
+	res := cleanup.Result{Failed: failed}
+	errs := []error{uploadErr}
+	for _, f := range expired {
+		if err := os.Remove(filepath.Join(s.dir, f.name)); err != nil {
+			res.Failed = append(res.Failed, cleanup.ItemError{Name: f.name, Err: err})
+			continue
+		}
+
+		slog.Info("file expired", "name", f.name, "rule", f.rule)
+		res.Removed = append(res.Removed, f.name)
+
+		// The file is gone either way. A blob left behind is removed the
+		// next time the store starts.
+		if err := s.removeMeta(f.name); err != nil {
+			errs = append(errs, err)
+		}
+		if err := s.dropRef(f.sha256); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return res, errors.Join(errs...)
+}
+
+// Expired lists the files Cleanup would remove, without removing them.
+func (s *Store) Expired(_ context.Context, maxAge time.Duration) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	expired, failed, err := s.expiredFiles(time.Now(), maxAge)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(expired))
+	for _, f := range expired {
+		names = append(names, f.name)
+	}
+
+	errs := make([]error, 0, len(failed))
+	for _, f := range failed {
+		errs = append(errs, f)
+	}
+
+	return names, errors.Join(errs...)
+}
+
+type expiredFile struct {
+	name   string
+	rule   string
+	sha256 string
+}
+
+// expiredFiles finds the files a cleanup at now removes, and the files it
+// cannot tell about because they cannot be read. The caller holds mu.
+func (s *Store) expiredFiles(now time.Time, maxAge time.Duration) ([]expiredFile, []cleanup.ItemError, error) {
 	entries, err := os.ReadDir(s.dir)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	now := time.Now()
+	var expired []expiredFile
+	var failed []cleanup.ItemError
 	for _, e := range entries {
 		if e.IsDir() {
 			continue
 		}
 
 		info, err := e.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			failed = append(failed, cleanup.ItemError{Name: e.Name(), Err: err})
+			continue
+		}
+
+		m, err := s.readMeta(e.Name())
 		if err != nil {
+			failed = append(failed, cleanup.ItemError{Name: e.Name(), Err: err})
 			continue
 		}
 
-		if now.Sub(info.ModTime()) > maxAge {
-			os.Remove(filepath.Join(s.dir, e.Name()))
+		rule, ok := s.expiry(newInfo(e.Name(), info.Size(), info.ModTime(), m), now, maxAge)
+		if ok {
+			expired = append(expired, expiredFile{name: e.Name(), rule: rule, sha256: m.SHA256})
 		}
 	}
 
-	return nil
+	return expired, failed, nil
+}
+
+// lockName serializes operations on one file while letting operations on
+// other files, and listings, proceed.
+func (s *Store) lockName(name string) (unlock func()) {
+	s.mu.RLock()
+	release := s.names.lock(name)
+
+	return func() {
+		release()
+		s.mu.RUnlock()
+	}
 }
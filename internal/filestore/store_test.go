@@ -2,26 +2,58 @@ package filestore
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/d6o/homeclip/internal/events"
 )
 
+type mockPublisher struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (m *mockPublisher) Publish(t events.Type, data any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, events.Event{Type: t, Data: data})
+}
+
 func newTestStore(t *testing.T) *Store {
 	t.Helper()
 	dir := t.TempDir()
-	s, err := NewStore(dir)
+	s, err := NewStore(dir, &mockPublisher{}, Options{})
 	if err != nil {
 		t.Fatalf("NewStore failed: %v", err)
 	}
 	return s
 }
 
+// backdate moves the recorded upload time of a file into the past.
+func backdate(t *testing.T, s *Store, name string, at time.Time) {
+	t.Helper()
+	m, err := s.readMeta(name)
+	if err != nil {
+		t.Fatalf("readMeta failed: %v", err)
+	}
+	m.UploadedAt = at
+	if err := s.writeMeta(name, m); err != nil {
+		t.Fatalf("writeMeta failed: %v", err)
+	}
+}
+
 func TestNewStore(t *testing.T) {
 	dir := t.TempDir()
-	s, err := NewStore(dir)
+	s, err := NewStore(dir, &mockPublisher{}, Options{})
 	if err != nil {
 		t.Fatalf("NewStore failed: %v", err)
 	}
@@ -44,7 +76,7 @@ func TestStore_SaveAndList(t *testing.T) {
 	s := newTestStore(t)
 	ctx := context.Background()
 
-	info, err := s.Save(ctx, "test.txt", strings.NewReader("hello"), 5)
+	info, err := s.Save(ctx, "test.txt", strings.NewReader("hello"), 5, SaveOptions{})
 	if err != nil {
 		t.Fatalf("Save failed: %v", err)
 	}
@@ -72,17 +104,39 @@ func TestStore_SaveTooLarge(t *testing.T) {
 	s := newTestStore(t)
 	ctx := context.Background()
 
-	_, err := s.Save(ctx, "big.bin", strings.NewReader("data"), maxFileSize+1)
+	_, err := s.Save(ctx, "big.bin", strings.NewReader("data"), defaultMaxFileSize+1, SaveOptions{})
 	if err != ErrTooLarge {
 		t.Errorf("expected ErrTooLarge, got %v", err)
 	}
 }
 
+func TestStore_SaveMaxFileSize(t *testing.T) {
+	s, err := NewStore(t.TempDir(), &mockPublisher{}, Options{MaxFileSize: 8})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := s.Save(ctx, "fits.txt", strings.NewReader("12345678"), -1, SaveOptions{}); err != nil {
+		t.Fatalf("expected a file at the limit to be stored, got %v", err)
+	}
+
+	// The size is unknown up front, so the stream itself is cut off.
+	_, err = s.Save(ctx, "big.txt", strings.NewReader("123456789"), -1, SaveOptions{})
+	if err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+
+	if _, err := s.CreateUpload(ctx, "big.bin", 9, SaveOptions{}); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge for an upload, got %v", err)
+	}
+}
+
 func TestStore_SavePathTraversal(t *testing.T) {
 	s := newTestStore(t)
 	ctx := context.Background()
 
-	info, err := s.Save(ctx, "../../../etc/passwd", strings.NewReader("data"), 4)
+	info, err := s.Save(ctx, "../../../etc/passwd", strings.NewReader("data"), 4, SaveOptions{})
 	if err != nil {
 		t.Fatalf("Save failed: %v", err)
 	}
@@ -92,39 +146,67 @@ func TestStore_SavePathTraversal(t *testing.T) {
 	}
 }
 
-func TestStore_FilePath(t *testing.T) {
+func TestStore_Open(t *testing.T) {
 	s := newTestStore(t)
 	ctx := context.Background()
 
-	_, err := s.Save(ctx, "doc.pdf", strings.NewReader("pdf content"), 11)
+	_, err := s.Save(ctx, "doc.pdf", strings.NewReader("pdf content"), 11, SaveOptions{})
 	if err != nil {
 		t.Fatalf("Save failed: %v", err)
 	}
 
-	path, err := s.FilePath("doc.pdf")
+	f, info, err := s.Open(ctx, "doc.pdf")
 	if err != nil {
-		t.Fatalf("FilePath failed: %v", err)
+		t.Fatalf("Open failed: %v", err)
 	}
+	defer f.Close()
 
-	if filepath.Base(path) != "doc.pdf" {
-		t.Errorf("expected path ending in doc.pdf, got %q", path)
+	if info.Name != "doc.pdf" {
+		t.Errorf("expected name doc.pdf, got %q", info.Name)
 	}
 }
 
-func TestStore_FilePathNotFound(t *testing.T) {
+func TestStore_OpenNotFound(t *testing.T) {
 	s := newTestStore(t)
 
-	_, err := s.FilePath("nonexistent.txt")
+	_, _, err := s.Open(context.Background(), "nonexistent.txt")
 	if err != ErrNotFound {
 		t.Errorf("expected ErrNotFound, got %v", err)
 	}
 }
 
+func TestStore_OpenSurvivesDelete(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := s.Save(ctx, "doc.txt", strings.NewReader("contents"), 8, SaveOptions{}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	f, _, err := s.Open(ctx, "doc.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	if err := s.Delete(ctx, "doc.txt"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(data) != "contents" {
+		t.Errorf("expected contents, got %q", data)
+	}
+}
+
 func TestStore_Delete(t *testing.T) {
 	s := newTestStore(t)
 	ctx := context.Background()
 
-	_, err := s.Save(ctx, "remove.txt", strings.NewReader("bye"), 3)
+	_, err := s.Save(ctx, "remove.txt", strings.NewReader("bye"), 3, SaveOptions{})
 	if err != nil {
 		t.Fatalf("Save failed: %v", err)
 	}
@@ -133,7 +215,7 @@ func TestStore_Delete(t *testing.T) {
 		t.Fatalf("Delete failed: %v", err)
 	}
 
-	_, err = s.FilePath("remove.txt")
+	_, err = s.Stat(ctx, "remove.txt")
 	if err != ErrNotFound {
 		t.Errorf("expected ErrNotFound after delete, got %v", err)
 	}
@@ -164,7 +246,7 @@ func TestStore_ListSkipsDirs(t *testing.T) {
 	s := newTestStore(t)
 	ctx := context.Background()
 
-	_, err := s.Save(ctx, "file.txt", strings.NewReader("data"), 4)
+	_, err := s.Save(ctx, "file.txt", strings.NewReader("data"), 4, SaveOptions{})
 	if err != nil {
 		t.Fatalf("Save failed: %v", err)
 	}
@@ -186,15 +268,15 @@ func TestStore_CleanupExpired(t *testing.T) {
 	s := newTestStore(t)
 	ctx := context.Background()
 
-	_, err := s.Save(ctx, "old.txt", strings.NewReader("old"), 3)
+	_, err := s.Save(ctx, "old.txt", strings.NewReader("old"), 3, SaveOptions{})
 	if err != nil {
 		t.Fatalf("Save failed: %v", err)
 	}
 
 	oldTime := time.Now().Add(-2 * time.Hour)
-	os.Chtimes(filepath.Join(s.dir, "old.txt"), oldTime, oldTime)
+	backdate(t, s, "old.txt", oldTime)
 
-	if err := s.Cleanup(ctx, time.Hour); err != nil {
+	if _, err := s.Cleanup(ctx, time.Hour); err != nil {
 		t.Fatalf("Cleanup failed: %v", err)
 	}
 
@@ -207,16 +289,37 @@ func TestStore_CleanupExpired(t *testing.T) {
 	}
 }
 
+func TestStore_Expired(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	saveString(t, s, "old.txt", "old")
+	saveString(t, s, "fresh.txt", "fresh")
+	backdate(t, s, "old.txt", time.Now().Add(-2*time.Hour))
+
+	expired, err := s.Expired(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("Expired failed: %v", err)
+	}
+	if len(expired) != 1 || expired[0] != "old.txt" {
+		t.Errorf("expected old.txt, got %v", expired)
+	}
+
+	if _, err := s.Stat(ctx, "old.txt"); err != nil {
+		t.Errorf("expected old.txt to be kept, got %v", err)
+	}
+}
+
 func TestStore_CleanupKeepsFresh(t *testing.T) {
 	s := newTestStore(t)
 	ctx := context.Background()
 
-	_, err := s.Save(ctx, "fresh.txt", strings.NewReader("new"), 3)
+	_, err := s.Save(ctx, "fresh.txt", strings.NewReader("new"), 3, SaveOptions{})
 	if err != nil {
 		t.Fatalf("Save failed: %v", err)
 	}
 
-	if err := s.Cleanup(ctx, time.Hour); err != nil {
+	if _, err := s.Cleanup(ctx, time.Hour); err != nil {
 		t.Fatalf("Cleanup failed: %v", err)
 	}
 
@@ -229,23 +332,77 @@ func TestStore_CleanupKeepsFresh(t *testing.T) {
 	}
 }
 
+func TestStore_CleanupZeroMaxAge(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := s.Save(ctx, "old.txt", strings.NewReader("old"), 3, SaveOptions{}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	backdate(t, s, "old.txt", time.Now().Add(-365*24*time.Hour))
+
+	if _, err := s.Save(ctx, "short.txt", strings.NewReader("x"), 1, SaveOptions{TTL: time.Minute}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	m, err := s.readMeta("short.txt")
+	if err != nil {
+		t.Fatalf("readMeta failed: %v", err)
+	}
+	past := time.Now().Add(-time.Second)
+	m.ExpiresAt = &past
+	if err := s.writeMeta("short.txt", m); err != nil {
+		t.Fatalf("writeMeta failed: %v", err)
+	}
+
+	res, err := s.Cleanup(ctx, 0)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if len(res.Removed) != 1 || res.Removed[0] != "short.txt" {
+		t.Errorf("expected only the file past its TTL removed, got %v", res.Removed)
+	}
+}
+
+func TestStore_CleanupReportsFailures(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	saveString(t, s, "old.txt", "old")
+	saveString(t, s, "damaged.txt", "damaged")
+	backdate(t, s, "old.txt", time.Now().Add(-2*time.Hour))
+	if err := os.WriteFile(s.metaPath("damaged.txt"), []byte("not json"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	res, err := s.Cleanup(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if len(res.Removed) != 1 || res.Removed[0] != "old.txt" {
+		t.Errorf("expected old.txt removed, got %v", res.Removed)
+	}
+	if len(res.Failed) != 1 || res.Failed[0].Name != "damaged.txt" || res.Failed[0].Err == nil {
+		t.Errorf("expected damaged.txt reported as failed, got %+v", res.Failed)
+	}
+}
+
 func TestStore_CleanupMixed(t *testing.T) {
 	s := newTestStore(t)
 	ctx := context.Background()
 
-	_, err := s.Save(ctx, "old.txt", strings.NewReader("old"), 3)
+	_, err := s.Save(ctx, "old.txt", strings.NewReader("old"), 3, SaveOptions{})
 	if err != nil {
 		t.Fatalf("Save failed: %v", err)
 	}
 	oldTime := time.Now().Add(-2 * time.Hour)
-	os.Chtimes(filepath.Join(s.dir, "old.txt"), oldTime, oldTime)
+	backdate(t, s, "old.txt", oldTime)
 
-	_, err = s.Save(ctx, "new.txt", strings.NewReader("new"), 3)
+	_, err = s.Save(ctx, "new.txt", strings.NewReader("new"), 3, SaveOptions{})
 	if err != nil {
 		t.Fatalf("Save failed: %v", err)
 	}
 
-	if err := s.Cleanup(ctx, time.Hour); err != nil {
+	if _, err := s.Cleanup(ctx, time.Hour); err != nil {
 		t.Fatalf("Cleanup failed: %v", err)
 	}
 
@@ -260,3 +417,582 @@ func TestStore_CleanupMixed(t *testing.T) {
 		t.Errorf("expected new.txt to remain, got %q", files[0].Name)
 	}
 }
+
+func TestStore_PublishesFileEvents(t *testing.T) {
+	dir := t.TempDir()
+	pub := &mockPublisher{}
+	s, err := NewStore(dir, pub, Options{})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := s.Save(ctx, "a.txt", strings.NewReader("a"), 1, SaveOptions{}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := s.Delete(ctx, "a.txt"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	if len(pub.events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(pub.events))
+	}
+	if pub.events[0].Type != events.FileCreated {
+		t.Errorf("expected %q, got %q", events.FileCreated, pub.events[0].Type)
+	}
+	if pub.events[1].Type != events.FileDeleted {
+		t.Errorf("expected %q, got %q", events.FileDeleted, pub.events[1].Type)
+	}
+	if info := pub.events[1].Data.(Info); info.Name != "a.txt" {
+		t.Errorf("expected deleted name %q, got %q", "a.txt", info.Name)
+	}
+}
+
+func TestStore_CleanupReportsRemoved(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := s.Save(ctx, "old.txt", strings.NewReader("old"), 3, SaveOptions{}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	oldTime := time.Now().Add(-2 * time.Hour)
+	backdate(t, s, "old.txt", oldTime)
+
+	res, err := s.Cleanup(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if len(res.Removed) != 1 || res.Removed[0] != "old.txt" {
+		t.Errorf("expected old.txt reported as removed, got %v", res.Removed)
+	}
+}
+
+func TestStore_SaveWithTTL(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	info, err := s.Save(ctx, "short.txt", strings.NewReader("x"), 1, SaveOptions{TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if info.ExpiresAt == nil || !info.ExpiresAt.Equal(info.UploadedAt.Add(time.Minute)) {
+		t.Fatalf("expected expiry a minute after upload, got %v", info.ExpiresAt)
+	}
+
+	files, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(files) != 1 || files[0].ExpiresAt == nil || !files[0].ExpiresAt.Equal(*info.ExpiresAt) {
+		t.Fatalf("expected List to report the expiry, got %+v", files)
+	}
+
+	past := time.Now().Add(-time.Second)
+	if err := s.writeMeta("short.txt", metadata{ExpiresAt: &past}); err != nil {
+		t.Fatalf("writeMeta failed: %v", err)
+	}
+
+	res, err := s.Cleanup(ctx, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if len(res.Removed) != 1 || res.Removed[0] != "short.txt" {
+		t.Errorf("expected short.txt removed, got %v", res.Removed)
+	}
+	if _, err := os.Stat(s.metaPath("short.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected metadata removed with the file, got %v", err)
+	}
+}
+
+func TestStore_SaveNeverExpires(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	info, err := s.Save(ctx, "driver.zip", strings.NewReader("zip"), 3, SaveOptions{TTL: -1})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if !info.NeverExpires {
+		t.Fatal("expected file to never expire")
+	}
+
+	oldTime := time.Now().Add(-48 * time.Hour)
+	backdate(t, s, "driver.zip", oldTime)
+
+	if _, err := s.Cleanup(ctx, time.Hour); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	files, _ := s.List(ctx)
+	if len(files) != 1 {
+		t.Errorf("expected file kept, got %d files", len(files))
+	}
+}
+
+func TestStore_DeleteRemovesMetadata(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := s.Save(ctx, "a.txt", strings.NewReader("a"), 1, SaveOptions{TTL: time.Hour}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := s.Delete(ctx, "a.txt"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	if _, err := os.Stat(s.metaPath("a.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected metadata removed, got %v", err)
+	}
+}
+
+func TestStore_PinSkipsCleanup(t *testing.T) {
+	pub := &mockPublisher{}
+	s, err := NewStore(t.TempDir(), pub, Options{})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := s.Save(ctx, "qr.png", strings.NewReader("png"), 3, SaveOptions{}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	info, err := s.Pin(ctx, "qr.png", true)
+	if err != nil {
+		t.Fatalf("Pin failed: %v", err)
+	}
+	if !info.Pinned {
+		t.Error("expected info to report pinned")
+	}
+	if last := pub.events[len(pub.events)-1]; last.Type != events.FileUpdated {
+		t.Errorf("expected %s event, got %s", events.FileUpdated, last.Type)
+	}
+
+	oldTime := time.Now().Add(-48 * time.Hour)
+	backdate(t, s, "qr.png", oldTime)
+
+	res, err := s.Cleanup(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if len(res.Removed) != 0 {
+		t.Fatalf("expected pinned file kept, removed %v", res.Removed)
+	}
+
+	if _, err := s.Save(ctx, "qr.png", strings.NewReader("png2"), 4, SaveOptions{OnConflict: ConflictOverwrite}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	files, _ := s.List(ctx)
+	if len(files) != 1 || !files[0].Pinned {
+		t.Errorf("expected replaced file to stay pinned, got %+v", files)
+	}
+}
+
+func TestStore_PinNotFound(t *testing.T) {
+	s := newTestStore(t)
+
+	if _, err := s.Pin(context.Background(), "missing.txt", true); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStore_OpenBurnAfterReading(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := s.Save(ctx, "secret.txt", strings.NewReader("secret"), 6, SaveOptions{BurnAfterReading: true}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	info, err := s.Stat(ctx, "secret.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if !info.BurnAfterReading {
+		t.Error("expected Stat to report burn-after-reading")
+	}
+
+	f, info, err := s.Open(ctx, "secret.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("failed to read opened file: %v", err)
+	}
+	if string(data) != "secret" || info.Size != 6 {
+		t.Errorf("unexpected file %q with info %+v", data, info)
+	}
+
+	if _, _, err := s.Open(ctx, "secret.txt"); err != ErrNotFound {
+		t.Errorf("expected second Open to fail, got %v", err)
+	}
+	files, _ := s.List(ctx)
+	if len(files) != 0 {
+		t.Errorf("expected file gone from List, got %+v", files)
+	}
+}
+
+func TestStore_BurnAfterReadingRedacted(t *testing.T) {
+	s := newTestStore(t)
+	pub := s.events.(*mockPublisher)
+	ctx := context.Background()
+
+	saved, err := s.Save(ctx, "secret.txt", strings.NewReader("1234"), -1, SaveOptions{BurnAfterReading: true})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if saved.SHA256 != "" || saved.ContentType != "" {
+		t.Errorf("expected Save to redact, got %+v", saved)
+	}
+	if created := pub.events[len(pub.events)-1].Data.(Info); created.SHA256 != "" || created.ContentType != "" {
+		t.Errorf("expected event to redact, got %+v", created)
+	}
+
+	files, _ := s.List(ctx)
+	if len(files) != 1 || files[0].SHA256 != "" || files[0].ContentType != "" {
+		t.Errorf("expected List to redact, got %+v", files)
+	}
+	info, _ := s.Stat(ctx, "secret.txt")
+	if info.SHA256 != "" || info.ContentType != "" {
+		t.Errorf("expected Stat to redact, got %+v", info)
+	}
+
+	sum := sha256.Sum256([]byte("1234"))
+	if ok, _ := s.HasContent(ctx, hex.EncodeToString(sum[:])); ok {
+		t.Error("expected HasContent to ignore burn-after-reading files")
+	}
+
+	f, opened, err := s.Open(ctx, "secret.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	f.Close()
+	if opened.SHA256 != hex.EncodeToString(sum[:]) || opened.ContentType == "" {
+		t.Errorf("expected Open to describe the file fully, got %+v", opened)
+	}
+}
+
+func TestStore_OpenKeepsRegularFiles(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := s.Save(ctx, "a.txt", strings.NewReader("a"), 1, SaveOptions{}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	for range 2 {
+		f, _, err := s.Open(ctx, "a.txt")
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		f.Close()
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestStore_SaveFailureKeepsOldFile(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := s.Save(ctx, "file.txt", strings.NewReader("original"), 8, SaveOptions{}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	r := io.MultiReader(strings.NewReader("partial"), failingReader{})
+	if _, err := s.Save(ctx, "file.txt", r, 100, SaveOptions{OnConflict: ConflictOverwrite}); err == nil {
+		t.Fatal("expected error from interrupted upload")
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, "file.txt"))
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if string(data) != "original" {
+		t.Errorf("expected original content, got %q", data)
+	}
+
+	leftovers, err := os.ReadDir(s.tmpDir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(leftovers) != 0 {
+		t.Errorf("expected no temp files, got %d", len(leftovers))
+	}
+}
+
+func TestNewStore_SweepsInterruptedUploads(t *testing.T) {
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "tmp")
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, ".tmp-123"), []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewStore(dir, &mockPublisher{}, Options{}); err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tmp, ".tmp-123")); !os.IsNotExist(err) {
+		t.Errorf("expected interrupted upload to be removed, got %v", err)
+	}
+}
+
+func TestStore_ListDuringUpload(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Save(ctx, "slow.bin", pr, 0, SaveOptions{})
+		done <- err
+	}()
+
+	if _, err := pw.Write([]byte("first chunk")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	listed := make(chan []Info, 1)
+	go func() {
+		files, _ := s.List(ctx)
+		listed <- files
+	}()
+
+	select {
+	case files := <-listed:
+		if len(files) != 0 {
+			t.Errorf("expected unfinished upload to be hidden, got %d files", len(files))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("List blocked behind an in-progress upload")
+	}
+
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	files, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected 1 file after upload, got %d", len(files))
+	}
+}
+
+func BenchmarkStore_List(b *testing.B) {
+	s, err := NewStore(b.TempDir(), &mockPublisher{}, Options{})
+	if err != nil {
+		b.Fatalf("NewStore failed: %v", err)
+	}
+	benchmarkList(b, s)
+}
+
+func BenchmarkStore_ListDuringUploads(b *testing.B) {
+	s, err := NewStore(b.TempDir(), &mockPublisher{}, Options{})
+	if err != nil {
+		b.Fatalf("NewStore failed: %v", err)
+	}
+
+	// Each upload keeps trickling data until the benchmark ends, like a
+	// slow phone on the LAN.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range 8 {
+		pr, pw := io.Pipe()
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.Save(context.Background(), fmt.Sprintf("upload-%d.bin", i), pr, 0, SaveOptions{})
+		}()
+		go func() {
+			defer wg.Done()
+			defer pw.Close()
+			chunk := make([]byte, 4096)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := pw.Write(chunk); err != nil {
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+
+	benchmarkList(b, s)
+
+	close(stop)
+	wg.Wait()
+}
+
+func benchmarkList(b *testing.B, s *Store) {
+	ctx := context.Background()
+	for i := range 20 {
+		name := fmt.Sprintf("file-%d.txt", i)
+		if _, err := s.Save(ctx, name, strings.NewReader("data"), 4, SaveOptions{}); err != nil {
+			b.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	for b.Loop() {
+		if _, err := s.List(ctx); err != nil {
+			b.Fatalf("List failed: %v", err)
+		}
+	}
+}
+
+func TestStore_SaveRecordsMetadata(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	uploader := Uploader{IP: "192.168.1.20", Device: "Pixel 8"}
+	before := time.Now()
+
+	info, err := s.Save(ctx, "page.html", strings.NewReader("<html><body>hi</body></html>"), -1, SaveOptions{Uploader: uploader})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	if info.ContentType != "text/html; charset=utf-8" {
+		t.Errorf("expected sniffed html, got %q", info.ContentType)
+	}
+	if info.SHA256 != "95d70659530e385bfae5d6eefe689d95ac463cb0c58235f19eef71bdaa725126" {
+		t.Errorf("unexpected sha256 %q", info.SHA256)
+	}
+	if info.Uploader != uploader {
+		t.Errorf("expected uploader %+v, got %+v", uploader, info.Uploader)
+	}
+	if info.OriginalName != "page.html" {
+		t.Errorf("expected original name page.html, got %q", info.OriginalName)
+	}
+	if info.UploadedAt.Before(before) {
+		t.Errorf("expected upload time after %v, got %v", before, info.UploadedAt)
+	}
+
+	files, _ := s.List(ctx)
+	if len(files) != 1 || files[0].SHA256 != info.SHA256 || !files[0].UploadedAt.Equal(info.UploadedAt) {
+		t.Errorf("expected List to report the recorded metadata, got %+v", files)
+	}
+}
+
+func TestStore_CleanupIgnoresTouch(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := s.Save(ctx, "old.txt", strings.NewReader("old"), 3, SaveOptions{}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	backdate(t, s, "old.txt", time.Now().Add(-2*time.Hour))
+
+	// Touching the file, e.g. by restoring a backup, does not make it new.
+	now := time.Now()
+	os.Chtimes(filepath.Join(s.dir, "old.txt"), now, now)
+
+	res, err := s.Cleanup(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if len(res.Removed) != 1 {
+		t.Errorf("expected old.txt removed, got %v", res.Removed)
+	}
+}
+
+func TestStore_ListFallsBackToModTime(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	// A file from before metadata was recorded.
+	path := filepath.Join(s.dir, "legacy.txt")
+	os.WriteFile(path, []byte("legacy"), 0o644)
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(path, mtime, mtime)
+
+	files, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(files) != 1 || !files[0].UploadedAt.Equal(mtime) {
+		t.Errorf("expected upload time %v, got %+v", mtime, files)
+	}
+}
+
+func TestStore_Each(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	s.Save(ctx, "b.txt", strings.NewReader("bee"), -1, SaveOptions{})
+	s.Save(ctx, "a.txt", strings.NewReader("ay"), -1, SaveOptions{})
+	s.Save(ctx, "secret.txt", strings.NewReader("psst"), -1, SaveOptions{BurnAfterReading: true})
+
+	read := func(names []string) map[string]string {
+		got := make(map[string]string)
+		var order []string
+		err := s.Each(ctx, names, func(info Info, r io.Reader) error {
+			data, err := io.ReadAll(r)
+			got[info.Name] = string(data)
+			order = append(order, info.Name)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Each failed: %v", err)
+		}
+		if !slices.IsSorted(order) {
+			t.Errorf("expected name order, got %v", order)
+		}
+		return got
+	}
+
+	all := read(nil)
+	if len(all) != 2 || all["a.txt"] != "ay" || all["b.txt"] != "bee" {
+		t.Errorf("expected a.txt and b.txt without the secret, got %v", all)
+	}
+
+	subset := read([]string{"b.txt", "missing.txt", "../b.txt"})
+	if len(subset) != 1 || subset["b.txt"] != "bee" {
+		t.Errorf("expected only b.txt, got %v", subset)
+	}
+
+	if _, err := s.Stat(ctx, "secret.txt"); err != nil {
+		t.Errorf("expected burn-after-reading file untouched, got %v", err)
+	}
+}
+
+func TestStore_EachSurvivesConcurrentDelete(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	s.Save(ctx, "a.txt", strings.NewReader("first half, second half"), -1, SaveOptions{})
+
+	err := s.Each(ctx, nil, func(info Info, r io.Reader) error {
+		head := make([]byte, 11)
+		io.ReadFull(r, head)
+
+		if err := s.Delete(ctx, info.Name); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+
+		rest, err := io.ReadAll(r)
+		if got := string(head) + string(rest); got != "first half, second half" {
+			t.Errorf("expected full content, got %q", got)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Each failed: %v", err)
+	}
+}
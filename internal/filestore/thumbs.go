@@ -0,0 +1,167 @@
+package filestore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/d6o/homeclip/internal/atomicfile"
+	"github.com/d6o/homeclip/internal/thumbnail"
+)
+
+var ErrNoThumbnail = errors.New("file is not a JPEG, PNG or GIF image")
+
+// Thumbnail is a small preview of an image file.
+type Thumbnail struct {
+	Data        []byte
+	ContentType string
+	ModTime     time.Time
+}
+
+// Thumbnails are cached in thumbDir by content hash and size, so files with
+// the same content share them and they are removed together with the blob.
+
+var thumbExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// Thumbnail returns a preview of an image file that fits in a size×size
+// square, rendering and caching it on first use.
+func (s *Store) Thumbnail(ctx context.Context, name string, size int) (Thumbnail, error) {
+	clean := filepath.Base(name)
+
+	unlock := s.lockName(clean)
+	f, info, err := s.open(clean)
+	unlock()
+
+	if err != nil {
+		return Thumbnail{}, err
+	}
+	defer f.Close()
+
+	// A preview would give the content away without consuming the file.
+	if info.BurnAfterReading {
+		return Thumbnail{}, ErrNoThumbnail
+	}
+	if info.ContentType != "" && !strings.HasPrefix(info.ContentType, "image/") {
+		return Thumbnail{}, ErrNoThumbnail
+	}
+
+	t := Thumbnail{ModTime: info.UploadedAt}
+
+	if info.SHA256 != "" {
+		if data, ct, ok := s.cachedThumb(info.SHA256, size); ok {
+			t.Data, t.ContentType = data, ct
+			return t, nil
+		}
+	}
+
+	// Decoding takes far more memory than anything else the store does,
+	// so only one image is rendered at a time.
+	select {
+	case s.renderSem <- struct{}{}:
+	case <-ctx.Done():
+		return Thumbnail{}, ctx.Err()
+	}
+	defer func() { <-s.renderSem }()
+
+	// Another request may have rendered it while this one waited.
+	if info.SHA256 != "" {
+		if data, ct, ok := s.cachedThumb(info.SHA256, size); ok {
+			t.Data, t.ContentType = data, ct
+			return t, nil
+		}
+	}
+
+	var buf bytes.Buffer
+	t.ContentType, err = thumbnail.Render(&buf, f, size)
+	if err != nil {
+		if errors.Is(err, thumbnail.ErrUnsupported) || errors.Is(err, thumbnail.ErrTooLarge) {
+			return Thumbnail{}, ErrNoThumbnail
+		}
+		return Thumbnail{}, err
+	}
+	t.Data = buf.Bytes()
+
+	// Files stored before checksums were recorded have no hash to cache
+	// under.
+	if info.SHA256 != "" {
+		if err := s.cacheThumb(info.SHA256, size, t); err != nil {
+			slog.Error("failed to cache thumbnail", "name", clean, "error", err)
+		}
+	}
+
+	return t, nil
+}
+
+func (s *Store) cachedThumb(sha256 string, size int) ([]byte, string, bool) {
+	for ct, ext := range thumbExts {
+		data, err := os.ReadFile(s.thumbPath(sha256, size, ext))
+		if err == nil {
+			return data, ct, true
+		}
+	}
+	return nil, "", false
+}
+
+// cacheThumb stores a rendered thumbnail unless its blob has been removed
+// while it was being rendered, which would leave it behind forever.
+func (s *Store) cacheThumb(sha256 string, size int, t Thumbnail) error {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	if s.refs[sha256] == 0 {
+		return nil
+	}
+
+	return atomicfile.WriteFile(s.thumbPath(sha256, size, thumbExts[t.ContentType]), t.Data, 0o644)
+}
+
+// removeThumbs drops every cached thumbnail of a blob. The caller holds
+// blobMu.
+func (s *Store) removeThumbs(sha256 string) error {
+	paths, err := filepath.Glob(filepath.Join(s.thumbDir, sha256+"-*"))
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sweepThumbs removes thumbnails of blobs that are no longer stored. It
+// runs before the store is shared.
+func (s *Store) sweepThumbs() error {
+	entries, err := os.ReadDir(s.thumbDir)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, e := range entries {
+		sha256, _, _ := strings.Cut(e.Name(), "-")
+		if s.refs[sha256] > 0 {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.thumbDir, e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Store) thumbPath(sha256 string, size int, ext string) string {
+	return filepath.Join(s.thumbDir, fmt.Sprintf("%s-%d%s", sha256, size, ext))
+}
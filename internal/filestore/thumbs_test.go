@@ -0,0 +1,189 @@
+package filestore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func savePNG(t *testing.T, s *Store, name string, w, h int, opts SaveOptions) Info {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("png.Encode failed: %v", err)
+	}
+	info, err := s.Save(context.Background(), name, &buf, -1, opts)
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	return info
+}
+
+func countThumbs(t *testing.T, s *Store) int {
+	t.Helper()
+	entries, err := os.ReadDir(s.thumbDir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	return len(entries)
+}
+
+func TestStore_Thumbnail(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	savePNG(t, s, "screenshot.png", 1024, 512, SaveOptions{})
+
+	thumb, err := s.Thumbnail(ctx, "screenshot.png", 256)
+	if err != nil {
+		t.Fatalf("Thumbnail failed: %v", err)
+	}
+	if thumb.ContentType != "image/png" {
+		t.Errorf("expected image/png, got %s", thumb.ContentType)
+	}
+
+	cfg, err := png.DecodeConfig(bytes.NewReader(thumb.Data))
+	if err != nil {
+		t.Fatalf("thumbnail does not decode: %v", err)
+	}
+	if cfg.Width != 256 || cfg.Height != 128 {
+		t.Errorf("expected 256x128, got %dx%d", cfg.Width, cfg.Height)
+	}
+
+	if n := countThumbs(t, s); n != 1 {
+		t.Fatalf("expected 1 cached thumbnail, got %d", n)
+	}
+
+	cached, err := s.Thumbnail(ctx, "screenshot.png", 256)
+	if err != nil {
+		t.Fatalf("Thumbnail failed: %v", err)
+	}
+	if !bytes.Equal(cached.Data, thumb.Data) {
+		t.Error("expected the cached thumbnail to be served")
+	}
+}
+
+func TestStore_ThumbnailNotImage(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := s.Save(ctx, "notes.txt", strings.NewReader("just text"), -1, SaveOptions{}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	// Sniffed as an image but not one that decodes.
+	if _, err := s.Save(ctx, "broken.png", strings.NewReader("\x89PNG\r\n\x1a\ntruncated"), -1, SaveOptions{}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	for _, name := range []string{"notes.txt", "broken.png"} {
+		if _, err := s.Thumbnail(ctx, name, 256); !errors.Is(err, ErrNoThumbnail) {
+			t.Errorf("%s: expected ErrNoThumbnail, got %v", name, err)
+		}
+	}
+
+	if _, err := s.Thumbnail(ctx, "missing.png", 256); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStore_ThumbnailBurnAfterReading(t *testing.T) {
+	s := newTestStore(t)
+
+	savePNG(t, s, "secret.png", 64, 64, SaveOptions{BurnAfterReading: true})
+
+	if _, err := s.Thumbnail(context.Background(), "secret.png", 32); !errors.Is(err, ErrNoThumbnail) {
+		t.Errorf("expected ErrNoThumbnail, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, "secret.png")); err != nil {
+		t.Errorf("expected the file to survive, got %v", err)
+	}
+}
+
+func TestStore_ThumbnailInvalidation(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	savePNG(t, s, "a.png", 64, 64, SaveOptions{})
+	savePNG(t, s, "b.png", 32, 32, SaveOptions{})
+
+	for _, name := range []string{"a.png", "b.png"} {
+		if _, err := s.Thumbnail(ctx, name, 16); err != nil {
+			t.Fatalf("Thumbnail failed: %v", err)
+		}
+	}
+
+	if err := s.Delete(ctx, "a.png"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if n := countThumbs(t, s); n != 1 {
+		t.Errorf("expected 1 thumbnail after Delete, got %d", n)
+	}
+
+	backdate(t, s, "b.png", time.Now().Add(-2*time.Hour))
+	if _, err := s.Cleanup(ctx, time.Hour); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if n := countThumbs(t, s); n != 0 {
+		t.Errorf("expected no thumbnails after Cleanup, got %d", n)
+	}
+}
+
+func TestNewStore_SweepsOrphanedThumbnails(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir, &mockPublisher{}, Options{})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	savePNG(t, s, "kept.png", 32, 32, SaveOptions{})
+	if _, err := s.Thumbnail(context.Background(), "kept.png", 16); err != nil {
+		t.Fatalf("Thumbnail failed: %v", err)
+	}
+	orphan := s.thumbPath(strings.Repeat("0", 64), 16, ".png")
+	if err := os.WriteFile(orphan, []byte("stale"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	s, err = NewStore(dir, &mockPublisher{}, Options{})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("expected orphaned thumbnail to be removed")
+	}
+	if n := countThumbs(t, s); n != 1 {
+		t.Errorf("expected the live thumbnail to be kept, got %d", n)
+	}
+}
+
+func TestStore_ThumbnailRendersOneAtATime(t *testing.T) {
+	s := newTestStore(t)
+
+	savePNG(t, s, "cached.png", 64, 64, SaveOptions{})
+	savePNG(t, s, "new.png", 32, 32, SaveOptions{})
+	if _, err := s.Thumbnail(context.Background(), "cached.png", 16); err != nil {
+		t.Fatalf("Thumbnail failed: %v", err)
+	}
+
+	// Hold the only render slot as a concurrent render would.
+	s.renderSem <- struct{}{}
+	defer func() { <-s.renderSem }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := s.Thumbnail(ctx, "cached.png", 16); err != nil {
+		t.Errorf("expected a cached thumbnail without waiting, got %v", err)
+	}
+	if _, err := s.Thumbnail(ctx, "new.png", 16); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the render to wait for its turn, got %v", err)
+	}
+}
@@ -0,0 +1,482 @@
+package filestore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/d6o/homeclip/internal/atomicfile"
+)
+
+var (
+	ErrUploadNotFound = errors.New("upload not found")
+	ErrOffsetMismatch = errors.New("upload offset does not match")
+	ErrInvalidLength  = errors.New("upload length must not be negative")
+	ErrUploadBusy     = errors.New("upload is already receiving a chunk")
+)
+
+// Upload is a file being sent in chunks. It is kept in the upload area and
+// only becomes visible as a file once Offset reaches Length. From then on
+// Name is the name the file was stored under.
+type Upload struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Length int64  `json:"length"`
+	Offset int64  `json:"offset"`
+}
+
+// uploadRecord is what is stored next to the partial data; the offset is
+// the size of the data itself. Once the upload is complete the data is gone
+// and the record stays behind, with Completed set and Name updated, so that
+// a client that missed the last response can still find out.
+type uploadRecord struct {
+	Name             string         `json:"name"`
+	Length           int64          `json:"length"`
+	CreatedAt        time.Time      `json:"createdAt"`
+	TTL              time.Duration  `json:"ttl,omitempty"`
+	BurnAfterReading bool           `json:"burnAfterReading,omitempty"`
+	OnConflict       ConflictPolicy `json:"onConflict,omitempty"`
+	Uploader         Uploader       `json:"uploader,omitzero"`
+	Completed        bool           `json:"completed,omitempty"`
+}
+
+// CreateUpload starts a chunked upload of length bytes. An empty upload is
+// complete straight away.
+func (s *Store) CreateUpload(_ context.Context, name string, length int64, opts SaveOptions) (Upload, error) {
+	if length < 0 {
+		return Upload{}, ErrInvalidLength
+	}
+	if length > s.maxSize {
+		return Upload{}, ErrTooLarge
+	}
+
+	policy := s.policy(opts.OnConflict)
+	if policy == ConflictReject && s.taken(filepath.Base(name)) {
+		return Upload{}, ErrExists
+	}
+	hold, err := s.reserve(filepath.Base(name), length, policy == ConflictOverwrite)
+	if err != nil {
+		return Upload{}, err
+	}
+	defer hold.release()
+
+	id, err := newUploadID()
+	if err != nil {
+		return Upload{}, err
+	}
+
+	rec := uploadRecord{
+		Name:             filepath.Base(name),
+		Length:           length,
+		CreatedAt:        time.Now(),
+		TTL:              opts.TTL,
+		BurnAfterReading: opts.BurnAfterReading,
+		OnConflict:       policy,
+		Uploader:         opts.Uploader,
+	}
+
+	unlock := s.uploads.lock(id)
+	defer unlock()
+
+	f, err := os.OpenFile(s.partPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return Upload{}, err
+	}
+	f.Close()
+
+	if err := s.writeUploadRecord(id, rec); err != nil {
+		os.Remove(s.partPath(id))
+		return Upload{}, err
+	}
+	// From here on the record counts against the quota instead.
+	hold.release()
+
+	u := Upload{ID: id, Name: rec.Name, Length: length}
+	if length == 0 {
+		u.Name, err = s.completeUpload(id, rec)
+		return u, err
+	}
+
+	return u, nil
+}
+
+// Upload reports how much of an upload has been received, counting what has
+// arrived of a chunk still being received.
+func (s *Store) Upload(_ context.Context, id string) (Upload, error) {
+	if !validUploadID(id) {
+		return Upload{}, ErrUploadNotFound
+	}
+
+	unlock := s.uploads.lock(id)
+	defer unlock()
+
+	_, u, err := s.readUpload(id)
+	return u, err
+}
+
+// WriteUpload appends r to an upload that has received exactly offset
+// bytes so far. Whatever arrives before r fails is kept, so the client can
+// resume from the returned offset. The final chunk publishes the file. Only
+// one chunk is received at a time; another one fails with ErrUploadBusy.
+func (s *Store) WriteUpload(_ context.Context, id string, offset int64, r io.Reader) (Upload, error) {
+	if !validUploadID(id) {
+		return Upload{}, ErrUploadNotFound
+	}
+
+	u, f, err := s.startChunk(id, offset)
+	if err != nil || f == nil {
+		return u, err
+	}
+	defer s.endChunk(id)
+
+	// The client is read without the upload's lock, so that asking for the
+	// offset does not wait for a chunk that may never finish.
+	n, copyErr := io.Copy(f, io.LimitReader(r, u.Length-u.Offset))
+	u.Offset += n
+
+	if err := errors.Join(copyErr, f.Sync(), f.Close()); err != nil {
+		return u, err
+	}
+
+	if u.Offset < u.Length {
+		return u, nil
+	}
+
+	unlock := s.uploads.lock(id)
+	defer unlock()
+
+	// The upload may have been terminated while the chunk arrived.
+	rec, err := s.readRecord(id)
+	if err != nil {
+		return u, err
+	}
+
+	final, err := s.completeUpload(id, rec)
+	if err != nil {
+		return u, err
+	}
+	u.Name = final
+
+	return u, nil
+}
+
+// startChunk checks that offset is where the upload stands and marks it as
+// receiving a chunk, returning the part to append to. A completed upload
+// has no part and nothing to receive.
+func (s *Store) startChunk(id string, offset int64) (Upload, *os.File, error) {
+	unlock := s.uploads.lock(id)
+	defer unlock()
+
+	s.writingMu.Lock()
+	defer s.writingMu.Unlock()
+
+	if s.writing[id] {
+		return Upload{}, nil, ErrUploadBusy
+	}
+
+	rec, u, err := s.readUpload(id)
+	if err != nil {
+		return Upload{}, nil, err
+	}
+
+	if offset != u.Offset {
+		return u, nil, ErrOffsetMismatch
+	}
+	if rec.Completed {
+		return u, nil, nil
+	}
+
+	f, err := os.OpenFile(s.partPath(id), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return u, nil, err
+	}
+	s.writing[id] = true
+
+	return u, f, nil
+}
+
+func (s *Store) endChunk(id string) {
+	s.writingMu.Lock()
+	defer s.writingMu.Unlock()
+
+	delete(s.writing, id)
+}
+
+func (s *Store) receiving(id string) bool {
+	s.writingMu.Lock()
+	defer s.writingMu.Unlock()
+
+	return s.writing[id]
+}
+
+// DeleteUpload discards an unfinished upload.
+func (s *Store) DeleteUpload(_ context.Context, id string) error {
+	if !validUploadID(id) {
+		return ErrUploadNotFound
+	}
+
+	unlock := s.uploads.lock(id)
+	defer unlock()
+
+	if _, err := os.Stat(s.recordPath(id)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrUploadNotFound
+		}
+		return err
+	}
+
+	return s.removeUpload(id)
+}
+
+// completeUpload moves the received data into the files directory. The
+// caller holds the upload's lock. An upload that does not fit in the quota,
+// or whose name was taken meanwhile and that may not replace or rename, is
+// discarded. It returns the name the file was stored under.
+func (s *Store) completeUpload(id string, rec uploadRecord) (string, error) {
+	d, err := s.digestUpload(id)
+	if err != nil {
+		return "", err
+	}
+
+	m := d.metadata(rec.Name)
+	policy := s.policy(rec.OnConflict)
+
+	held := reservation{files: 1, bytes: rec.Length}
+	release, err := s.admit(rec.Name, m.Size, m.SHA256, policy == ConflictOverwrite, held)
+	if err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			s.removeUpload(id)
+		}
+		return "", err
+	}
+	defer release()
+
+	final, unlock, err := s.claim(rec.Name, policy)
+	if err != nil {
+		if errors.Is(err, ErrExists) {
+			s.removeUpload(id)
+		}
+		return "", err
+	}
+	defer unlock()
+
+	commit := func(blob string) error { return os.Rename(s.partPath(id), blob) }
+	discard := func() { os.Remove(s.partPath(id)) }
+
+	if err := s.link(final, m.SHA256, commit, discard); err != nil {
+		return "", err
+	}
+
+	done := rec
+	done.Name = final
+	done.Completed = true
+	if err := s.writeUploadRecord(id, done); err != nil {
+		return "", err
+	}
+
+	opts := SaveOptions{TTL: rec.TTL, BurnAfterReading: rec.BurnAfterReading, Uploader: rec.Uploader}
+	if _, err := s.finish(final, m, opts); err != nil {
+		return "", err
+	}
+
+	return final, nil
+}
+
+func (s *Store) digestUpload(id string) (*digest, error) {
+	f, err := os.Open(s.partPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d := newDigest()
+	if _, err := io.Copy(d, f); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// cleanupUploads removes uploads that have not received any data for
+// longer than maxAge.
+func (s *Store) cleanupUploads(maxAge time.Duration) error {
+	entries, err := os.ReadDir(s.uploadDir)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		id, ok := uploadIDFromFile(e.Name())
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if err := s.cleanupUpload(id, now, maxAge); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *Store) cleanupUpload(id string, now time.Time, maxAge time.Duration) error {
+	unlock := s.uploads.lock(id)
+	defer unlock()
+
+	// A chunk on its way is activity, however long it takes.
+	if s.receiving(id) {
+		return nil
+	}
+
+	// A completed upload is aged by its record, which was written when it
+	// completed.
+	path := s.partPath(id)
+	if rec, err := s.readRecord(id); err == nil && rec.Completed {
+		path = s.recordPath(id)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// A record without data is left over from a crash during completion
+	// or creation and is removed regardless of age.
+	if err == nil && now.Sub(stat.ModTime()) <= maxAge {
+		return nil
+	}
+
+	return s.removeUpload(id)
+}
+
+func (s *Store) readRecord(id string) (uploadRecord, error) {
+	data, err := os.ReadFile(s.recordPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return uploadRecord{}, ErrUploadNotFound
+		}
+		return uploadRecord{}, err
+	}
+
+	var rec uploadRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return uploadRecord{}, err
+	}
+
+	return rec, nil
+}
+
+func (s *Store) readUpload(id string) (uploadRecord, Upload, error) {
+	rec, err := s.readRecord(id)
+	if err != nil {
+		return uploadRecord{}, Upload{}, err
+	}
+
+	if rec.Completed {
+		return rec, Upload{ID: id, Name: rec.Name, Length: rec.Length, Offset: rec.Length}, nil
+	}
+
+	stat, err := os.Stat(s.partPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return uploadRecord{}, Upload{}, ErrUploadNotFound
+		}
+		return uploadRecord{}, Upload{}, err
+	}
+
+	return rec, Upload{ID: id, Name: rec.Name, Length: rec.Length, Offset: stat.Size()}, nil
+}
+
+func (s *Store) writeUploadRecord(id string, rec uploadRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(s.recordPath(id), data, 0o644)
+}
+
+// pendingUploads adds up the uploads that have not completed yet, which
+// count against the quota at their declared length.
+func (s *Store) pendingUploads() (reservation, error) {
+	entries, err := os.ReadDir(s.uploadDir)
+	if err != nil {
+		return reservation{}, err
+	}
+
+	var r reservation
+	for _, e := range entries {
+		id, ok := uploadIDFromFile(e.Name())
+		if !ok || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+
+		rec, err := s.readRecord(id)
+		if err != nil || rec.Completed {
+			continue
+		}
+
+		r.files++
+		r.bytes += rec.Length
+	}
+
+	return r, nil
+}
+
+func (s *Store) removeUpload(id string) error {
+	var errs []error
+	for _, p := range []string{s.partPath(id), s.recordPath(id)} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Store) recordPath(id string) string {
+	return filepath.Join(s.uploadDir, id+".json")
+}
+
+func (s *Store) partPath(id string) string {
+	return filepath.Join(s.uploadDir, id+".part")
+}
+
+const uploadIDLen = 32
+
+func newUploadID() (string, error) {
+	b := make([]byte, uploadIDLen/2)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validUploadID keeps client supplied IDs from naming anything outside the
+// upload area.
+func validUploadID(id string) bool {
+	if len(id) != uploadIDLen {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func uploadIDFromFile(filename string) (string, bool) {
+	ext := filepath.Ext(filename)
+	if ext != ".json" && ext != ".part" {
+		return "", false
+	}
+	id := filename[:len(filename)-len(ext)]
+	return id, validUploadID(id)
+}
@@ -0,0 +1,308 @@
+package filestore
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d6o/homeclip/internal/events"
+)
+
+func TestStore_UploadInChunks(t *testing.T) {
+	dir := t.TempDir()
+	pub := &mockPublisher{}
+	s, err := NewStore(dir, pub, Options{})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	ctx := context.Background()
+
+	uploader := Uploader{IP: "10.0.0.5", Device: "phone"}
+	u, err := s.CreateUpload(ctx, "video.mp4", 11, SaveOptions{TTL: time.Hour, Uploader: uploader})
+	if err != nil {
+		t.Fatalf("CreateUpload failed: %v", err)
+	}
+
+	u, err = s.WriteUpload(ctx, u.ID, 0, strings.NewReader("hello "))
+	if err != nil {
+		t.Fatalf("WriteUpload failed: %v", err)
+	}
+	if u.Offset != 6 {
+		t.Errorf("expected offset 6, got %d", u.Offset)
+	}
+
+	files, _ := s.List(ctx)
+	if len(files) != 0 {
+		t.Fatalf("expected unfinished upload to be hidden, got %d files", len(files))
+	}
+
+	// The upload area survives a restart.
+	s, err = NewStore(dir, pub, Options{})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	got, err := s.Upload(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if got.Offset != 6 || got.Length != 11 {
+		t.Errorf("expected 6 of 11 bytes, got %d of %d", got.Offset, got.Length)
+	}
+
+	if _, err := s.WriteUpload(ctx, u.ID, 6, strings.NewReader("world")); err != nil {
+		t.Fatalf("WriteUpload failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, "video.mp4"))
+	if err != nil {
+		t.Fatalf("expected finished file: %v", err)
+	}
+	if string(data) != "hello world" {
+		t.Errorf("expected %q, got %q", "hello world", data)
+	}
+
+	info, err := s.Stat(ctx, "video.mp4")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.ExpiresAt == nil {
+		t.Error("expected the upload ttl to be applied")
+	}
+	if info.Uploader != uploader || info.SHA256 == "" || info.Size != 11 {
+		t.Errorf("expected recorded metadata, got %+v", info)
+	}
+
+	got, err = s.Upload(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("expected finished upload to be kept, got %v", err)
+	}
+	if got.Offset != 11 || got.Length != 11 || got.Name != "video.mp4" {
+		t.Errorf("expected 11 of 11 bytes of video.mp4, got %+v", got)
+	}
+
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+	if len(pub.events) != 1 || pub.events[0].Type != events.FileCreated {
+		t.Errorf("expected a single file.created event, got %+v", pub.events)
+	}
+}
+
+func TestStore_CompletedUploadReportsFinalName(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	saveString(t, s, "a.txt", "old")
+
+	u, _ := s.CreateUpload(ctx, "a.txt", 3, SaveOptions{OnConflict: ConflictRename})
+	if _, err := s.WriteUpload(ctx, u.ID, 0, strings.NewReader("new")); err != nil {
+		t.Fatalf("WriteUpload failed: %v", err)
+	}
+
+	got, err := s.Upload(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if got.Name != "a (1).txt" || got.Offset != 3 {
+		t.Errorf("expected a (1).txt complete, got %+v", got)
+	}
+
+	// A retried last chunk gets the final offset back and stores nothing.
+	if _, err := s.WriteUpload(ctx, u.ID, 0, strings.NewReader("new")); err != ErrOffsetMismatch {
+		t.Errorf("expected ErrOffsetMismatch for a stale offset, got %v", err)
+	}
+	if _, err := s.WriteUpload(ctx, u.ID, 3, strings.NewReader("")); err != nil {
+		t.Errorf("expected an empty write at the end to succeed, got %v", err)
+	}
+
+	files, _ := s.List(ctx)
+	if len(files) != 2 {
+		t.Errorf("expected 2 files, got %d", len(files))
+	}
+}
+
+func TestStore_WriteUploadOffsetMismatch(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	u, _ := s.CreateUpload(ctx, "a.txt", 10, SaveOptions{})
+	s.WriteUpload(ctx, u.ID, 0, strings.NewReader("abc"))
+
+	got, err := s.WriteUpload(ctx, u.ID, 0, strings.NewReader("abc"))
+	if err != ErrOffsetMismatch {
+		t.Fatalf("expected ErrOffsetMismatch, got %v", err)
+	}
+	if got.Offset != 3 {
+		t.Errorf("expected current offset 3, got %d", got.Offset)
+	}
+}
+
+func TestStore_UploadWhileReceivingChunk(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	u, _ := s.CreateUpload(ctx, "a.txt", 10, SaveOptions{})
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.WriteUpload(ctx, u.ID, 0, pr)
+		done <- err
+	}()
+
+	// The empty write returns only once the first bytes are in the part.
+	pw.Write([]byte("abc"))
+	pw.Write(nil)
+
+	got, err := s.Upload(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if got.Offset != 3 {
+		t.Errorf("expected offset 3 while the chunk arrives, got %d", got.Offset)
+	}
+
+	if _, err := s.WriteUpload(ctx, u.ID, 3, strings.NewReader("def")); err != ErrUploadBusy {
+		t.Errorf("expected ErrUploadBusy, got %v", err)
+	}
+
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("WriteUpload failed: %v", err)
+	}
+
+	if _, err := s.WriteUpload(ctx, u.ID, 3, strings.NewReader("defghij")); err != nil {
+		t.Errorf("expected the next chunk to be accepted, got %v", err)
+	}
+	if _, err := s.Stat(ctx, "a.txt"); err != nil {
+		t.Errorf("expected the upload to complete, got %v", err)
+	}
+}
+
+func TestStore_WriteUploadIgnoresExtraBytes(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	u, _ := s.CreateUpload(ctx, "a.txt", 3, SaveOptions{})
+	u, err := s.WriteUpload(ctx, u.ID, 0, strings.NewReader("abcdef"))
+	if err != nil {
+		t.Fatalf("WriteUpload failed: %v", err)
+	}
+	if u.Offset != 3 {
+		t.Errorf("expected offset 3, got %d", u.Offset)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(s.dir, "a.txt"))
+	if string(data) != "abc" {
+		t.Errorf("expected %q, got %q", "abc", data)
+	}
+}
+
+func TestStore_CreateEmptyUpload(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := s.CreateUpload(ctx, "empty.txt", 0, SaveOptions{}); err != nil {
+		t.Fatalf("CreateUpload failed: %v", err)
+	}
+
+	if _, err := s.Stat(ctx, "empty.txt"); err != nil {
+		t.Errorf("expected empty upload to be published, got %v", err)
+	}
+}
+
+func TestStore_CreateUploadTooLarge(t *testing.T) {
+	s := newTestStore(t)
+
+	_, err := s.CreateUpload(context.Background(), "big.bin", defaultMaxFileSize+1, SaveOptions{})
+	if err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestStore_UploadInvalidID(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"", "../files/x", strings.Repeat("z", uploadIDLen)} {
+		if _, err := s.Upload(ctx, id); err != ErrUploadNotFound {
+			t.Errorf("Upload(%q): expected ErrUploadNotFound, got %v", id, err)
+		}
+	}
+}
+
+func TestStore_DeleteUpload(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	u, _ := s.CreateUpload(ctx, "a.txt", 10, SaveOptions{})
+
+	if err := s.DeleteUpload(ctx, u.ID); err != nil {
+		t.Fatalf("DeleteUpload failed: %v", err)
+	}
+	if err := s.DeleteUpload(ctx, u.ID); err != ErrUploadNotFound {
+		t.Errorf("expected ErrUploadNotFound, got %v", err)
+	}
+
+	entries, _ := os.ReadDir(s.uploadDir)
+	if len(entries) != 0 {
+		t.Errorf("expected empty upload area, got %d entries", len(entries))
+	}
+}
+
+func TestStore_CleanupAbandonedUploads(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	stale, _ := s.CreateUpload(ctx, "stale.txt", 10, SaveOptions{})
+	fresh, _ := s.CreateUpload(ctx, "fresh.txt", 10, SaveOptions{})
+
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(s.partPath(stale.ID), old, old)
+
+	res, err := s.Cleanup(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+	if len(res.Removed) != 0 {
+		t.Errorf("expected partial uploads not to be reported, got %v", res.Removed)
+	}
+
+	if _, err := s.Upload(ctx, stale.ID); err != ErrUploadNotFound {
+		t.Errorf("expected stale upload to be removed, got %v", err)
+	}
+	if _, err := s.Upload(ctx, fresh.ID); err != nil {
+		t.Errorf("expected fresh upload to be kept, got %v", err)
+	}
+}
+
+func TestStore_CleanupCompletedUploads(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	stale, _ := s.CreateUpload(ctx, "stale.txt", 1, SaveOptions{})
+	s.WriteUpload(ctx, stale.ID, 0, strings.NewReader("a"))
+	fresh, _ := s.CreateUpload(ctx, "fresh.txt", 1, SaveOptions{})
+	s.WriteUpload(ctx, fresh.ID, 0, strings.NewReader("b"))
+
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(s.recordPath(stale.ID), old, old)
+
+	if _, err := s.Cleanup(ctx, time.Hour); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	if _, err := s.Upload(ctx, stale.ID); err != ErrUploadNotFound {
+		t.Errorf("expected stale completed upload to be removed, got %v", err)
+	}
+	if _, err := s.Upload(ctx, fresh.ID); err != nil {
+		t.Errorf("expected fresh completed upload to be kept, got %v", err)
+	}
+	if _, err := s.Stat(ctx, "fresh.txt"); err != nil {
+		t.Errorf("expected the file itself to stay, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+)
+
+// negotiate picks the offer the Accept header prefers. Ties go to the
+// earlier offer, and an empty header accepts the first one. It returns ""
+// when nothing is acceptable.
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := quality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
+
+// quality returns the q-value of the most specific media range matching
+// offer.
+func quality(accept, offer string) float64 {
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, _ := strings.Cut(part, ";")
+		mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+
+		s := -1
+		switch {
+		case mediaRange == offer:
+			s = 2
+		case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaRange, "*")):
+			s = 1
+		case mediaRange == "*/*":
+			s = 0
+		}
+		if s < 0 || s < specificity {
+			continue
+		}
+
+		specificity = s
+		q = 1
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.TrimSpace(k) == "q" {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+		}
+	}
+
+	return q
+}
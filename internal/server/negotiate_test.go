@@ -0,0 +1,28 @@
+package server
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"application/json", "text/plain", "text/html"}
+
+	cases := []struct {
+		accept string
+		want   string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"text/plain", "text/plain"},
+		{"text/*", "text/plain"},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "text/html"},
+		{"text/plain;q=0.5, text/html;q=0.9", "text/html"},
+		{"application/json;q=0.1, */*", "text/plain"},
+		{"text/*;q=0, text/plain", "text/plain"},
+		{"image/png", ""},
+	}
+
+	for _, tc := range cases {
+		if got := negotiate(tc.accept, offers); got != tc.want {
+			t.Errorf("negotiate(%q): expected %q, got %q", tc.accept, tc.want, got)
+		}
+	}
+}
@@ -1,47 +1,130 @@
 package server
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
 	"embed"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
+	"mime"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/d6o/homeclip/internal/cleanup"
 	"github.com/d6o/homeclip/internal/clipboard"
+	"github.com/d6o/homeclip/internal/collab"
+	"github.com/d6o/homeclip/internal/events"
 	"github.com/d6o/homeclip/internal/filestore"
 )
 
+const maxCollabMessage = 1 << 20
+
+var (
+	errInvalidTTL = errors.New("invalid ttl: use a duration such as 10m or 7d, or never")
+	errNoFile     = errors.New("no file part in request")
+	errThumbSize  = fmt.Errorf("invalid size: use a number of pixels from %d to %d", minThumbSize, maxThumbSize)
+)
+
+// Thumbnail sizes are bounded so a client cannot fill the cache with one
+// rendering per pixel count.
+const (
+	defaultThumbSize = 256
+	minThumbSize     = 16
+	maxThumbSize     = 1024
+)
+
 //go:embed static
 var staticFiles embed.FS
 
 type textStore interface {
-	Get(ctx context.Context) (clipboard.Content, error)
-	Set(ctx context.Context, content string) error
+	Get(ctx context.Context, name string) (clipboard.Content, error)
+	Read(ctx context.Context, name string) (clipboard.Content, error)
+	Set(ctx context.Context, name, content string, opts clipboard.SetOptions) (clipboard.Content, error)
+	CompareAndSet(ctx context.Context, name string, revision int64, content string, opts clipboard.SetOptions) (clipboard.Content, error)
+	SetIfExists(ctx context.Context, name, content string, opts clipboard.SetOptions) (clipboard.Content, error)
+	List(ctx context.Context) ([]clipboard.Content, error)
+	Delete(ctx context.Context, name string) error
+	History(ctx context.Context, name string) ([]clipboard.Content, error)
+	Revision(ctx context.Context, name string, id int64) (clipboard.Content, error)
+	Restore(ctx context.Context, name string, id int64) (clipboard.Content, error)
+	Pin(ctx context.Context, name string, pinned bool) (clipboard.Content, error)
+	SetFormat(ctx context.Context, name, mimeType string, r io.Reader) (clipboard.Content, error)
+	OpenFormat(ctx context.Context, name, mimeType string) (io.ReadCloser, error)
 }
 
 type fileStore interface {
-	Save(ctx context.Context, name string, r io.Reader, size int64) (filestore.Info, error)
+	Save(ctx context.Context, name string, r io.Reader, size int64, opts filestore.SaveOptions) (filestore.Info, error)
 	List(ctx context.Context) ([]filestore.Info, error)
-	FilePath(name string) (string, error)
+	Stat(ctx context.Context, name string) (filestore.Info, error)
+	Open(ctx context.Context, name string) (*os.File, filestore.Info, error)
 	Delete(ctx context.Context, name string) error
+	Pin(ctx context.Context, name string, pinned bool) (filestore.Info, error)
+	CreateUpload(ctx context.Context, name string, length int64, opts filestore.SaveOptions) (filestore.Upload, error)
+	Upload(ctx context.Context, id string) (filestore.Upload, error)
+	WriteUpload(ctx context.Context, id string, offset int64, r io.Reader) (filestore.Upload, error)
+	DeleteUpload(ctx context.Context, id string) error
+	HasContent(ctx context.Context, sha256 string) (bool, error)
+	Each(ctx context.Context, names []string, fn func(filestore.Info, io.Reader) error) error
+	Thumbnail(ctx context.Context, name string, size int) (filestore.Thumbnail, error)
+	Usage(ctx context.Context) (filestore.Usage, error)
+}
+
+type eventSource interface {
+	Subscribe(lastID uint64) ([]events.Event, <-chan events.Event, func())
+}
+
+type collabHub interface {
+	Join(ctx context.Context, name string) (*collab.Session, error)
+}
+
+type cleanupRunner interface {
+	Status() cleanup.Status
+	RunNow(ctx context.Context) cleanup.Report
+	DryRun(ctx context.Context) cleanup.Report
+}
+
+// Limits are the size limits the stores were configured with. The server
+// uses them to refuse oversized requests before reading them and to tell
+// clients what is allowed.
+type Limits struct {
+	MaxFileSize   int64 `json:"maxFileSize"`
+	MaxTextSize   int64 `json:"maxTextSize"`
+	MaxFormatSize int64 `json:"maxFormatSize"`
 }
 
 type Server struct {
-	text textStore
-	file fileStore
-	addr string
+	text      textStore
+	file      fileStore
+	events    eventSource
+	collab    collabHub
+	cleaner   cleanupRunner
+	limits    Limits
+	addr      string
+	heartbeat time.Duration
 }
 
-func NewServer(port string, text textStore, file fileStore) *Server {
+func NewServer(port string, text textStore, file fileStore, events eventSource, collab collabHub, cleaner cleanupRunner, limits Limits) *Server {
 	return &Server{
-		text: text,
-		file: file,
-		addr: net.JoinHostPort("", port),
+		text:      text,
+		file:      file,
+		events:    events,
+		collab:    collab,
+		cleaner:   cleaner,
+		limits:    limits,
+		addr:      net.JoinHostPort("", port),
+		heartbeat: 15 * time.Second,
 	}
 }
 
@@ -50,10 +133,45 @@ func (s *Server) Run(ctx context.Context) error {
 
 	mux.HandleFunc("GET /api/text", s.handleGetText)
 	mux.HandleFunc("PUT /api/text", s.handleSetText)
+	mux.HandleFunc("GET /api/text/history", s.handleListHistory)
+	mux.HandleFunc("GET /api/text/history/{id}", s.handleGetRevision)
+	mux.HandleFunc("POST /api/text/history/{id}/restore", s.handleRestoreRevision)
+	mux.HandleFunc("GET /api/text/ws", s.handleCollab)
+	mux.HandleFunc("GET /api/text/formats/{type...}", s.handleGetFormat)
+	mux.HandleFunc("PUT /api/text/formats/{type...}", s.handleSetFormat)
+	mux.HandleFunc("PUT /api/text/pin", s.handlePinClip)
+	mux.HandleFunc("DELETE /api/text/pin", s.handleUnpinClip)
+	mux.HandleFunc("GET /api/clips", s.handleListClips)
+	mux.HandleFunc("GET /api/clips/{name}", s.handleGetText)
+	mux.HandleFunc("PUT /api/clips/{name}", s.handleSetText)
+	mux.HandleFunc("DELETE /api/clips/{name}", s.handleDeleteClip)
+	mux.HandleFunc("GET /api/clips/{name}/history", s.handleListHistory)
+	mux.HandleFunc("GET /api/clips/{name}/history/{id}", s.handleGetRevision)
+	mux.HandleFunc("POST /api/clips/{name}/history/{id}/restore", s.handleRestoreRevision)
+	mux.HandleFunc("GET /api/clips/{name}/ws", s.handleCollab)
+	mux.HandleFunc("GET /api/clips/{name}/formats/{type...}", s.handleGetFormat)
+	mux.HandleFunc("PUT /api/clips/{name}/formats/{type...}", s.handleSetFormat)
+	mux.HandleFunc("PUT /api/clips/{name}/pin", s.handlePinClip)
+	mux.HandleFunc("DELETE /api/clips/{name}/pin", s.handleUnpinClip)
 	mux.HandleFunc("POST /api/files", s.handleUploadFile)
 	mux.HandleFunc("GET /api/files", s.handleListFiles)
+	mux.HandleFunc("GET /api/files.zip", s.handleDownloadZip)
 	mux.HandleFunc("GET /api/files/{filename}", s.handleDownloadFile)
+	mux.HandleFunc("HEAD /api/files/by-hash/{sha256}", s.handleHasContent)
 	mux.HandleFunc("DELETE /api/files/{filename}", s.handleDeleteFile)
+	mux.HandleFunc("GET /api/files/{filename}/{view}", s.handleThumbnail)
+	mux.HandleFunc("PUT /api/files/{filename}/pin", s.handlePinFile)
+	mux.HandleFunc("DELETE /api/files/{filename}/pin", s.handleUnpinFile)
+	mux.HandleFunc("GET /api/usage", s.handleUsage)
+	mux.HandleFunc("GET /api/limits", s.handleLimits)
+	mux.HandleFunc("GET /api/admin/cleanup", s.handleCleanupStatus)
+	mux.HandleFunc("POST /api/admin/cleanup", s.handleRunCleanup)
+	mux.HandleFunc("OPTIONS /api/uploads", s.handleUploadOptions)
+	mux.HandleFunc("POST /api/uploads", s.handleCreateUpload)
+	mux.HandleFunc("HEAD /api/uploads/{id}", s.handleUploadOffset)
+	mux.HandleFunc("PATCH /api/uploads/{id}", s.handlePatchUpload)
+	mux.HandleFunc("DELETE /api/uploads/{id}", s.handleDeleteUpload)
+	mux.HandleFunc("GET /api/events", s.handleEvents)
 
 	staticFS, err := fs.Sub(staticFiles, "static")
 	if err != nil {
@@ -65,6 +183,10 @@ func (s *Server) Run(ctx context.Context) error {
 		Addr:              s.addr,
 		Handler:           mux,
 		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			// Long-lived event streams end when the server is shutting down.
+			return ctx
+		},
 	}
 
 	go func() {
@@ -87,60 +209,310 @@ func (s *Server) Run(ctx context.Context) error {
 }
 
 func (s *Server) handleGetText(w http.ResponseWriter, r *http.Request) {
-	content, err := s.text.Get(r.Context())
-	if err != nil {
-		if errors.Is(err, clipboard.ErrEmpty) {
-			s.writeJSON(w, http.StatusOK, clipboard.Content{})
+	name := clipName(r)
+
+	content, err := s.text.Get(r.Context(), name)
+	if err != nil && !(errors.Is(err, clipboard.ErrEmpty) && name == clipboard.DefaultClip) {
+		s.writeClipError(w, r, err)
+		return
+	}
+
+	offers := []string{"application/json", clipboard.TypePlain}
+	for _, f := range content.Formats {
+		offers = append(offers, f.Type)
+	}
+
+	w.Header().Set("Vary", "Accept")
+
+	mimeType := negotiate(r.Header.Get("Accept"), offers)
+	if mimeType == "" {
+		s.writeError(w, http.StatusNotAcceptable, errors.New("no acceptable clip format"))
+		return
+	}
+
+	// A burn-after-reading clip is only used up once there is a
+	// representation to send it in, and never by HEAD.
+	if content.BurnAfterReading && r.Method != http.MethodHead {
+		content, err = s.text.Read(r.Context(), name)
+		if err != nil {
+			s.writeClipError(w, r, err)
 			return
 		}
-		s.writeError(w, http.StatusInternalServerError, err)
+	}
+
+	w.Header().Set("ETag", etag(content))
+	if content.BurnAfterReading {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Burn-After-Reading", "1")
+	}
+
+	switch mimeType {
+	case "application/json":
+		s.writeJSON(w, http.StatusOK, content)
+	case clipboard.TypePlain:
+		writeFormat(w, mimeType, strings.NewReader(content.Content))
+	default:
+		s.serveFormat(w, r, name, mimeType)
+	}
+}
+
+func (s *Server) handleGetFormat(w http.ResponseWriter, r *http.Request) {
+	s.serveFormat(w, r, clipName(r), r.PathValue("type"))
+}
+
+func (s *Server) handleSetFormat(w http.ResponseWriter, r *http.Request) {
+	content, err := s.text.SetFormat(r.Context(), clipName(r), r.PathValue("type"), r.Body)
+	if err != nil {
+		s.writeClipError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(content))
 	s.writeJSON(w, http.StatusOK, content)
 }
 
+func (s *Server) serveFormat(w http.ResponseWriter, r *http.Request, name, mimeType string) {
+	rc, err := s.text.OpenFormat(r.Context(), name, mimeType)
+	if err != nil {
+		s.writeClipError(w, r, err)
+		return
+	}
+	defer rc.Close()
+
+	writeFormat(w, mimeType, rc)
+}
+
 func (s *Server) handleSetText(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
+	ttl, err := parseTTL(r.URL.Query().Get("ttl"))
 	if err != nil {
 		s.writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	if err := s.text.Set(r.Context(), string(body)); err != nil {
+	burn, err := parseFlag(r.URL.Query().Get("burn"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.limits.MaxTextSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.writeClipError(w, r, clipboard.ErrTextTooLarge)
+			return
+		}
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	opts := clipboard.SetOptions{TTL: ttl, BurnAfterReading: burn}
+
+	var content clipboard.Content
+	switch match := r.Header.Get("If-Match"); match {
+	case "":
+		content, err = s.text.Set(r.Context(), clipName(r), string(body), opts)
+	case "*":
+		content, err = s.text.SetIfExists(r.Context(), clipName(r), string(body), opts)
+	default:
+		content, err = s.text.CompareAndSet(r.Context(), clipName(r), parseETag(match), string(body), opts)
+	}
+
+	if err != nil {
+		if errors.Is(err, clipboard.ErrConflict) {
+			w.Header().Set("ETag", etag(content))
+			s.writeJSON(w, http.StatusPreconditionFailed, content)
+			return
+		}
+		s.writeClipError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(content))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListClips(w http.ResponseWriter, r *http.Request) {
+	clips, err := s.text.List(r.Context())
+	if err != nil {
 		s.writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
+	if clips == nil {
+		clips = []clipboard.Content{}
+	}
+
+	s.writeJSON(w, http.StatusOK, clips)
+}
+
+func (s *Server) handleDeleteClip(w http.ResponseWriter, r *http.Request) {
+	if err := s.text.Delete(r.Context(), clipName(r)); err != nil {
+		s.writeClipError(w, r, err)
+		return
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (s *Server) handleUploadFile(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, 100*1024*1024+1024)
+func (s *Server) handlePinClip(w http.ResponseWriter, r *http.Request) {
+	s.pinClip(w, r, true)
+}
+
+func (s *Server) handleUnpinClip(w http.ResponseWriter, r *http.Request) {
+	s.pinClip(w, r, false)
+}
+
+func (s *Server) pinClip(w http.ResponseWriter, r *http.Request, pinned bool) {
+	content, err := s.text.Pin(r.Context(), clipName(r), pinned)
+	if err != nil {
+		s.writeClipError(w, r, err)
+		return
+	}
 
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
+	s.writeJSON(w, http.StatusOK, content)
+}
+
+func (s *Server) handleListHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := s.text.History(r.Context(), clipName(r))
+	if err != nil {
+		s.writeClipError(w, r, err)
+		return
+	}
+
+	if history == nil {
+		history = []clipboard.Content{}
+	}
+
+	s.writeJSON(w, http.StatusOK, history)
+}
+
+func (s *Server) handleGetRevision(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	content, err := s.text.Revision(r.Context(), clipName(r), id)
+	if err != nil {
+		s.writeClipError(w, r, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, content)
+}
+
+func (s *Server) handleRestoreRevision(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
 		s.writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	file, header, err := r.FormFile("file")
+	content, err := s.text.Restore(r.Context(), clipName(r), id)
+	if err != nil {
+		s.writeClipError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(content))
+	s.writeJSON(w, http.StatusOK, content)
+}
+
+func (s *Server) handleUploadFile(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseSaveOptions(r)
 	if err != nil {
 		s.writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	defer file.Close()
 
-	info, err := s.file.Save(r.Context(), header.Filename, file, header.Size)
+	// Parts are streamed straight into the store one after another; each
+	// one is size-limited by Save, so nothing is buffered here.
+	mr, err := r.MultipartReader()
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	saved := []filestore.Info{}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && len(saved) > 0 {
+			s.writePartialUpload(w, http.StatusBadRequest, saved, "", err)
+			return
+		}
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if part.FormName() != "file" || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		info, err := s.file.Save(r.Context(), part.FileName(), part, -1, opts)
+		part.Close()
+		if err != nil && len(saved) > 0 {
+			s.writePartialUpload(w, uploadStatus(err), saved, part.FileName(), err)
+			return
+		}
+		if err != nil {
+			s.writeUploadError(w, r, err)
+			return
+		}
+
+		saved = append(saved, info)
+	}
+
+	if len(saved) == 0 {
+		s.writeError(w, http.StatusBadRequest, errNoFile)
+		return
+	}
+
+	s.writeJSON(w, http.StatusCreated, saved)
+}
+
+// partialUpload is the body of a multipart upload that failed after some of
+// its files were stored. Those stay stored, so the client must not send
+// them again.
+type partialUpload struct {
+	Error  string           `json:"error"`
+	Failed string           `json:"failed,omitempty"`
+	Saved  []filestore.Info `json:"saved"`
+}
+
+// writePartialUpload reports a multipart upload that stopped at the part
+// named failed, or at a malformed part if failed is empty, after storing
+// the files in saved.
+func (s *Server) writePartialUpload(w http.ResponseWriter, status int, saved []filestore.Info, failed string, err error) {
+	slog.Error("request error", "status", status, "error", err, "saved", len(saved))
+	s.writeJSON(w, status, partialUpload{Error: err.Error(), Failed: failed, Saved: saved})
+}
+
+// handleHasContent lets a client find out whether uploading a file would
+// only store a duplicate.
+func (s *Server) handleHasContent(w http.ResponseWriter, r *http.Request) {
+	ok, err := s.file.HasContent(r.Context(), r.PathValue("sha256"))
 	if err != nil {
-		if errors.Is(err, filestore.ErrTooLarge) {
-			s.writeError(w, http.StatusRequestEntityTooLarge, err)
+		if errors.Is(err, filestore.ErrInvalidHash) {
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 		s.writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	s.writeJSON(w, http.StatusCreated, info)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
 
 func (s *Server) handleListFiles(w http.ResponseWriter, r *http.Request) {
@@ -157,36 +529,392 @@ func (s *Server) handleListFiles(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, http.StatusOK, files)
 }
 
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := s.file.Usage(r.Context())
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, usage)
+}
+
+func (s *Server) handleLimits(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.limits)
+}
+
+func (s *Server) handleCleanupStatus(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.cleaner.Status())
+}
+
+// handleRunCleanup runs a cleanup cycle and reports what it removed, or
+// with ?dryRun=1 what it would remove.
+func (s *Server) handleRunCleanup(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := parseFlag(r.URL.Query().Get("dryRun"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if dryRun {
+		s.writeJSON(w, http.StatusOK, s.cleaner.DryRun(r.Context()))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.cleaner.RunNow(r.Context()))
+}
+
 func (s *Server) handleDownloadFile(w http.ResponseWriter, r *http.Request) {
 	filename := r.PathValue("filename")
 
-	path, err := s.file.FilePath(filename)
+	inline, err := parseFlag(r.URL.Query().Get("inline"))
 	if err != nil {
-		if errors.Is(err, filestore.ErrNotFound) {
-			http.NotFound(w, r)
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		info, err := s.file.Stat(r.Context(), filename)
+		if err != nil {
+			s.writeFileError(w, r, err)
 			return
 		}
-		s.writeError(w, http.StatusInternalServerError, err)
+
+		setDisposition(w, info, inline)
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		w.Header().Set("Last-Modified", info.UploadedAt.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	f, info, err := s.file.Open(r.Context(), filename)
+	if err != nil {
+		s.writeFileError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	if info.BurnAfterReading {
+		// The file is already gone, so serve all of it in one go.
+		r.Header.Del("Range")
+		r.Header.Del("If-Modified-Since")
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	setDisposition(w, info, inline)
+	http.ServeContent(w, r, info.Name, info.UploadedAt, f)
+}
+
+// inlineCSP lets a file shown in the browser load nothing but itself, so an
+// upload can never run script against this origin.
+const inlineCSP = "default-src 'none'; img-src 'self'; media-src 'self'; object-src 'self'; style-src 'unsafe-inline'"
+
+// setDisposition makes a file download unless inline display was asked for
+// and its sniffed type is safe to show, in which case it is served as that
+// type rather than one guessed from the name.
+func setDisposition(w http.ResponseWriter, info filestore.Info, inline bool) {
+	disposition := "attachment"
+	if inline && inlineType(info.ContentType) {
+		disposition = "inline"
+		w.Header().Set("Content-Type", info.ContentType)
+		w.Header().Set("Content-Security-Policy", inlineCSP)
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", disposition+"; filename=\""+info.Name+"\"")
+}
+
+// inlineType reports whether a sniffed content type is one browsers display
+// without running anything: images, PDF, plain text, audio and video. SVG is
+// an image that can carry script, and is left out along with HTML.
+func inlineType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case mediaType == "image/svg+xml":
+		return false
+	case mediaType == "application/pdf", mediaType == "text/plain":
+		return true
+	default:
+		for _, prefix := range []string{"image/", "audio/", "video/"} {
+			if strings.HasPrefix(mediaType, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// handleDownloadZip streams all files, or the ones named by ?name=, as a
+// single archive. Nothing is buffered: each file is copied from its open
+// handle straight into the response.
+func (s *Server) handleDownloadZip(w http.ResponseWriter, r *http.Request) {
+	names := r.URL.Query()["name"]
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"homeclip.zip\"")
+
+	zw := zip.NewWriter(w)
+
+	err := s.file.Each(r.Context(), names, func(info filestore.Info, f io.Reader) error {
+		// Most shared files are photos and videos that do not compress,
+		// so entries are stored as-is.
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     info.Name,
+			Method:   zip.Store,
+			Modified: info.UploadedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(entry, f)
+		return err
+	})
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if err != nil {
+		slog.Error("zip download failed", "error", err)
+		// The status is long gone; cut the connection so the client sees a
+		// broken download instead of a truncated archive.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// handleThumbnail serves a preview of an image file. Files that are not
+// JPEG, PNG or GIF images have none.
+func (s *Server) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	// The route takes any last segment because a literal one would overlap
+	// with HEAD /api/files/by-hash/{sha256} without either being more
+	// specific.
+	if r.PathValue("view") != "thumbnail" {
+		http.NotFound(w, r)
+		return
+	}
+
+	size := defaultThumbSize
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minThumbSize || n > maxThumbSize {
+			s.writeError(w, http.StatusBadRequest, errThumbSize)
+			return
+		}
+		size = n
+	}
+
+	t, err := s.file.Thumbnail(r.Context(), r.PathValue("filename"), size)
+	if err != nil {
+		s.writeFileError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
-	http.ServeFile(w, r, path)
+	// Replacing the file changes its upload time, so revalidating is enough.
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", t.ContentType)
+	http.ServeContent(w, r, "", t.ModTime, bytes.NewReader(t.Data))
 }
 
 func (s *Server) handleDeleteFile(w http.ResponseWriter, r *http.Request) {
 	filename := r.PathValue("filename")
 
 	if err := s.file.Delete(r.Context(), filename); err != nil {
-		if errors.Is(err, filestore.ErrNotFound) {
-			http.NotFound(w, r)
+		s.writeFileError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handlePinFile(w http.ResponseWriter, r *http.Request) {
+	s.pinFile(w, r, true)
+}
+
+func (s *Server) handleUnpinFile(w http.ResponseWriter, r *http.Request) {
+	s.pinFile(w, r, false)
+}
+
+func (s *Server) pinFile(w http.ResponseWriter, r *http.Request, pinned bool) {
+	info, err := s.file.Pin(r.Context(), r.PathValue("filename"), pinned)
+	if err != nil {
+		s.writeFileError(w, r, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	replay, live, cancel := s.events.Subscribe(lastID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	io.WriteString(w, "retry: 3000\n\n")
+	for _, e := range replay {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-live:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *Server) handleCollab(w http.ResponseWriter, r *http.Request) {
+	session, err := s.collab.Join(r.Context(), clipName(r))
+	if err != nil {
+		s.writeClipError(w, r, err)
+		return
+	}
+	defer session.Leave()
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		slog.Error("websocket accept failed", "error", err)
+		return
+	}
+	defer conn.CloseNow()
+
+	conn.SetReadLimit(maxCollabMessage)
+
+	ctx := r.Context()
+
+	go func() {
+		for m := range session.Messages() {
+			if err := wsjson.Write(ctx, conn, m); err != nil {
+				return
+			}
+		}
+		conn.Close(websocket.StatusTryAgainLater, "session ended")
+	}()
+
+	for {
+		var m collab.Message
+		if err := wsjson.Read(ctx, conn, &m); err != nil {
 			return
 		}
+
+		if err := session.Submit(m.Revision, m.Op); err != nil {
+			status := websocket.StatusPolicyViolation
+			if errors.Is(err, clipboard.ErrTextTooLarge) {
+				status = websocket.StatusMessageTooBig
+			}
+			conn.Close(status, err.Error())
+			return
+		}
+	}
+}
+
+func (s *Server) writeClipError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, clipboard.ErrEmpty), errors.Is(err, clipboard.ErrRevisionNotFound),
+		errors.Is(err, clipboard.ErrFormatNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, clipboard.ErrInvalidName):
+		s.writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, clipboard.ErrUnsupportedFormat):
+		s.writeError(w, http.StatusUnsupportedMediaType, err)
+	case errors.Is(err, clipboard.ErrTextTooLarge):
+		s.writeTooLarge(w, err, "maxTextSize", s.limits.MaxTextSize)
+	case errors.Is(err, clipboard.ErrTooLarge):
+		s.writeTooLarge(w, err, "maxFormatSize", s.limits.MaxFormatSize)
+	case errors.Is(err, collab.ErrBurnAfterReading):
+		s.writeError(w, http.StatusConflict, err)
+	default:
 		s.writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func (s *Server) writeFileError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, filestore.ErrNotFound) || errors.Is(err, filestore.ErrNoThumbnail) {
+		http.NotFound(w, r)
 		return
 	}
+	s.writeError(w, http.StatusInternalServerError, err)
+}
+
+func (s *Server) writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	switch status := uploadStatus(err); status {
+	case http.StatusNotFound:
+		http.NotFound(w, r)
+	case http.StatusRequestEntityTooLarge:
+		s.writeTooLarge(w, err, "maxFileSize", s.limits.MaxFileSize)
+	default:
+		s.writeError(w, status, err)
+	}
+}
 
-	w.WriteHeader(http.StatusNoContent)
+func uploadStatus(err error) int {
+	switch {
+	case errors.Is(err, filestore.ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, filestore.ErrOffsetMismatch), errors.Is(err, filestore.ErrExists):
+		return http.StatusConflict
+	case errors.Is(err, filestore.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, filestore.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, filestore.ErrInvalidLength):
+		return http.StatusBadRequest
+	case errors.Is(err, filestore.ErrUploadBusy):
+		return http.StatusLocked
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// sizeLimitError is the body of a 413 response: which limit the request
+// broke and how many bytes it allows.
+type sizeLimitError struct {
+	Error    string `json:"error"`
+	Limit    string `json:"limit"`
+	MaxBytes int64  `json:"maxBytes"`
+}
+
+func (s *Server) writeTooLarge(w http.ResponseWriter, err error, limit string, maxBytes int64) {
+	slog.Error("request error", "status", http.StatusRequestEntityTooLarge, "error", err)
+	s.writeJSON(w, http.StatusRequestEntityTooLarge, sizeLimitError{
+		Error:    err.Error(),
+		Limit:    limit,
+		MaxBytes: maxBytes,
+	})
 }
 
 func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
@@ -202,3 +930,133 @@ func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
 	slog.Error("request error", "status", status, "error", err)
 	http.Error(w, err.Error(), status)
 }
+
+func clipName(r *http.Request) string {
+	if name := r.PathValue("name"); name != "" {
+		return name
+	}
+	return clipboard.DefaultClip
+}
+
+// writeFormat sends a raw clip payload. Clips are user-supplied, so HTML is
+// sandboxed and browsers are told not to second-guess the type.
+func writeFormat(w http.ResponseWriter, mimeType string, r io.Reader) {
+	if strings.HasPrefix(mimeType, "text/") {
+		mimeType += "; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, r); err != nil {
+		slog.Error("failed to write clip format", "error", err)
+	}
+}
+
+func writeEvent(w io.Writer, e events.Event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// parseTTL reads a ttl query parameter: a Go duration, a whole number of
+// days such as "7d", or "never", which is returned as a negative duration.
+// An empty value means the default expiry.
+func parseTTL(v string) (time.Duration, error) {
+	switch {
+	case v == "":
+		return 0, nil
+	case v == "never":
+		return -1, nil
+	case strings.HasSuffix(v, "d"):
+		days, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+		if err != nil || days <= 0 {
+			return 0, errInvalidTTL
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, errInvalidTTL
+	}
+
+	return d, nil
+}
+
+// parseSaveOptions reads the per-upload options shared by both upload
+// endpoints from the query string.
+func parseSaveOptions(r *http.Request) (filestore.SaveOptions, error) {
+	q := r.URL.Query()
+
+	ttl, err := parseTTL(q.Get("ttl"))
+	if err != nil {
+		return filestore.SaveOptions{}, err
+	}
+
+	burn, err := parseFlag(q.Get("burn"))
+	if err != nil {
+		return filestore.SaveOptions{}, err
+	}
+
+	conflict, err := filestore.ParseConflictPolicy(q.Get("conflict"))
+	if err != nil {
+		return filestore.SaveOptions{}, err
+	}
+
+	return filestore.SaveOptions{
+		TTL:              ttl,
+		BurnAfterReading: burn,
+		OnConflict:       conflict,
+		Uploader:         uploader(r),
+	}, nil
+}
+
+// uploader identifies the device behind a request. Clients can name
+// themselves with X-Device-Name; otherwise the user agent is used.
+func uploader(r *http.Request) filestore.Uploader {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	device := r.Header.Get("X-Device-Name")
+	if device == "" {
+		device = r.UserAgent()
+	}
+
+	return filestore.Uploader{IP: ip, Device: device}
+}
+
+func parseFlag(v string) (bool, error) {
+	if v == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+func etag(c clipboard.Content) string {
+	return strconv.Quote(strconv.FormatInt(c.Revision, 10))
+}
+
+// parseETag returns the revision encoded in an entity tag, or -1 if the tag
+// was not issued by etag and therefore can never match.
+func parseETag(tag string) int64 {
+	unquoted, err := strconv.Unquote(strings.TrimSpace(tag))
+	if err != nil {
+		return -1
+	}
+
+	rev, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil {
+		return -1
+	}
+
+	return rev
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -10,29 +12,142 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/d6o/homeclip/internal/cleanup"
 	"github.com/d6o/homeclip/internal/clipboard"
+	"github.com/d6o/homeclip/internal/collab"
+	"github.com/d6o/homeclip/internal/events"
 	"github.com/d6o/homeclip/internal/filestore"
 )
 
 // --- mocks ---
 
 type mockTextStore struct {
-	content clipboard.Content
-	err     error
-	setErr  error
-	last    string
+	content     clipboard.Content
+	err         error
+	setErr      error
+	last        string
+	lastName    string
+	clips       []clipboard.Content
+	listErr     error
+	delErr      error
+	deleted     string
+	history     []clipboard.Content
+	historyErr  error
+	revision    clipboard.Content
+	revErr      error
+	restoredID  int64
+	casRev      int64
+	casCalled   bool
+	setIfExists bool
+	formats     map[string]string
+	formatErr   error
+	setFormat   string
+	opts        clipboard.SetOptions
+	pinned      map[string]bool
+	reads       int
+}
+
+func (m *mockTextStore) Get(_ context.Context, name string) (clipboard.Content, error) {
+	m.lastName = name
+	c := m.content
+	if c.BurnAfterReading {
+		c.Content = ""
+		c.Formats = nil
+	}
+	return c, m.err
 }
 
-func (m *mockTextStore) Get(_ context.Context) (clipboard.Content, error) {
+func (m *mockTextStore) Read(_ context.Context, name string) (clipboard.Content, error) {
+	m.lastName = name
+	m.reads++
 	return m.content, m.err
 }
 
-func (m *mockTextStore) Set(_ context.Context, content string) error {
+func (m *mockTextStore) Set(_ context.Context, name, content string, opts clipboard.SetOptions) (clipboard.Content, error) {
+	m.lastName = name
 	m.last = content
-	return m.setErr
+	m.opts = opts
+	return clipboard.Content{Name: name, Revision: m.content.Revision + 1, Content: content}, m.setErr
+}
+
+func (m *mockTextStore) CompareAndSet(_ context.Context, name string, revision int64, content string, opts clipboard.SetOptions) (clipboard.Content, error) {
+	m.casCalled = true
+	m.casRev = revision
+	if revision != m.content.Revision {
+		return m.content, clipboard.ErrConflict
+	}
+	return m.Set(context.Background(), name, content, opts)
+}
+
+func (m *mockTextStore) SetIfExists(_ context.Context, name, content string, opts clipboard.SetOptions) (clipboard.Content, error) {
+	m.setIfExists = true
+	if m.err != nil || m.content.Revision == 0 {
+		return m.content, clipboard.ErrConflict
+	}
+	return m.Set(context.Background(), name, content, opts)
+}
+
+func (m *mockTextStore) CompareAndSetText(ctx context.Context, name string, revision int64, text string) (clipboard.Content, error) {
+	return m.CompareAndSet(ctx, name, revision, text, clipboard.SetOptions{})
+}
+
+func (m *mockTextStore) SetFormat(_ context.Context, _, mimeType string, r io.Reader) (clipboard.Content, error) {
+	data, _ := io.ReadAll(r)
+	m.setFormat = mimeType + ":" + string(data)
+	return clipboard.Content{Revision: m.content.Revision + 1}, m.formatErr
+}
+
+func (m *mockTextStore) OpenFormat(_ context.Context, _, mimeType string) (io.ReadCloser, error) {
+	if m.formatErr != nil {
+		return nil, m.formatErr
+	}
+	data, ok := m.formats[mimeType]
+	if !ok {
+		return nil, clipboard.ErrFormatNotFound
+	}
+	return io.NopCloser(strings.NewReader(data)), nil
+}
+
+func (m *mockTextStore) Pin(_ context.Context, name string, pinned bool) (clipboard.Content, error) {
+	if m.err != nil {
+		return clipboard.Content{}, m.err
+	}
+	if m.pinned == nil {
+		m.pinned = make(map[string]bool)
+	}
+	m.pinned[name] = pinned
+	return clipboard.Content{Name: name, Pinned: pinned}, nil
+}
+
+func (m *mockTextStore) List(_ context.Context) ([]clipboard.Content, error) {
+	return m.clips, m.listErr
+}
+
+func (m *mockTextStore) Delete(_ context.Context, name string) error {
+	m.deleted = name
+	return m.delErr
+}
+
+func (m *mockTextStore) History(_ context.Context, _ string) ([]clipboard.Content, error) {
+	return m.history, m.historyErr
+}
+
+func (m *mockTextStore) Revision(_ context.Context, _ string, _ int64) (clipboard.Content, error) {
+	return m.revision, m.revErr
+}
+
+func (m *mockTextStore) Restore(_ context.Context, _ string, id int64) (clipboard.Content, error) {
+	m.restoredID = id
+	return m.revision, m.revErr
 }
 
 type mockFileStore struct {
@@ -43,38 +158,238 @@ type mockFileStore struct {
 	path     string
 	pathErr  error
 	delErr   error
+	saveOpts filestore.SaveOptions
+	saved    []string
+	pinErr   error
+	pinned   map[string]bool
+	burn     bool
+	opened   int
+	mimeType string
+
+	hashes   map[string]bool
+	contents map[string]string
+	eachErr  error
+	eachArgs []string
+
+	upload     *filestore.Upload
+	uploadErr  error
+	saveErrs   map[string]error
+	uploadOpts filestore.SaveOptions
+	uploadData []byte
+
+	thumb     filestore.Thumbnail
+	thumbErr  error
+	thumbSize int
+
+	usage    filestore.Usage
+	usageErr error
+}
+
+func (m *mockFileStore) Save(_ context.Context, name string, r io.Reader, _ int64, opts filestore.SaveOptions) (filestore.Info, error) {
+	m.saveOpts = opts
+	if m.saveErr != nil {
+		return filestore.Info{}, m.saveErr
+	}
+	if err := m.saveErrs[name]; err != nil {
+		return filestore.Info{}, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return filestore.Info{}, err
+	}
+	m.saved = append(m.saved, name)
+	if m.saveInfo.Name != "" {
+		return m.saveInfo, nil
+	}
+	return filestore.Info{Name: name, Size: int64(len(data))}, nil
 }
 
-func (m *mockFileStore) Save(_ context.Context, name string, _ io.Reader, _ int64) (filestore.Info, error) {
-	return m.saveInfo, m.saveErr
+func (m *mockFileStore) Pin(_ context.Context, name string, pinned bool) (filestore.Info, error) {
+	if m.pinErr != nil {
+		return filestore.Info{}, m.pinErr
+	}
+	if m.pinned == nil {
+		m.pinned = make(map[string]bool)
+	}
+	m.pinned[name] = pinned
+	return filestore.Info{Name: name, Pinned: pinned}, nil
 }
 
 func (m *mockFileStore) List(_ context.Context) ([]filestore.Info, error) {
 	return m.files, m.listErr
 }
 
-func (m *mockFileStore) FilePath(_ string) (string, error) {
-	return m.path, m.pathErr
+func (m *mockFileStore) Stat(_ context.Context, name string) (filestore.Info, error) {
+	if m.pathErr != nil {
+		return filestore.Info{}, m.pathErr
+	}
+	return filestore.Info{Name: name, ContentType: m.mimeType, BurnAfterReading: m.burn}, nil
+}
+
+func (m *mockFileStore) Open(_ context.Context, name string) (*os.File, filestore.Info, error) {
+	if m.pathErr != nil {
+		return nil, filestore.Info{}, m.pathErr
+	}
+	m.opened++
+	f, err := os.Open(m.path)
+	return f, filestore.Info{Name: name, ContentType: m.mimeType, BurnAfterReading: m.burn}, err
 }
 
 func (m *mockFileStore) Delete(_ context.Context, _ string) error {
 	return m.delErr
 }
 
+func (m *mockFileStore) HasContent(_ context.Context, sha256 string) (bool, error) {
+	if len(sha256) != 64 {
+		return false, filestore.ErrInvalidHash
+	}
+	return m.hashes[sha256], nil
+}
+
+func (m *mockFileStore) Each(_ context.Context, names []string, fn func(filestore.Info, io.Reader) error) error {
+	m.eachArgs = names
+	if names == nil {
+		for name := range m.contents {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+	}
+	for _, name := range names {
+		content, ok := m.contents[name]
+		if !ok {
+			continue
+		}
+		if err := fn(filestore.Info{Name: name, UploadedAt: time.Now()}, strings.NewReader(content)); err != nil {
+			return err
+		}
+	}
+	return m.eachErr
+}
+
+func (m *mockFileStore) Thumbnail(_ context.Context, _ string, size int) (filestore.Thumbnail, error) {
+	m.thumbSize = size
+	return m.thumb, m.thumbErr
+}
+
+func (m *mockFileStore) Usage(_ context.Context) (filestore.Usage, error) {
+	return m.usage, m.usageErr
+}
+
+func (m *mockFileStore) CreateUpload(_ context.Context, name string, length int64, opts filestore.SaveOptions) (filestore.Upload, error) {
+	if m.uploadErr != nil {
+		return filestore.Upload{}, m.uploadErr
+	}
+	m.upload = &filestore.Upload{ID: "abc", Name: name, Length: length}
+	m.uploadOpts = opts
+	return *m.upload, nil
+}
+
+func (m *mockFileStore) Upload(_ context.Context, id string) (filestore.Upload, error) {
+	if m.upload == nil || m.upload.ID != id {
+		return filestore.Upload{}, filestore.ErrUploadNotFound
+	}
+	return *m.upload, nil
+}
+
+func (m *mockFileStore) WriteUpload(_ context.Context, id string, offset int64, r io.Reader) (filestore.Upload, error) {
+	if m.upload == nil || m.upload.ID != id {
+		return filestore.Upload{}, filestore.ErrUploadNotFound
+	}
+	if offset != m.upload.Offset {
+		return *m.upload, filestore.ErrOffsetMismatch
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return *m.upload, err
+	}
+	m.uploadData = append(m.uploadData, data...)
+	m.upload.Offset += int64(len(data))
+	return *m.upload, nil
+}
+
+func (m *mockFileStore) DeleteUpload(_ context.Context, id string) error {
+	if m.upload == nil || m.upload.ID != id {
+		return filestore.ErrUploadNotFound
+	}
+	m.upload = nil
+	return nil
+}
+
 // --- helpers ---
 
-func newTestServer(text textStore, file fileStore) *Server {
-	return NewServer("0", text, file)
+var testLimits = Limits{MaxFileSize: 100 << 20, MaxTextSize: 1 << 20, MaxFormatSize: 10 << 20}
+
+type mockCleaner struct {
+	status  cleanup.Status
+	report  cleanup.Report
+	runs    int
+	dryRuns int
+}
+
+func (m *mockCleaner) Status() cleanup.Status {
+	return m.status
+}
+
+func (m *mockCleaner) RunNow(context.Context) cleanup.Report {
+	m.runs++
+	return m.report
+}
+
+func (m *mockCleaner) DryRun(context.Context) cleanup.Report {
+	m.dryRuns++
+	r := m.report
+	r.DryRun = true
+	return r
+}
+
+func newTestServer(text *mockTextStore, file fileStore) *Server {
+	bus := events.NewBus(16)
+	return NewServer("0", text, file, bus, collab.NewHub(text, bus, time.Hour, testLimits.MaxTextSize), &mockCleaner{}, testLimits)
 }
 
 func setupMux(s *Server) http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /api/text", s.handleGetText)
 	mux.HandleFunc("PUT /api/text", s.handleSetText)
+	mux.HandleFunc("GET /api/text/history", s.handleListHistory)
+	mux.HandleFunc("GET /api/text/history/{id}", s.handleGetRevision)
+	mux.HandleFunc("POST /api/text/history/{id}/restore", s.handleRestoreRevision)
+	mux.HandleFunc("GET /api/text/ws", s.handleCollab)
+	mux.HandleFunc("GET /api/text/formats/{type...}", s.handleGetFormat)
+	mux.HandleFunc("PUT /api/text/formats/{type...}", s.handleSetFormat)
+	mux.HandleFunc("PUT /api/text/pin", s.handlePinClip)
+	mux.HandleFunc("DELETE /api/text/pin", s.handleUnpinClip)
+	mux.HandleFunc("GET /api/clips", s.handleListClips)
+	mux.HandleFunc("GET /api/clips/{name}", s.handleGetText)
+	mux.HandleFunc("PUT /api/clips/{name}", s.handleSetText)
+	mux.HandleFunc("DELETE /api/clips/{name}", s.handleDeleteClip)
+	mux.HandleFunc("GET /api/clips/{name}/history", s.handleListHistory)
+	mux.HandleFunc("GET /api/clips/{name}/history/{id}", s.handleGetRevision)
+	mux.HandleFunc("POST /api/clips/{name}/history/{id}/restore", s.handleRestoreRevision)
+	mux.HandleFunc("GET /api/clips/{name}/ws", s.handleCollab)
+	mux.HandleFunc("GET /api/clips/{name}/formats/{type...}", s.handleGetFormat)
+	mux.HandleFunc("PUT /api/clips/{name}/formats/{type...}", s.handleSetFormat)
+	mux.HandleFunc("PUT /api/clips/{name}/pin", s.handlePinClip)
+	mux.HandleFunc("DELETE /api/clips/{name}/pin", s.handleUnpinClip)
 	mux.HandleFunc("POST /api/files", s.handleUploadFile)
 	mux.HandleFunc("GET /api/files", s.handleListFiles)
+	mux.HandleFunc("GET /api/files.zip", s.handleDownloadZip)
 	mux.HandleFunc("GET /api/files/{filename}", s.handleDownloadFile)
+	mux.HandleFunc("HEAD /api/files/by-hash/{sha256}", s.handleHasContent)
 	mux.HandleFunc("DELETE /api/files/{filename}", s.handleDeleteFile)
+	mux.HandleFunc("GET /api/files/{filename}/{view}", s.handleThumbnail)
+	mux.HandleFunc("PUT /api/files/{filename}/pin", s.handlePinFile)
+	mux.HandleFunc("DELETE /api/files/{filename}/pin", s.handleUnpinFile)
+	mux.HandleFunc("GET /api/usage", s.handleUsage)
+	mux.HandleFunc("GET /api/limits", s.handleLimits)
+	mux.HandleFunc("GET /api/admin/cleanup", s.handleCleanupStatus)
+	mux.HandleFunc("POST /api/admin/cleanup", s.handleRunCleanup)
+	mux.HandleFunc("OPTIONS /api/uploads", s.handleUploadOptions)
+	mux.HandleFunc("POST /api/uploads", s.handleCreateUpload)
+	mux.HandleFunc("HEAD /api/uploads/{id}", s.handleUploadOffset)
+	mux.HandleFunc("PATCH /api/uploads/{id}", s.handlePatchUpload)
+	mux.HandleFunc("DELETE /api/uploads/{id}", s.handleDeleteUpload)
+	mux.HandleFunc("GET /api/events", s.handleEvents)
 	return mux
 }
 
@@ -158,157 +473,162 @@ func TestHandleSetText_Success(t *testing.T) {
 	if ts.last != "new clipboard content" {
 		t.Errorf("expected last set to %q, got %q", "new clipboard content", ts.last)
 	}
+	if ts.lastName != clipboard.DefaultClip {
+		t.Errorf("expected default clip, got %q", ts.lastName)
+	}
 }
 
-func TestHandleSetText_StoreError(t *testing.T) {
-	ts := &mockTextStore{setErr: errors.New("write error")}
+func TestHandleSetText_TTL(t *testing.T) {
+	ts := &mockTextStore{}
 	s := newTestServer(ts, &mockFileStore{})
 	mux := setupMux(s)
 
-	body := bytes.NewBufferString("data")
-	req := httptest.NewRequest(http.MethodPut, "/api/text", body)
+	req := httptest.NewRequest(http.MethodPut, "/api/text?ttl=10m", bytes.NewBufferString("secret"))
 	w := httptest.NewRecorder()
 	mux.ServeHTTP(w, req)
 
-	if w.Code != http.StatusInternalServerError {
-		t.Errorf("expected status 500, got %d", w.Code)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", w.Code)
+	}
+	if ts.opts.TTL != 10*time.Minute {
+		t.Errorf("expected ttl of 10m, got %v", ts.opts.TTL)
 	}
 }
 
-// --- GET /api/files ---
-
-func TestHandleListFiles_Empty(t *testing.T) {
-	fs := &mockFileStore{files: nil}
-	s := newTestServer(&mockTextStore{}, fs)
+func TestHandleSetText_InvalidTTL(t *testing.T) {
+	ts := &mockTextStore{}
+	s := newTestServer(ts, &mockFileStore{})
 	mux := setupMux(s)
 
-	req := httptest.NewRequest(http.MethodGet, "/api/files", nil)
+	req := httptest.NewRequest(http.MethodPut, "/api/text?ttl=-5m", bytes.NewBufferString("x"))
 	w := httptest.NewRecorder()
 	mux.ServeHTTP(w, req)
 
-	if w.Code != http.StatusOK {
-		t.Errorf("expected status 200, got %d", w.Code)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+	if ts.last != "" {
+		t.Error("expected nothing to be stored")
 	}
+}
 
-	var files []filestore.Info
-	if err := json.NewDecoder(w.Body).Decode(&files); err != nil {
-		t.Fatalf("failed to decode response: %v", err)
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"10m", 10 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"never", -1, false},
+		{"0s", 0, true},
+		{"-1h", 0, true},
+		{"0d", 0, true},
+		{"1.5d", 0, true},
+		{"soon", 0, true},
 	}
-	if len(files) != 0 {
-		t.Errorf("expected 0 files, got %d", len(files))
+
+	for _, tt := range tests {
+		got, err := parseTTL(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTTL(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseTTL(%q) = %v, want %v", tt.in, got, tt.want)
+		}
 	}
 }
 
-func TestHandleListFiles_WithFiles(t *testing.T) {
-	fs := &mockFileStore{
-		files: []filestore.Info{
-			{Name: "a.txt", Size: 100, UploadedAt: time.Now()},
-			{Name: "b.txt", Size: 200, UploadedAt: time.Now()},
-		},
-	}
-	s := newTestServer(&mockTextStore{}, fs)
+func TestHandleSetText_Burn(t *testing.T) {
+	ts := &mockTextStore{}
+	s := newTestServer(ts, &mockFileStore{})
 	mux := setupMux(s)
 
-	req := httptest.NewRequest(http.MethodGet, "/api/files", nil)
+	req := httptest.NewRequest(http.MethodPut, "/api/text?burn=true", bytes.NewBufferString("secret"))
 	w := httptest.NewRecorder()
 	mux.ServeHTTP(w, req)
 
-	if w.Code != http.StatusOK {
-		t.Errorf("expected status 200, got %d", w.Code)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", w.Code)
 	}
-
-	var files []filestore.Info
-	if err := json.NewDecoder(w.Body).Decode(&files); err != nil {
-		t.Fatalf("failed to decode response: %v", err)
+	if !ts.opts.BurnAfterReading {
+		t.Error("expected clip to be marked burn-after-reading")
 	}
-	if len(files) != 2 {
-		t.Errorf("expected 2 files, got %d", len(files))
+
+	req = httptest.NewRequest(http.MethodPut, "/api/text?burn=maybe", bytes.NewBufferString("secret"))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
 	}
 }
 
-func TestHandleListFiles_Error(t *testing.T) {
-	fs := &mockFileStore{listErr: errors.New("list error")}
-	s := newTestServer(&mockTextStore{}, fs)
+func TestHandleGetText_BurnAfterReading(t *testing.T) {
+	ts := &mockTextStore{content: clipboard.Content{Content: "secret", BurnAfterReading: true}}
+	s := newTestServer(ts, &mockFileStore{})
 	mux := setupMux(s)
 
-	req := httptest.NewRequest(http.MethodGet, "/api/files", nil)
+	req := httptest.NewRequest(http.MethodHead, "/api/text", nil)
 	w := httptest.NewRecorder()
 	mux.ServeHTTP(w, req)
 
-	if w.Code != http.StatusInternalServerError {
-		t.Errorf("expected status 500, got %d", w.Code)
+	if ts.reads != 0 {
+		t.Error("expected HEAD not to read the clip")
 	}
-}
-
-// --- POST /api/files ---
-
-func TestHandleUploadFile_Success(t *testing.T) {
-	info := filestore.Info{Name: "upload.txt", Size: 12, UploadedAt: time.Now()}
-	fs := &mockFileStore{saveInfo: info}
-	s := newTestServer(&mockTextStore{}, fs)
-	mux := setupMux(s)
-
-	var buf bytes.Buffer
-	mw := multipart.NewWriter(&buf)
-	fw, err := mw.CreateFormFile("file", "upload.txt")
-	if err != nil {
-		t.Fatalf("failed to create form file: %v", err)
+	if w.Header().Get("X-Burn-After-Reading") != "1" {
+		t.Error("expected HEAD to flag the clip as burn-after-reading")
 	}
-	fw.Write([]byte("file content"))
-	mw.Close()
 
-	req := httptest.NewRequest(http.MethodPost, "/api/files", &buf)
-	req.Header.Set("Content-Type", mw.FormDataContentType())
-	w := httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/text", nil)
+	w = httptest.NewRecorder()
 	mux.ServeHTTP(w, req)
 
-	if w.Code != http.StatusCreated {
-		t.Errorf("expected status 201, got %d", w.Code)
+	if ts.reads != 1 {
+		t.Errorf("expected GET to read the clip once, got %d", ts.reads)
 	}
 
-	var result filestore.Info
+	var result clipboard.Content
 	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
 		t.Fatalf("failed to decode response: %v", err)
 	}
-	if result.Name != "upload.txt" {
-		t.Errorf("expected name %q, got %q", "upload.txt", result.Name)
+	if result.Content != "secret" {
+		t.Errorf("expected secret content, got %q", result.Content)
 	}
 }
 
-func TestHandleUploadFile_TooLarge(t *testing.T) {
-	fs := &mockFileStore{saveErr: filestore.ErrTooLarge}
-	s := newTestServer(&mockTextStore{}, fs)
+func TestHandleGetText_BurnAfterReadingNotAcceptable(t *testing.T) {
+	ts := &mockTextStore{content: clipboard.Content{
+		Content:          "secret",
+		Formats:          []clipboard.Format{{Type: "image/png"}},
+		BurnAfterReading: true,
+	}}
+	s := newTestServer(ts, &mockFileStore{})
 	mux := setupMux(s)
 
-	var buf bytes.Buffer
-	mw := multipart.NewWriter(&buf)
-	fw, _ := mw.CreateFormFile("file", "big.bin")
-	fw.Write([]byte("data"))
-	mw.Close()
-
-	req := httptest.NewRequest(http.MethodPost, "/api/files", &buf)
-	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req := httptest.NewRequest(http.MethodGet, "/api/text", nil)
+	req.Header.Set("Accept", "image/png")
 	w := httptest.NewRecorder()
 	mux.ServeHTTP(w, req)
 
-	if w.Code != http.StatusRequestEntityTooLarge {
-		t.Errorf("expected status 413, got %d", w.Code)
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("expected 406, got %d", w.Code)
+	}
+	if ts.reads != 0 {
+		t.Error("expected the clip not to be used up by a request that cannot be served")
 	}
 }
 
-func TestHandleUploadFile_SaveError(t *testing.T) {
-	fs := &mockFileStore{saveErr: errors.New("save error")}
-	s := newTestServer(&mockTextStore{}, fs)
+func TestHandleSetText_StoreError(t *testing.T) {
+	ts := &mockTextStore{setErr: errors.New("write error")}
+	s := newTestServer(ts, &mockFileStore{})
 	mux := setupMux(s)
 
-	var buf bytes.Buffer
-	mw := multipart.NewWriter(&buf)
-	fw, _ := mw.CreateFormFile("file", "fail.txt")
-	fw.Write([]byte("data"))
-	mw.Close()
-
-	req := httptest.NewRequest(http.MethodPost, "/api/files", &buf)
-	req.Header.Set("Content-Type", mw.FormDataContentType())
+	body := bytes.NewBufferString("data")
+	req := httptest.NewRequest(http.MethodPut, "/api/text", body)
 	w := httptest.NewRecorder()
 	mux.ServeHTTP(w, req)
 
@@ -317,83 +637,1345 @@ func TestHandleUploadFile_SaveError(t *testing.T) {
 	}
 }
 
-func TestHandleUploadFile_NoFile(t *testing.T) {
-	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+func TestHandleSetText_TooLarge(t *testing.T) {
+	ts := &mockTextStore{}
+	s := newTestServer(ts, &mockFileStore{})
 	mux := setupMux(s)
 
-	req := httptest.NewRequest(http.MethodPost, "/api/files", bytes.NewBufferString("not multipart"))
-	req.Header.Set("Content-Type", "text/plain")
+	body := strings.NewReader(strings.Repeat("x", int(testLimits.MaxTextSize)+1))
+	req := httptest.NewRequest(http.MethodPut, "/api/text", body)
 	w := httptest.NewRecorder()
 	mux.ServeHTTP(w, req)
 
-	if w.Code != http.StatusBadRequest {
-		t.Errorf("expected status 400, got %d", w.Code)
+	assertTooLarge(t, w, "maxTextSize", testLimits.MaxTextSize)
+	if ts.last != "" {
+		t.Error("expected the text not to reach the store")
 	}
 }
 
-// --- DELETE /api/files/{filename} ---
-
-func TestHandleDeleteFile_Success(t *testing.T) {
-	fs := &mockFileStore{}
-	s := newTestServer(&mockTextStore{}, fs)
+func TestHandleSetFormat_TooLarge(t *testing.T) {
+	ts := &mockTextStore{formatErr: clipboard.ErrTooLarge}
+	s := newTestServer(ts, &mockFileStore{})
 	mux := setupMux(s)
 
-	req := httptest.NewRequest(http.MethodDelete, "/api/files/test.txt", nil)
+	req := httptest.NewRequest(http.MethodPut, "/api/text/formats/image/png", strings.NewReader("png"))
 	w := httptest.NewRecorder()
 	mux.ServeHTTP(w, req)
 
-	if w.Code != http.StatusNoContent {
-		t.Errorf("expected status 204, got %d", w.Code)
-	}
+	assertTooLarge(t, w, "maxFormatSize", testLimits.MaxFormatSize)
 }
 
-func TestHandleDeleteFile_NotFound(t *testing.T) {
-	fs := &mockFileStore{delErr: filestore.ErrNotFound}
-	s := newTestServer(&mockTextStore{}, fs)
-	mux := setupMux(s)
+// assertTooLarge checks for a 413 that names the limit that was broken.
+func assertTooLarge(t *testing.T, w *httptest.ResponseRecorder, limit string, maxBytes int64) {
+	t.Helper()
 
-	req := httptest.NewRequest(http.MethodDelete, "/api/files/ghost.txt", nil)
-	w := httptest.NewRecorder()
-	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status 413, got %d", w.Code)
+	}
 
-	if w.Code != http.StatusNotFound {
-		t.Errorf("expected status 404, got %d", w.Code)
+	var body sizeLimitError
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("expected a JSON body: %v", err)
+	}
+	if body.Limit != limit || body.MaxBytes != maxBytes || body.Error == "" {
+		t.Errorf("expected %s of %d bytes, got %+v", limit, maxBytes, body)
 	}
 }
 
-func TestHandleDeleteFile_Error(t *testing.T) {
-	fs := &mockFileStore{delErr: errors.New("delete error")}
-	s := newTestServer(&mockTextStore{}, fs)
+// --- /api/clips ---
+
+func TestHandleListClips(t *testing.T) {
+	ts := &mockTextStore{
+		clips: []clipboard.Content{
+			{Name: clipboard.DefaultClip, Content: "a"},
+			{Name: "work", Content: "b"},
+		},
+	}
+	s := newTestServer(ts, &mockFileStore{})
 	mux := setupMux(s)
 
-	req := httptest.NewRequest(http.MethodDelete, "/api/files/err.txt", nil)
+	req := httptest.NewRequest(http.MethodGet, "/api/clips", nil)
 	w := httptest.NewRecorder()
 	mux.ServeHTTP(w, req)
 
-	if w.Code != http.StatusInternalServerError {
-		t.Errorf("expected status 500, got %d", w.Code)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
 	}
-}
 
-// --- GET /api/files/{filename} ---
+	var clips []clipboard.Content
+	if err := json.NewDecoder(w.Body).Decode(&clips); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(clips) != 2 {
+		t.Errorf("expected 2 clips, got %d", len(clips))
+	}
+}
 
-func TestHandleDownloadFile_NotFound(t *testing.T) {
-	fs := &mockFileStore{pathErr: filestore.ErrNotFound}
-	s := newTestServer(&mockTextStore{}, fs)
+func TestHandleGetClip_NotFound(t *testing.T) {
+	ts := &mockTextStore{err: clipboard.ErrEmpty}
+	s := newTestServer(ts, &mockFileStore{})
 	mux := setupMux(s)
 
-	req := httptest.NewRequest(http.MethodGet, "/api/files/missing.txt", nil)
+	req := httptest.NewRequest(http.MethodGet, "/api/clips/work", nil)
 	w := httptest.NewRecorder()
 	mux.ServeHTTP(w, req)
 
 	if w.Code != http.StatusNotFound {
 		t.Errorf("expected status 404, got %d", w.Code)
 	}
+	if ts.lastName != "work" {
+		t.Errorf("expected clip %q, got %q", "work", ts.lastName)
+	}
 }
 
-func TestHandleDownloadFile_PathError(t *testing.T) {
-	fs := &mockFileStore{pathErr: errors.New("path error")}
-	s := newTestServer(&mockTextStore{}, fs)
+func TestHandleSetClip_Success(t *testing.T) {
+	ts := &mockTextStore{}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/clips/work", bytes.NewBufferString("notes"))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", w.Code)
+	}
+	if ts.lastName != "work" || ts.last != "notes" {
+		t.Errorf("expected clip work=notes, got %s=%s", ts.lastName, ts.last)
+	}
+}
+
+func TestHandleSetClip_InvalidName(t *testing.T) {
+	ts := &mockTextStore{setErr: clipboard.ErrInvalidName}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/clips/bad.name", bytes.NewBufferString("x"))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestHandleDeleteClip(t *testing.T) {
+	ts := &mockTextStore{}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/clips/work", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", w.Code)
+	}
+	if ts.deleted != "work" {
+		t.Errorf("expected clip %q deleted, got %q", "work", ts.deleted)
+	}
+}
+
+func TestHandleDeleteClip_NotFound(t *testing.T) {
+	ts := &mockTextStore{delErr: clipboard.ErrEmpty}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/clips/ghost", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+// --- GET /api/text/history ---
+
+func TestHandleListHistory_Empty(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/text/history", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+
+	var history []clipboard.Content
+	if err := json.NewDecoder(w.Body).Decode(&history); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if history == nil || len(history) != 0 {
+		t.Errorf("expected empty array, got %v", history)
+	}
+}
+
+func TestHandleListHistory_WithRevisions(t *testing.T) {
+	ts := &mockTextStore{
+		history: []clipboard.Content{
+			{Revision: 2, Content: "second", UpdatedAt: time.Now()},
+			{Revision: 1, Content: "first", UpdatedAt: time.Now()},
+		},
+	}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/text/history", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	var history []clipboard.Content
+	if err := json.NewDecoder(w.Body).Decode(&history); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(history) != 2 {
+		t.Errorf("expected 2 revisions, got %d", len(history))
+	}
+}
+
+func TestHandleListHistory_Error(t *testing.T) {
+	ts := &mockTextStore{historyErr: errors.New("read error")}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/text/history", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", w.Code)
+	}
+}
+
+// --- GET /api/text/history/{id} ---
+
+func TestHandleGetRevision_Success(t *testing.T) {
+	ts := &mockTextStore{revision: clipboard.Content{Revision: 3, Content: "old"}}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/text/history/3", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+
+	var c clipboard.Content
+	if err := json.NewDecoder(w.Body).Decode(&c); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if c.Content != "old" {
+		t.Errorf("expected content %q, got %q", "old", c.Content)
+	}
+}
+
+func TestHandleGetRevision_NotFound(t *testing.T) {
+	ts := &mockTextStore{revErr: clipboard.ErrRevisionNotFound}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/text/history/99", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestHandleGetRevision_InvalidID(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/text/history/abc", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+// --- POST /api/text/history/{id}/restore ---
+
+func TestHandleRestoreRevision_Success(t *testing.T) {
+	ts := &mockTextStore{revision: clipboard.Content{Revision: 5, Content: "restored"}}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/text/history/2/restore", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+	if ts.restoredID != 2 {
+		t.Errorf("expected restore of revision 2, got %d", ts.restoredID)
+	}
+}
+
+func TestHandleRestoreRevision_NotFound(t *testing.T) {
+	ts := &mockTextStore{revErr: clipboard.ErrRevisionNotFound}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/text/history/7/restore", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+// --- formats and content negotiation ---
+
+func TestHandleGetText_AcceptPlain(t *testing.T) {
+	ts := &mockTextStore{content: clipboard.Content{Content: "plain body"}}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/text", nil)
+	req.Header.Set("Accept", "text/plain")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	if w.Body.String() != "plain body" {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+}
+
+func TestHandleGetText_AcceptPNG(t *testing.T) {
+	ts := &mockTextStore{
+		content: clipboard.Content{Formats: []clipboard.Format{{Type: clipboard.TypePNG, Size: 3}}},
+		formats: map[string]string{clipboard.TypePNG: "png"},
+	}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/text", nil)
+	req.Header.Set("Accept", "image/png")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	if w.Body.String() != "png" {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+}
+
+func TestHandleGetText_NotAcceptable(t *testing.T) {
+	ts := &mockTextStore{content: clipboard.Content{Content: "x"}}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/text", nil)
+	req.Header.Set("Accept", "image/png")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("expected status 406, got %d", w.Code)
+	}
+}
+
+func TestHandleGetFormat_HTMLSandboxed(t *testing.T) {
+	ts := &mockTextStore{formats: map[string]string{clipboard.TypeHTML: "<script>alert(1)</script>"}}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/text/formats/text/html", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if csp := w.Header().Get("Content-Security-Policy"); csp != "sandbox" {
+		t.Errorf("expected sandbox CSP, got %q", csp)
+	}
+	if nosniff := w.Header().Get("X-Content-Type-Options"); nosniff != "nosniff" {
+		t.Errorf("expected nosniff, got %q", nosniff)
+	}
+}
+
+func TestHandleGetFormat_NotFound(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/clips/work/formats/image/png", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestHandleSetFormat_Success(t *testing.T) {
+	ts := &mockTextStore{}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/text/formats/text/html", bytes.NewBufferString("<b>hi</b>"))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+	if ts.setFormat != "text/html:<b>hi</b>" {
+		t.Errorf("unexpected stored format %q", ts.setFormat)
+	}
+}
+
+func TestHandleSetFormat_Unsupported(t *testing.T) {
+	ts := &mockTextStore{formatErr: clipboard.ErrUnsupportedFormat}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/text/formats/application/pdf", bytes.NewBufferString("x"))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status 415, got %d", w.Code)
+	}
+}
+
+// --- ETag / If-Match ---
+
+func TestHandleGetText_ETag(t *testing.T) {
+	ts := &mockTextStore{content: clipboard.Content{Revision: 7, Content: "x"}}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/text", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if got := w.Header().Get("ETag"); got != `"7"` {
+		t.Errorf("expected ETag %q, got %q", `"7"`, got)
+	}
+}
+
+func TestHandleSetText_IfMatchSuccess(t *testing.T) {
+	ts := &mockTextStore{content: clipboard.Content{Revision: 3}}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/text", bytes.NewBufferString("update"))
+	req.Header.Set("If-Match", `"3"`)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", w.Code)
+	}
+	if !ts.casCalled || ts.casRev != 3 {
+		t.Errorf("expected CompareAndSet with revision 3, got called=%v rev=%d", ts.casCalled, ts.casRev)
+	}
+	if got := w.Header().Get("ETag"); got != `"4"` {
+		t.Errorf("expected new ETag %q, got %q", `"4"`, got)
+	}
+}
+
+func TestHandleSetText_IfMatchStale(t *testing.T) {
+	ts := &mockTextStore{content: clipboard.Content{Revision: 5, Content: "from phone"}}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/text", bytes.NewBufferString("from laptop"))
+	req.Header.Set("If-Match", `"4"`)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected status 412, got %d", w.Code)
+	}
+
+	var c clipboard.Content
+	if err := json.NewDecoder(w.Body).Decode(&c); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if c.Content != "from phone" {
+		t.Errorf("expected current content in body, got %q", c.Content)
+	}
+	if got := w.Header().Get("ETag"); got != `"5"` {
+		t.Errorf("expected current ETag %q, got %q", `"5"`, got)
+	}
+}
+
+func TestHandleSetText_IfMatchMalformed(t *testing.T) {
+	ts := &mockTextStore{content: clipboard.Content{Revision: 1}}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/text", bytes.NewBufferString("x"))
+	req.Header.Set("If-Match", `W/"1"`)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status 412, got %d", w.Code)
+	}
+}
+
+func TestHandleSetText_IfMatchWildcard(t *testing.T) {
+	ts := &mockTextStore{content: clipboard.Content{Revision: 9}}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/text", bytes.NewBufferString("x"))
+	req.Header.Set("If-Match", "*")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", w.Code)
+	}
+	if !ts.setIfExists {
+		t.Error("expected SetIfExists for wildcard If-Match")
+	}
+	if ts.last != "x" {
+		t.Errorf("expected the clip to be written, got %q", ts.last)
+	}
+}
+
+func TestHandleSetText_IfMatchWildcardMissing(t *testing.T) {
+	tests := []struct {
+		name string
+		ts   *mockTextStore
+	}{
+		{"empty", &mockTextStore{err: clipboard.ErrEmpty}},
+		{"revision 0", &mockTextStore{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(tt.ts, &mockFileStore{})
+			mux := setupMux(s)
+
+			req := httptest.NewRequest(http.MethodPut, "/api/text", bytes.NewBufferString("x"))
+			req.Header.Set("If-Match", "*")
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != http.StatusPreconditionFailed {
+				t.Errorf("expected status 412, got %d", w.Code)
+			}
+			if tt.ts.last != "" {
+				t.Errorf("expected nothing written, got %q", tt.ts.last)
+			}
+		})
+	}
+}
+
+// --- GET /api/files ---
+
+func TestHandleListFiles_Empty(t *testing.T) {
+	fs := &mockFileStore{files: nil}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+
+	var files []filestore.Info
+	if err := json.NewDecoder(w.Body).Decode(&files); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected 0 files, got %d", len(files))
+	}
+}
+
+func TestHandleListFiles_WithFiles(t *testing.T) {
+	fs := &mockFileStore{
+		files: []filestore.Info{
+			{Name: "a.txt", Size: 100, UploadedAt: time.Now()},
+			{Name: "b.txt", Size: 200, UploadedAt: time.Now()},
+		},
+	}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+
+	var files []filestore.Info
+	if err := json.NewDecoder(w.Body).Decode(&files); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(files) != 2 {
+		t.Errorf("expected 2 files, got %d", len(files))
+	}
+}
+
+func TestHandleListFiles_Error(t *testing.T) {
+	fs := &mockFileStore{listErr: errors.New("list error")}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", w.Code)
+	}
+}
+
+// --- POST /api/files ---
+
+func TestHandleUploadFile_Success(t *testing.T) {
+	info := filestore.Info{Name: "upload.txt", Size: 12, UploadedAt: time.Now()}
+	fs := &mockFileStore{saveInfo: info}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("file", "upload.txt")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	fw.Write([]byte("file content"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("expected status 201, got %d", w.Code)
+	}
+
+	var result []filestore.Info
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(result) != 1 || result[0].Name != "upload.txt" {
+		t.Errorf("expected one file named %q, got %+v", "upload.txt", result)
+	}
+}
+
+func TestHandleUploadFile_MultipleFiles(t *testing.T) {
+	fs := &mockFileStore{}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		fw, _ := mw.CreateFormFile("file", name)
+		fw.Write([]byte("content of " + name))
+	}
+	mw.WriteField("note", "ignored")
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", w.Code)
+	}
+
+	var result []filestore.Info
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(result))
+	}
+	if result[0].Name != "a.txt" || result[1].Name != "b.txt" {
+		t.Errorf("expected a.txt and b.txt, got %q and %q", result[0].Name, result[1].Name)
+	}
+	if result[1].Size != int64(len("content of b.txt")) {
+		t.Errorf("expected size %d, got %d", len("content of b.txt"), result[1].Size)
+	}
+}
+
+func TestHandleUploadFile_NoFilePart(t *testing.T) {
+	fs := &mockFileStore{}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("file", "not a file")
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+	if len(fs.saved) != 0 {
+		t.Errorf("expected nothing saved, got %v", fs.saved)
+	}
+}
+
+func TestHandleUploadFile_TTL(t *testing.T) {
+	fs := &mockFileStore{saveInfo: filestore.Info{Name: "upload.txt"}}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("file", "upload.txt")
+	fw.Write([]byte("file content"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files?ttl=7d", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", w.Code)
+	}
+	if fs.saveOpts.TTL != 7*24*time.Hour {
+		t.Errorf("expected ttl of 7 days, got %v", fs.saveOpts.TTL)
+	}
+}
+
+func TestHandleUploadFile_ConflictPolicy(t *testing.T) {
+	fs := &mockFileStore{}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("file", "upload.txt")
+	fw.Write([]byte("file content"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files?conflict=reject", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", w.Code)
+	}
+	if fs.saveOpts.OnConflict != filestore.ConflictReject {
+		t.Errorf("expected reject policy, got %q", fs.saveOpts.OnConflict)
+	}
+}
+
+func TestHandleUploadFile_RecordsUploader(t *testing.T) {
+	fs := &mockFileStore{}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("file", "upload.txt")
+	fw.Write([]byte("file content"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-Device-Name", "kitchen-tablet")
+	req.RemoteAddr = "192.168.1.42:51234"
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	want := filestore.Uploader{IP: "192.168.1.42", Device: "kitchen-tablet"}
+	if fs.saveOpts.Uploader != want {
+		t.Errorf("expected uploader %+v, got %+v", want, fs.saveOpts.Uploader)
+	}
+}
+
+func TestHandleUploadFile_InvalidConflictPolicy(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files?conflict=merge", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestHandleUploadFile_Exists(t *testing.T) {
+	fs := &mockFileStore{saveErr: filestore.ErrExists}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("file", "taken.txt")
+	fw.Write([]byte("data"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files?conflict=reject", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", w.Code)
+	}
+}
+
+func TestHandleUploadFile_PartlyStored(t *testing.T) {
+	fs := &mockFileStore{saveErrs: map[string]error{"b.txt": filestore.ErrExists}}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		fw, _ := mw.CreateFormFile("file", name)
+		fw.Write([]byte("content of " + name))
+	}
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files?conflict=reject", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", w.Code)
+	}
+
+	var result partialUpload
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if result.Failed != "b.txt" || result.Error != filestore.ErrExists.Error() {
+		t.Errorf("expected b.txt to fail as taken, got %+v", result)
+	}
+	if len(result.Saved) != 1 || result.Saved[0].Name != "a.txt" {
+		t.Errorf("expected a.txt reported as saved, got %+v", result.Saved)
+	}
+	if len(fs.saved) != 1 {
+		t.Errorf("expected the parts after the failure not to be stored, got %v", fs.saved)
+	}
+}
+
+func TestHandleUploadFile_InvalidTTL(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files?ttl=soon", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestHandleUploadFile_TooLarge(t *testing.T) {
+	fs := &mockFileStore{saveErr: filestore.ErrTooLarge}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("file", "big.bin")
+	fw.Write([]byte("data"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	assertTooLarge(t, w, "maxFileSize", testLimits.MaxFileSize)
+}
+
+func TestHandleUploadFile_QuotaExceeded(t *testing.T) {
+	fs := &mockFileStore{saveErr: filestore.ErrQuotaExceeded}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("file", "big.bin")
+	fw.Write([]byte("data"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("expected status 507, got %d", w.Code)
+	}
+}
+
+func TestHandleUploadFile_SaveError(t *testing.T) {
+	fs := &mockFileStore{saveErr: errors.New("save error")}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("file", "fail.txt")
+	fw.Write([]byte("data"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", w.Code)
+	}
+}
+
+func TestHandleUploadFile_NoFile(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files", bytes.NewBufferString("not multipart"))
+	req.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+// --- GET /api/files.zip ---
+
+func readZip(t *testing.T, body []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+	return files
+}
+
+func TestHandleDownloadZip_All(t *testing.T) {
+	fs := &mockFileStore{contents: map[string]string{"a.txt": "alpha", "b.jpg": "bravo"}}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files.zip", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("expected application/zip, got %q", ct)
+	}
+	if fs.eachArgs != nil {
+		t.Errorf("expected all files to be requested, got %v", fs.eachArgs)
+	}
+
+	files := readZip(t, w.Body.Bytes())
+	if len(files) != 2 || files["a.txt"] != "alpha" || files["b.jpg"] != "bravo" {
+		t.Errorf("unexpected archive contents: %v", files)
+	}
+}
+
+func TestHandleDownloadZip_Subset(t *testing.T) {
+	fs := &mockFileStore{contents: map[string]string{"a.txt": "alpha", "b.jpg": "bravo", "c.pdf": "charlie"}}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files.zip?name=a.txt&name=c.pdf", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	files := readZip(t, w.Body.Bytes())
+	if len(files) != 2 || files["a.txt"] != "alpha" || files["c.pdf"] != "charlie" {
+		t.Errorf("unexpected archive contents: %v", files)
+	}
+}
+
+func TestHandleDownloadZip_FailureAbortsStream(t *testing.T) {
+	fs := &mockFileStore{contents: map[string]string{"a.txt": "alpha"}, eachErr: errors.New("disk error")}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files.zip", nil)
+	w := httptest.NewRecorder()
+
+	defer func() {
+		if recover() != http.ErrAbortHandler {
+			t.Error("expected the handler to abort the response")
+		}
+	}()
+	mux.ServeHTTP(w, req)
+}
+
+// --- HEAD /api/files/by-hash/{sha256} ---
+
+func TestHandleHasContent(t *testing.T) {
+	known := strings.Repeat("a", 64)
+	fs := &mockFileStore{hashes: map[string]bool{known: true}}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	tests := []struct {
+		hash   string
+		status int
+	}{
+		{known, http.StatusOK},
+		{strings.Repeat("b", 64), http.StatusNotFound},
+		{"abc", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodHead, "/api/files/by-hash/"+tt.hash, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.hash, tt.status, w.Code)
+		}
+	}
+}
+
+// --- GET /api/limits ---
+
+func TestHandleLimits(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/limits", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	var limits Limits
+	if err := json.NewDecoder(w.Body).Decode(&limits); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if limits != testLimits {
+		t.Errorf("expected %+v, got %+v", testLimits, limits)
+	}
+}
+
+// --- /api/admin/cleanup ---
+
+func TestHandleCleanupStatus(t *testing.T) {
+	next := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	m := &mockCleaner{status: cleanup.Status{
+		LastRun: &cleanup.Report{
+			StartedAt: next.Add(-10 * time.Minute),
+			Removed:   []cleanup.Expired{{Kind: "file", Name: "old.txt"}},
+			Failures:  []cleanup.Failure{{Kind: "text", Error: "disk error"}},
+		},
+		NextRun:      &next,
+		TotalRemoved: 4,
+	}}
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	s.cleaner = m
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/cleanup", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var status cleanup.Status
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if !reflect.DeepEqual(status, m.status) {
+		t.Errorf("expected %+v, got %+v", m.status, status)
+	}
+}
+
+func TestHandleRunCleanup(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantDryRun bool
+	}{
+		{"", false},
+		{"?dryRun=0", false},
+		{"?dryRun=1", true},
+		{"?dryRun=true", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			m := &mockCleaner{report: cleanup.Report{Removed: []cleanup.Expired{{Kind: "file", Name: "old.txt"}}}}
+			s := newTestServer(&mockTextStore{}, &mockFileStore{})
+			s.cleaner = m
+			mux := setupMux(s)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/admin/cleanup"+tt.query, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", w.Code)
+			}
+
+			var report cleanup.Report
+			if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+			if report.DryRun != tt.wantDryRun || len(report.Removed) != 1 {
+				t.Errorf("unexpected report %+v", report)
+			}
+
+			wantRuns, wantDryRuns := 1, 0
+			if tt.wantDryRun {
+				wantRuns, wantDryRuns = 0, 1
+			}
+			if m.runs != wantRuns || m.dryRuns != wantDryRuns {
+				t.Errorf("expected %d runs and %d dry runs, got %d and %d", wantRuns, wantDryRuns, m.runs, m.dryRuns)
+			}
+		})
+	}
+}
+
+func TestHandleRunCleanup_InvalidDryRun(t *testing.T) {
+	m := &mockCleaner{}
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	s.cleaner = m
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/cleanup?dryRun=maybe", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+	if m.runs != 0 {
+		t.Error("expected no cleanup to run")
+	}
+}
+
+// --- GET /api/usage ---
+
+func TestHandleUsage(t *testing.T) {
+	fs := &mockFileStore{usage: filestore.Usage{Files: 3, Bytes: 1024, MaxBytes: 4096}}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/usage", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var usage filestore.Usage
+	if err := json.NewDecoder(w.Body).Decode(&usage); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if usage != fs.usage {
+		t.Errorf("expected %+v, got %+v", fs.usage, usage)
+	}
+}
+
+func TestHandleUsage_Error(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{usageErr: errors.New("disk error")})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/usage", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", w.Code)
+	}
+}
+
+// --- GET /api/files/{filename}/thumbnail ---
+
+func TestHandleThumbnail(t *testing.T) {
+	fs := &mockFileStore{thumb: filestore.Thumbnail{
+		Data:        []byte("jpeg data"),
+		ContentType: "image/jpeg",
+		ModTime:     time.Now(),
+	}}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files/photo.jpg/thumbnail", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("expected image/jpeg, got %s", ct)
+	}
+	if w.Body.String() != "jpeg data" {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+	if fs.thumbSize != 256 {
+		t.Errorf("expected default size 256, got %d", fs.thumbSize)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/files/photo.jpg/thumbnail?size=64", nil)
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	if fs.thumbSize != 64 {
+		t.Errorf("expected size 64, got %d", fs.thumbSize)
+	}
+}
+
+func TestHandleThumbnail_InvalidSize(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	mux := setupMux(s)
+
+	for _, size := range []string{"big", "0", "4096"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/files/photo.jpg/thumbnail?size="+size, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("size=%s: expected 400, got %d", size, w.Code)
+		}
+	}
+}
+
+func TestHandleThumbnail_NotFound(t *testing.T) {
+	for _, err := range []error{filestore.ErrNotFound, filestore.ErrNoThumbnail} {
+		s := newTestServer(&mockTextStore{}, &mockFileStore{thumbErr: err})
+		mux := setupMux(s)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/files/notes.txt/thumbnail", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%v: expected 404, got %d", err, w.Code)
+		}
+	}
+
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	req := httptest.NewRequest(http.MethodGet, "/api/files/photo.jpg/preview", nil)
+	w := httptest.NewRecorder()
+	setupMux(s).ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown view, got %d", w.Code)
+	}
+}
+
+// --- DELETE /api/files/{filename} ---
+
+func TestHandleDeleteFile_Success(t *testing.T) {
+	fs := &mockFileStore{}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/files/test.txt", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", w.Code)
+	}
+}
+
+func TestHandleDeleteFile_NotFound(t *testing.T) {
+	fs := &mockFileStore{delErr: filestore.ErrNotFound}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/files/ghost.txt", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestHandleDeleteFile_Error(t *testing.T) {
+	fs := &mockFileStore{delErr: errors.New("delete error")}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/files/err.txt", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", w.Code)
+	}
+}
+
+// --- GET /api/files/{filename} ---
+
+func TestHandleDownloadFile_NotFound(t *testing.T) {
+	fs := &mockFileStore{pathErr: filestore.ErrNotFound}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files/missing.txt", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestHandleDownloadFile_PathError(t *testing.T) {
+	fs := &mockFileStore{pathErr: errors.New("path error")}
+	s := newTestServer(&mockTextStore{}, fs)
 	mux := setupMux(s)
 
 	req := httptest.NewRequest(http.MethodGet, "/api/files/err.txt", nil)
@@ -429,10 +2011,329 @@ func TestHandleDownloadFile_Success(t *testing.T) {
 	}
 }
 
+func TestHandleDownloadFile_Inline(t *testing.T) {
+	dir := t.TempDir()
+	tmpFile := dir + "/upload"
+	if err := writeFile(tmpFile, "file body"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mimeType    string
+		disposition string
+	}{
+		{"application/pdf", "inline"},
+		{"text/plain; charset=utf-8", "inline"},
+		{"image/png", "inline"},
+		{"video/mp4", "inline"},
+		{"text/html; charset=utf-8", "attachment"},
+		{"image/svg+xml", "attachment"},
+		{"application/octet-stream", "attachment"},
+		{"", "attachment"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mimeType, func(t *testing.T) {
+			fs := &mockFileStore{path: tmpFile, mimeType: tt.mimeType}
+			s := newTestServer(&mockTextStore{}, fs)
+			mux := setupMux(s)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/files/page.html?inline=1", nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", w.Code)
+			}
+			if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, tt.disposition+";") {
+				t.Errorf("expected %s disposition, got %q", tt.disposition, cd)
+			}
+			if v := w.Header().Get("X-Content-Type-Options"); v != "nosniff" {
+				t.Errorf("expected nosniff, got %q", v)
+			}
+
+			csp := w.Header().Get("Content-Security-Policy")
+			if tt.disposition == "inline" {
+				if ct := w.Header().Get("Content-Type"); ct != tt.mimeType {
+					t.Errorf("expected sniffed type %q, got %q", tt.mimeType, ct)
+				}
+				if !strings.Contains(csp, "default-src 'none'") {
+					t.Errorf("expected a strict CSP, got %q", csp)
+				}
+			} else if csp != "" {
+				t.Errorf("expected no CSP on a download, got %q", csp)
+			}
+		})
+	}
+}
+
+func TestHandleDownloadFile_InvalidInline(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files/hello.txt?inline=maybe", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestHandleDownloadFile_BurnAfterReading(t *testing.T) {
+	dir := t.TempDir()
+	tmpFile := dir + "/secret.txt"
+	if err := writeFile(tmpFile, "top secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &mockFileStore{path: tmpFile, burn: true}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files/secret.txt", nil)
+	req.Header.Set("Range", "bytes=0-2")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if w.Body.String() != "top secret" {
+		t.Errorf("expected the whole file, got %q", w.Body.String())
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("expected Cache-Control no-store, got %q", cc)
+	}
+}
+
+func TestHandleDownloadFile_HeadDoesNotOpen(t *testing.T) {
+	fs := &mockFileStore{burn: true}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodHead, "/api/files/secret.txt", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if fs.opened != 0 {
+		t.Error("expected HEAD not to open the file")
+	}
+}
+
+func TestHandleUploadFile_Burn(t *testing.T) {
+	fs := &mockFileStore{saveInfo: filestore.Info{Name: "secret.txt"}}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("file", "secret.txt")
+	fw.Write([]byte("secret"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files?burn=1", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", w.Code)
+	}
+	if !fs.saveOpts.BurnAfterReading {
+		t.Error("expected upload to be marked burn-after-reading")
+	}
+}
+
+// --- GET /api/events ---
+
+func readEvent(t *testing.T, r *bufio.Reader) map[string]string {
+	t.Helper()
+	fields := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		if line == "" {
+			if _, ok := fields["event"]; ok {
+				return fields
+			}
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ": "); ok {
+			fields[k] = v
+		}
+	}
+}
+
+func TestHandleEvents_StreamsPublishedEvents(t *testing.T) {
+	bus := events.NewBus(16)
+	s := NewServer("0", &mockTextStore{}, &mockFileStore{}, bus, collab.NewHub(&mockTextStore{}, bus, time.Hour, testLimits.MaxTextSize), &mockCleaner{}, testLimits)
+	srv := httptest.NewServer(setupMux(s))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/api/events")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected text/event-stream, got %q", ct)
+	}
+
+	bus.Publish(events.FileCreated, filestore.Info{Name: "a.txt"})
+
+	e := readEvent(t, bufio.NewReader(res.Body))
+	if e["event"] != string(events.FileCreated) {
+		t.Errorf("expected event %q, got %q", events.FileCreated, e["event"])
+	}
+	if e["id"] != "1" {
+		t.Errorf("expected id 1, got %q", e["id"])
+	}
+	if !strings.Contains(e["data"], `"name":"a.txt"`) {
+		t.Errorf("unexpected data: %s", e["data"])
+	}
+}
+
+func TestHandleEvents_ResumesFromLastEventID(t *testing.T) {
+	bus := events.NewBus(16)
+	bus.Publish(events.FileCreated, filestore.Info{Name: "a.txt"})
+	bus.Publish(events.FileDeleted, filestore.Info{Name: "a.txt"})
+
+	s := NewServer("0", &mockTextStore{}, &mockFileStore{}, bus, collab.NewHub(&mockTextStore{}, bus, time.Hour, testLimits.MaxTextSize), &mockCleaner{}, testLimits)
+	srv := httptest.NewServer(setupMux(s))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	e := readEvent(t, bufio.NewReader(res.Body))
+	if e["id"] != "2" || e["event"] != string(events.FileDeleted) {
+		t.Errorf("expected replay of event 2, got %v", e)
+	}
+}
+
+func TestHandleEvents_Heartbeat(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	s.heartbeat = 10 * time.Millisecond
+	srv := httptest.NewServer(setupMux(s))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/api/events")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	r := bufio.NewReader(res.Body)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event stream: %v", err)
+		}
+		if strings.HasPrefix(line, ": heartbeat") {
+			return
+		}
+	}
+}
+
+// --- GET /api/text/ws ---
+
+func TestHandleCollab_EditRoundTrip(t *testing.T) {
+	ts := &mockTextStore{content: clipboard.Content{Revision: 1, Content: "abc"}}
+	s := newTestServer(ts, &mockFileStore{})
+	srv := httptest.NewServer(setupMux(s))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/api/text/ws", nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.CloseNow()
+
+	var init collab.Message
+	if err := wsjson.Read(ctx, conn, &init); err != nil {
+		t.Fatalf("failed to read init: %v", err)
+	}
+	if init.Type != "init" || init.Content != "abc" {
+		t.Errorf("unexpected init message: %+v", init)
+	}
+
+	if err := conn.Write(ctx, websocket.MessageText, []byte(`{"revision":0,"op":[3,"d"]}`)); err != nil {
+		t.Fatalf("failed to write op: %v", err)
+	}
+
+	var ack collab.Message
+	if err := wsjson.Read(ctx, conn, &ack); err != nil {
+		t.Fatalf("failed to read ack: %v", err)
+	}
+	if ack.Type != "ack" || ack.Revision != 1 {
+		t.Errorf("unexpected ack: %+v", ack)
+	}
+}
+
+func TestHandleCollab_InvalidOpClosesConnection(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	srv := httptest.NewServer(setupMux(s))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/api/text/ws", nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.CloseNow()
+
+	var init collab.Message
+	if err := wsjson.Read(ctx, conn, &init); err != nil {
+		t.Fatalf("failed to read init: %v", err)
+	}
+
+	if err := conn.Write(ctx, websocket.MessageText, []byte(`{"revision":0,"op":[10]}`)); err != nil {
+		t.Fatalf("failed to write op: %v", err)
+	}
+
+	_, _, err = conn.Read(ctx)
+	if websocket.CloseStatus(err) != websocket.StatusPolicyViolation {
+		t.Errorf("expected policy violation close, got %v", err)
+	}
+}
+
+func TestHandleCollab_InvalidName(t *testing.T) {
+	ts := &mockTextStore{err: clipboard.ErrInvalidName}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/clips/bad.name/ws", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
 // --- NewServer ---
 
 func TestNewServer(t *testing.T) {
-	s := NewServer("8080", &mockTextStore{}, &mockFileStore{})
+	bus := events.NewBus(16)
+	s := NewServer("8080", &mockTextStore{}, &mockFileStore{}, bus, collab.NewHub(&mockTextStore{}, bus, time.Hour, testLimits.MaxTextSize), &mockCleaner{}, testLimits)
 	if s.addr != ":8080" {
 		t.Errorf("expected addr %q, got %q", ":8080", s.addr)
 	}
@@ -442,3 +2343,101 @@ func TestNewServer(t *testing.T) {
 func writeFile(path, content string) error {
 	return os.WriteFile(path, []byte(content), 0o644)
 }
+
+// --- pinning ---
+
+func TestHandlePinClip(t *testing.T) {
+	ts := &mockTextStore{}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/clips/wifi/pin", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if !ts.pinned["wifi"] {
+		t.Error("expected wifi clip to be pinned")
+	}
+
+	var result clipboard.Content
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !result.Pinned {
+		t.Error("expected response to report pinned")
+	}
+}
+
+func TestHandleUnpinClip_Default(t *testing.T) {
+	ts := &mockTextStore{}
+	s := newTestServer(ts, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/text/pin", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if pinned, ok := ts.pinned[clipboard.DefaultClip]; !ok || pinned {
+		t.Error("expected default clip to be unpinned")
+	}
+}
+
+func TestHandlePinClip_NotFound(t *testing.T) {
+	s := newTestServer(&mockTextStore{err: clipboard.ErrEmpty}, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/clips/missing/pin", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestHandlePinFile(t *testing.T) {
+	fs := &mockFileStore{}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/files/driver.zip/pin", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if !fs.pinned["driver.zip"] {
+		t.Error("expected driver.zip to be pinned")
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/files/driver.zip/pin", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if fs.pinned["driver.zip"] {
+		t.Error("expected driver.zip to be unpinned")
+	}
+}
+
+func TestHandlePinFile_NotFound(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{pinErr: filestore.ErrNotFound})
+	mux := setupMux(s)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/files/missing.txt/pin", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Resumable uploads follow tus 1.0 (https://tus.io/protocols/resumable-upload)
+// with the creation and termination extensions.
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,termination"
+	tusContentType = "application/offset+octet-stream"
+)
+
+var (
+	errTusVersion    = errors.New("unsupported tus version")
+	errUploadLength  = errors.New("missing or invalid Upload-Length")
+	errUploadOffset  = errors.New("missing or invalid Upload-Offset")
+	errUploadName    = errors.New("upload metadata must include a filename")
+	errPatchEncoding = errors.New("PATCH requests must use " + tusContentType)
+)
+
+func (s *Server) handleUploadOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(s.limits.MaxFileSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	if !s.checkTus(w, r) {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		s.writeError(w, http.StatusBadRequest, errUploadLength)
+		return
+	}
+
+	meta := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	name := meta["filename"]
+	if name == "" {
+		name = meta["name"]
+	}
+	if name == "" {
+		s.writeError(w, http.StatusBadRequest, errUploadName)
+		return
+	}
+
+	opts, err := parseSaveOptions(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	u, err := s.file.CreateUpload(r.Context(), name, length, opts)
+	if err != nil {
+		s.writeUploadError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/uploads/"+u.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) handleUploadOffset(w http.ResponseWriter, r *http.Request) {
+	if !s.checkTus(w, r) {
+		return
+	}
+
+	u, err := s.file.Upload(r.Context(), r.PathValue("id"))
+	if err != nil {
+		s.writeUploadError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handlePatchUpload(w http.ResponseWriter, r *http.Request) {
+	if !s.checkTus(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != tusContentType {
+		s.writeError(w, http.StatusUnsupportedMediaType, errPatchEncoding)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		s.writeError(w, http.StatusBadRequest, errUploadOffset)
+		return
+	}
+
+	u, err := s.file.WriteUpload(r.Context(), r.PathValue("id"), offset, r.Body)
+	if err != nil {
+		s.writeUploadError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDeleteUpload(w http.ResponseWriter, r *http.Request) {
+	if !s.checkTus(w, r) {
+		return
+	}
+
+	if err := s.file.DeleteUpload(r.Context(), r.PathValue("id")); err != nil {
+		s.writeUploadError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkTus rejects requests from clients speaking another protocol version
+// and marks the response as a tus response.
+func (s *Server) checkTus(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		s.writeError(w, http.StatusPreconditionFailed, errTusVersion)
+		return false
+	}
+
+	return true
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header: comma separated
+// pairs of a key and a base64 value. Pairs that do not decode are skipped.
+func parseUploadMetadata(header string) map[string]string {
+	meta := make(map[string]string)
+	for pair := range strings.SplitSeq(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		meta[key] = string(decoded)
+	}
+	return meta
+}
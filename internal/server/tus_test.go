@@ -0,0 +1,321 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d6o/homeclip/internal/events"
+	"github.com/d6o/homeclip/internal/filestore"
+)
+
+func tusRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	return req
+}
+
+func TestHandleUploadOptions(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	mux := setupMux(s)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/api/uploads", nil))
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", w.Code)
+	}
+	if got := w.Header().Get("Tus-Version"); got != tusVersion {
+		t.Errorf("expected Tus-Version %q, got %q", tusVersion, got)
+	}
+	if got := w.Header().Get("Tus-Extension"); got != "creation,termination" {
+		t.Errorf("expected creation and termination extensions, got %q", got)
+	}
+	if got := w.Header().Get("Tus-Max-Size"); got != strconv.FormatInt(testLimits.MaxFileSize, 10) {
+		t.Errorf("expected Tus-Max-Size of the file limit, got %q", got)
+	}
+}
+
+func TestHandleCreateUpload(t *testing.T) {
+	fs := &mockFileStore{}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := tusRequest(http.MethodPost, "/api/uploads?ttl=1h", "")
+	req.Header.Set("Upload-Length", "11")
+	// "video.mp4" and "ignored"
+	req.Header.Set("Upload-Metadata", "filename dmlkZW8ubXA0,filetype aWdub3JlZA==")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Location"); got != "/api/uploads/abc" {
+		t.Errorf("expected Location /api/uploads/abc, got %q", got)
+	}
+	if got := w.Header().Get("Tus-Resumable"); got != tusVersion {
+		t.Errorf("expected Tus-Resumable %q, got %q", tusVersion, got)
+	}
+	if fs.upload.Name != "video.mp4" || fs.upload.Length != 11 {
+		t.Errorf("expected video.mp4 of 11 bytes, got %+v", fs.upload)
+	}
+	if fs.uploadOpts.TTL != time.Hour {
+		t.Errorf("expected ttl of 1h, got %v", fs.uploadOpts.TTL)
+	}
+}
+
+func TestHandleCreateUpload_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		length   string
+		metadata string
+		version  string
+		status   int
+	}{
+		{"missing length", "", "filename YS50eHQ=", tusVersion, http.StatusBadRequest},
+		{"negative length", "-1", "filename YS50eHQ=", tusVersion, http.StatusBadRequest},
+		{"missing filename", "5", "filetype dGV4dA==", tusVersion, http.StatusBadRequest},
+		{"wrong version", "5", "filename YS50eHQ=", "0.2.2", http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(&mockTextStore{}, &mockFileStore{})
+			mux := setupMux(s)
+
+			req := tusRequest(http.MethodPost, "/api/uploads", "")
+			req.Header.Set("Tus-Resumable", tt.version)
+			req.Header.Set("Upload-Length", tt.length)
+			req.Header.Set("Upload-Metadata", tt.metadata)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestHandleCreateUpload_TooLarge(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{uploadErr: filestore.ErrTooLarge})
+	mux := setupMux(s)
+
+	req := tusRequest(http.MethodPost, "/api/uploads", "")
+	req.Header.Set("Upload-Length", "999999999999")
+	req.Header.Set("Upload-Metadata", "filename YS50eHQ=")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413, got %d", w.Code)
+	}
+}
+
+func TestHandlePatchUpload_Resume(t *testing.T) {
+	fs := &mockFileStore{upload: &filestore.Upload{ID: "abc", Name: "a.txt", Length: 11}}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	patch := func(offset, body string) *httptest.ResponseRecorder {
+		req := tusRequest(http.MethodPatch, "/api/uploads/abc", body)
+		req.Header.Set("Content-Type", tusContentType)
+		req.Header.Set("Upload-Offset", offset)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := patch("0", "hello ")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", w.Code)
+	}
+	if got := w.Header().Get("Upload-Offset"); got != "6" {
+		t.Errorf("expected offset 6, got %q", got)
+	}
+
+	head := tusRequest(http.MethodHead, "/api/uploads/abc", "")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, head)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("Upload-Offset"); got != "6" {
+		t.Errorf("expected offset 6, got %q", got)
+	}
+	if got := w.Header().Get("Upload-Length"); got != "11" {
+		t.Errorf("expected length 11, got %q", got)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("expected Cache-Control no-store, got %q", got)
+	}
+
+	if w := patch("0", "stale"); w.Code != http.StatusConflict {
+		t.Errorf("expected status 409 for stale offset, got %d", w.Code)
+	}
+
+	if w := patch("6", "world"); w.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", w.Code)
+	}
+	if string(fs.uploadData) != "hello world" {
+		t.Errorf("expected %q, got %q", "hello world", fs.uploadData)
+	}
+}
+
+func TestHandleUploadOffset_AfterCompletion(t *testing.T) {
+	fs, err := filestore.NewStore(t.TempDir(), events.NewBus(16), filestore.Options{})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	create := tusRequest(http.MethodPost, "/api/uploads", "")
+	create.Header.Set("Upload-Length", "5")
+	create.Header.Set("Upload-Metadata", "filename YS50eHQ=")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, create)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+
+	patch := tusRequest(http.MethodPatch, location, "hello")
+	patch.Header.Set("Content-Type", tusContentType)
+	patch.Header.Set("Upload-Offset", "0")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, patch)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A client that lost the response to its last PATCH asks again.
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, tusRequest(http.MethodHead, location, ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 after completion, got %d", w.Code)
+	}
+	if got := w.Header().Get("Upload-Offset"); got != "5" {
+		t.Errorf("expected offset 5, got %q", got)
+	}
+	if got := w.Header().Get("Upload-Length"); got != "5" {
+		t.Errorf("expected length 5, got %q", got)
+	}
+}
+
+func TestHandlePatchUpload_WhileAnotherArrives(t *testing.T) {
+	fs, err := filestore.NewStore(t.TempDir(), events.NewBus(16), filestore.Options{})
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	u, err := fs.CreateUpload(context.Background(), "a.txt", 10, filestore.SaveOptions{})
+	if err != nil {
+		t.Fatalf("CreateUpload failed: %v", err)
+	}
+	location := "/api/uploads/" + u.ID
+
+	patch := func(offset string, body io.Reader) *http.Request {
+		req := httptest.NewRequest(http.MethodPatch, location, body)
+		req.Header.Set("Tus-Resumable", tusVersion)
+		req.Header.Set("Content-Type", tusContentType)
+		req.Header.Set("Upload-Offset", offset)
+		return req
+	}
+
+	// A client whose connection stalled mid-chunk.
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		mux.ServeHTTP(httptest.NewRecorder(), patch("0", pr))
+		close(done)
+	}()
+	pw.Write([]byte("abc"))
+	pw.Write(nil)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, tusRequest(http.MethodHead, location, ""))
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "3" {
+		t.Errorf("expected offset 3 while the chunk arrives, got %d %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, patch("3", strings.NewReader("defghij")))
+	if w.Code != http.StatusLocked {
+		t.Errorf("expected status 423, got %d", w.Code)
+	}
+
+	pw.Close()
+	<-done
+}
+
+func TestHandlePatchUpload_WrongContentType(t *testing.T) {
+	fs := &mockFileStore{upload: &filestore.Upload{ID: "abc", Length: 5}}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	req := tusRequest(http.MethodPatch, "/api/uploads/abc", "hello")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status 415, got %d", w.Code)
+	}
+}
+
+func TestHandlePatchUpload_NotFound(t *testing.T) {
+	s := newTestServer(&mockTextStore{}, &mockFileStore{})
+	mux := setupMux(s)
+
+	req := tusRequest(http.MethodPatch, "/api/uploads/missing", "hello")
+	req.Header.Set("Content-Type", tusContentType)
+	req.Header.Set("Upload-Offset", "0")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestHandleDeleteUpload(t *testing.T) {
+	fs := &mockFileStore{upload: &filestore.Upload{ID: "abc", Length: 5}}
+	s := newTestServer(&mockTextStore{}, fs)
+	mux := setupMux(s)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, tusRequest(http.MethodDelete, "/api/uploads/abc", ""))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, tusRequest(http.MethodHead, "/api/uploads/abc", ""))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 after termination, got %d", w.Code)
+	}
+}
+
+func TestParseUploadMetadata(t *testing.T) {
+	meta := parseUploadMetadata("filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,is_confidential, bad !!!")
+
+	if meta["filename"] != "world_domination_plan.pdf" {
+		t.Errorf("expected decoded filename, got %q", meta["filename"])
+	}
+	if v, ok := meta["is_confidential"]; !ok || v != "" {
+		t.Errorf("expected key without value, got %q, %v", v, ok)
+	}
+	if _, ok := meta["bad"]; ok {
+		t.Error("expected undecodable value to be skipped")
+	}
+}
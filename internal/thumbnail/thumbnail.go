@@ -0,0 +1,129 @@
+// Package thumbnail renders small previews of JPEG, PNG and GIF images using
+// only the standard library.
+package thumbnail
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// maxDecodedBytes bounds the memory a decoded source image may take, so
+// that a large photo or a small file claiming huge dimensions cannot
+// exhaust a small container. It allows a 12 megapixel JPEG.
+const maxDecodedBytes = 48 << 20
+
+var (
+	ErrUnsupported = errors.New("not a JPEG, PNG or GIF image")
+	ErrTooLarge    = errors.New("image dimensions too large for a thumbnail")
+)
+
+// Render decodes the image in r and writes a thumbnail that fits in a
+// size×size square to w. Opaque images become JPEG and the rest PNG; the
+// content type written is returned. Images are never scaled up.
+func Render(w io.Writer, r io.ReadSeeker, size int) (string, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return "", ErrUnsupported
+	}
+	if int64(cfg.Width)*int64(cfg.Height)*bytesPerPixel(cfg.ColorModel) > maxDecodedBytes {
+		return "", ErrTooLarge
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return "", ErrUnsupported
+	}
+
+	dst := scale(src, size)
+
+	if opaque(src) {
+		return "image/jpeg", jpeg.Encode(w, dst, &jpeg.Options{Quality: 80})
+	}
+	return "image/png", png.Encode(w, dst)
+}
+
+// bytesPerPixel is how much memory the decoders use per pixel of an image
+// in model, rounded up.
+func bytesPerPixel(model color.Model) int64 {
+	if _, ok := model.(color.Palette); ok {
+		return 1
+	}
+
+	switch model {
+	case color.GrayModel:
+		return 1
+	case color.Gray16Model:
+		return 2
+	case color.YCbCrModel:
+		return 3
+	case color.RGBA64Model, color.NRGBA64Model:
+		return 8
+	}
+	return 4
+}
+
+func opaque(img image.Image) bool {
+	o, ok := img.(interface{ Opaque() bool })
+	return ok && o.Opaque()
+}
+
+// scale shrinks src to fit in a size×size square by averaging the source
+// pixels that fall into each destination pixel.
+func scale(src image.Image, size int) *image.RGBA64 {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := fit(sw, sh, size)
+
+	dst := image.NewRGBA64(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		y0 := b.Min.Y + y*sh/dh
+		y1 := max(b.Min.Y+(y+1)*sh/dh, y0+1)
+
+		for x := range dw {
+			x0 := b.Min.X + x*sw/dw
+			x1 := max(b.Min.X+(x+1)*sw/dw, x0+1)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
+
+// fit returns the dimensions of a w×h image shrunk to fit in a size×size
+// square, keeping its aspect ratio.
+func fit(w, h, size int) (int, int) {
+	if w <= size && h <= size {
+		return w, h
+	}
+	if w >= h {
+		return size, max(h*size/w, 1)
+	}
+	return max(w*size/h, 1), size
+}
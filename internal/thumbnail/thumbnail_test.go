@@ -0,0 +1,184 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func encode(t *testing.T, img image.Image, format string) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("encode %s: %v", format, err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestRender(t *testing.T) {
+	opaqueImg := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for y := range 400 {
+		for x := range 800 {
+			opaqueImg.Set(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+	transparent := image.NewNRGBA(image.Rect(0, 0, 300, 600))
+
+	tests := []struct {
+		name        string
+		img         image.Image
+		format      string
+		contentType string
+		w, h        int
+	}{
+		{"jpeg", opaqueImg, "jpeg", "image/jpeg", 256, 128},
+		{"opaque png", opaqueImg, "png", "image/jpeg", 256, 128},
+		{"transparent png", transparent, "png", "image/png", 128, 256},
+		{"gif", opaqueImg, "gif", "image/jpeg", 256, 128},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			ct, err := Render(&out, encode(t, tt.img, tt.format), 256)
+			if err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+			if ct != tt.contentType {
+				t.Errorf("expected %s, got %s", tt.contentType, ct)
+			}
+
+			thumb, _, err := image.Decode(&out)
+			if err != nil {
+				t.Fatalf("thumbnail does not decode: %v", err)
+			}
+			if b := thumb.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+				t.Errorf("expected %dx%d, got %dx%d", tt.w, tt.h, b.Dx(), b.Dy())
+			}
+		})
+	}
+}
+
+func TestRender_KeepsColour(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 512, 512))
+	for y := range 512 {
+		for x := range 512 {
+			src.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+
+	var out bytes.Buffer
+	if _, err := Render(&out, encode(t, src, "png"), 64); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	thumb, _, _ := image.Decode(&out)
+	r, g, b, _ := thumb.At(32, 32).RGBA()
+	if r>>8 < 240 || g>>8 > 15 || b>>8 > 15 {
+		t.Errorf("expected red, got %d,%d,%d", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestRender_NoUpscale(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+
+	var out bytes.Buffer
+	if _, err := Render(&out, encode(t, src, "png"), 256); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	cfg, _, _ := image.DecodeConfig(&out)
+	if cfg.Width != 40 || cfg.Height != 20 {
+		t.Errorf("expected 40x20, got %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+func TestRender_Unsupported(t *testing.T) {
+	var out bytes.Buffer
+	_, err := Render(&out, strings.NewReader("%PDF-1.7 not an image"), 256)
+	if err != ErrUnsupported {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+// pngHeader returns the start of a PNG that claims the given dimensions,
+// which is all DecodeConfig reads.
+func pngHeader(width, height uint32, bitDepth, colorType byte) *bytes.Reader {
+	ihdr := binary.BigEndian.AppendUint32([]byte("IHDR"), width)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, height)
+	ihdr = append(ihdr, bitDepth, colorType, 0, 0, 0)
+
+	b := []byte("\x89PNG\r\n\x1a\n")
+	b = binary.BigEndian.AppendUint32(b, uint32(len(ihdr)-4))
+	b = append(b, ihdr...)
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(ihdr))
+	return bytes.NewReader(b)
+}
+
+func TestRender_TooLarge(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height uint32
+		bitDepth      byte
+		colorType     byte
+	}{
+		// 16 million RGBA pixels take 64 MB once decoded.
+		{"rgba", 4000, 4000, 8, 6},
+		// 16-bit pixels take twice as much, so fewer of them fit.
+		{"rgba 16-bit", 3000, 3000, 16, 6},
+		{"huge grayscale", 100_000, 100_000, 8, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			_, err := Render(&out, pngHeader(tt.width, tt.height, tt.bitDepth, tt.colorType), 256)
+			if err != ErrTooLarge {
+				t.Errorf("expected ErrTooLarge, got %v", err)
+			}
+		})
+	}
+}
+
+func TestBytesPerPixel(t *testing.T) {
+	if n := bytesPerPixel(color.Palette{color.Black, color.White}); n != 1 {
+		t.Errorf("expected 1 byte for paletted images, got %d", n)
+	}
+	if n := bytesPerPixel(color.NRGBA64Model); n != 8 {
+		t.Errorf("expected 8 bytes for 16-bit images, got %d", n)
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		w, h, size, wantW, wantH int
+	}{
+		{1000, 500, 100, 100, 50},
+		{500, 1000, 100, 50, 100},
+		{50, 50, 100, 50, 50},
+		{10000, 1, 100, 100, 1},
+	}
+
+	for _, tt := range tests {
+		w, h := fit(tt.w, tt.h, tt.size)
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("fit(%d, %d, %d) = %d, %d; want %d, %d", tt.w, tt.h, tt.size, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}